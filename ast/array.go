@@ -0,0 +1,29 @@
+package ast
+
+import (
+	"bytes"
+	"staq/token"
+	"strings"
+)
+
+type ArrayLiteral struct {
+	Token    token.Token // the '[' token
+	Elements []Expression
+	Rbrack   token.Token // the ']' token
+}
+
+func (al *ArrayLiteral) expressionNode()      {}
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return al.Rbrack.End }
+func (al *ArrayLiteral) String() string {
+	var out bytes.Buffer
+	elements := []string{}
+	for _, el := range al.Elements {
+		elements = append(elements, el.String())
+	}
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+	return out.String()
+}
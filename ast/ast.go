@@ -1,8 +1,15 @@
 package ast
 
+import "staq/token"
+
+// Node is implemented by every node of the AST. Pos and End delimit the
+// source text the node was parsed from: Pos is the position of its first
+// character and End the position immediately after its last one.
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position
+	End() token.Position
 }
 
 type Statement interface {
@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"staq/lexer"
 	"staq/token"
 	"testing"
 )
@@ -26,3 +27,27 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestStringLiteralRoundTrip(t *testing.T) {
+	values := []string{
+		"plain",
+		"año \U0001F44B",
+		"quote \" and backslash \\",
+		"\n\t\r\b\f",
+		"nul \x00 bell \a del \x7f",
+		"zero\u200bwidth, line\u2028separator",
+	}
+
+	for _, value := range values {
+		literal := (&StringLiteral{Value: value}).String()
+
+		tok := lexer.New(literal).NextToken()
+		if tok.Type != token.STRING {
+			t.Errorf("%q printed as %s, which lexes as %s %q", value, literal, tok.Type, tok.Literal)
+			continue
+		}
+		if tok.Literal != value {
+			t.Errorf("%q printed as %s, which lexes as %q", value, literal, tok.Literal)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package ast
+
+import (
+	"staq/decimal"
+	"staq/token"
+)
+
+// DecimalLiteral is a number with a d suffix, such as 1.10d.
+type DecimalLiteral struct {
+	Token token.Token
+	Value decimal.Decimal
+}
+
+func (dl *DecimalLiteral) expressionNode()      {}
+func (dl *DecimalLiteral) TokenLiteral() string { return dl.Token.Literal }
+func (dl *DecimalLiteral) Pos() token.Position  { return dl.Token.Pos }
+func (dl *DecimalLiteral) End() token.Position  { return dl.Token.End }
+func (dl *DecimalLiteral) String() string       { return dl.Token.Literal }
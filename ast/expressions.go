@@ -13,6 +13,8 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position  { return pe.Right.End() }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -31,6 +33,8 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *InfixExpression) End() token.Position  { return ie.Right.End() }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -40,3 +44,100 @@ func (ie *InfixExpression) String() string {
 	out.WriteString(")")
 	return out.String()
 }
+
+type IndexExpression struct {
+	Token    token.Token // the '[' token
+	Left     Expression
+	Index    Expression
+	Rbrack   token.Token // the ']' token
+	Optional bool        // written as a?.[i], null if a is null
+}
+
+func (ie *IndexExpression) expressionNode()      {}
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *IndexExpression) End() token.Position  { return ie.Rbrack.End }
+func (ie *IndexExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(ie.Left.String())
+	if ie.Optional {
+		out.WriteString("?.")
+	}
+	out.WriteString("[")
+	out.WriteString(ie.Index.String())
+	out.WriteString("])")
+	return out.String()
+}
+
+// MemberExpression looks a property up by name, as in config.name, which
+// is the same as config["name"].
+type MemberExpression struct {
+	Token    token.Token // the '.' or '?.' token
+	Object   Expression
+	Property *Identifier
+	Optional bool // written as a?.b, null if a is null
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) Pos() token.Position  { return me.Object.Pos() }
+func (me *MemberExpression) End() token.Position  { return me.Property.End() }
+func (me *MemberExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(me.Object.String())
+	if me.Optional {
+		out.WriteString("?.")
+	} else {
+		out.WriteString(".")
+	}
+	out.WriteString(me.Property.String())
+	out.WriteString(")")
+	return out.String()
+}
+
+// AssignExpression stores Value in Target, which is an Identifier, an
+// IndexExpression or a MemberExpression. Compound operators such as +=
+// keep their own Operator, the evaluator combines the current value of
+// Target with Value before storing the result.
+type AssignExpression struct {
+	Token    token.Token // the assignment operator token
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Target.Pos() }
+func (ae *AssignExpression) End() token.Position  { return ae.Value.End() }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+	return out.String()
+}
+
+// PostfixExpression is x++ or x--. The prefix forms are PrefixExpressions.
+type PostfixExpression struct {
+	Token    token.Token // the '++' or '--' token
+	Left     Expression
+	Operator string
+}
+
+func (pe *PostfixExpression) expressionNode()      {}
+func (pe *PostfixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PostfixExpression) Pos() token.Position  { return pe.Left.Pos() }
+func (pe *PostfixExpression) End() token.Position  { return pe.Token.End }
+func (pe *PostfixExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(pe.Left.String())
+	out.WriteString(pe.Operator)
+	out.WriteString(")")
+	return out.String()
+}
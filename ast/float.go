@@ -9,4 +9,6 @@ type FloatLiteral struct {
 
 func (fl *FloatLiteral) expressionNode()      {}
 func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
@@ -0,0 +1,201 @@
+package ast
+
+import "strings"
+
+// Format prints program as source code with one statement per line, for
+// reading rather than for comparing like String. Statements that are not
+// loops end with a ;, and the statements of blocks go on lines of their
+// own, indented by four spaces per block. Expressions keep the
+// parentheses String puts around operators, so the grouping chosen by the
+// parser stays visible.
+func Format(program *Program) string {
+	var f formatter
+	f.statements(program.Statements)
+	return f.out.String()
+}
+
+type formatter struct {
+	out    strings.Builder
+	indent int
+}
+
+func (f *formatter) statements(statements []Statement) {
+	for _, s := range statements {
+		f.out.WriteString(strings.Repeat("    ", f.indent))
+		f.statement(s)
+		f.out.WriteString("\n")
+	}
+}
+
+func (f *formatter) statement(s Statement) {
+	switch s := s.(type) {
+	case *LetStatement:
+		f.out.WriteString("let " + s.Name.String() + " = ")
+		f.expression(s.Value)
+		f.out.WriteString(";")
+	case *ReturnStatement:
+		f.out.WriteString("return")
+		if s.ReturnValue != nil {
+			f.out.WriteString(" ")
+			f.expression(s.ReturnValue)
+		}
+		f.out.WriteString(";")
+	case *ExpressionStatement:
+		f.expression(s.Expression)
+		f.out.WriteString(";")
+	case *WhileStatement:
+		f.out.WriteString("while ")
+		f.condition(s.Condition)
+		f.block(s.Body)
+	case *ForStatement:
+		f.out.WriteString("for (")
+		if s.Init != nil {
+			f.statement(s.Init)
+		} else {
+			f.out.WriteString(";")
+		}
+		f.out.WriteString(" ")
+		f.expression(s.Condition)
+		f.out.WriteString("; ")
+		f.expression(s.Post)
+		f.out.WriteString(") ")
+		f.block(s.Body)
+	case *ForInStatement:
+		f.out.WriteString("for (" + s.Variable.String() + " in ")
+		f.expression(s.Iterable)
+		f.out.WriteString(") ")
+		f.block(s.Body)
+	case *BlockStatement:
+		f.block(s)
+	default:
+		f.out.WriteString(s.String())
+	}
+}
+
+func (f *formatter) block(b *BlockStatement) {
+	if len(b.Statements) == 0 {
+		f.out.WriteString("{ }")
+		return
+	}
+	f.out.WriteString("{\n")
+	f.indent++
+	f.statements(b.Statements)
+	f.indent--
+	f.out.WriteString(strings.Repeat("    ", f.indent) + "}")
+}
+
+// expression prints e like its String method, except for the blocks of
+// the function literals and if expressions in it. A missing expression
+// prints nothing.
+func (f *formatter) expression(e Expression) {
+	switch e := e.(type) {
+	case nil:
+	case *PrefixExpression:
+		f.out.WriteString("(" + e.Operator)
+		f.expression(e.Right)
+		f.out.WriteString(")")
+	case *InfixExpression:
+		f.out.WriteString("(")
+		f.expression(e.Left)
+		f.out.WriteString(" " + e.Operator + " ")
+		f.expression(e.Right)
+		f.out.WriteString(")")
+	case *AssignExpression:
+		f.out.WriteString("(")
+		f.expression(e.Target)
+		f.out.WriteString(" " + e.Operator + " ")
+		f.expression(e.Value)
+		f.out.WriteString(")")
+	case *PostfixExpression:
+		f.out.WriteString("(")
+		f.expression(e.Left)
+		f.out.WriteString(e.Operator + ")")
+	case *IndexExpression:
+		f.out.WriteString("(")
+		f.expression(e.Left)
+		if e.Optional {
+			f.out.WriteString("?.")
+		}
+		f.out.WriteString("[")
+		f.expression(e.Index)
+		f.out.WriteString("])")
+	case *MemberExpression:
+		f.out.WriteString("(")
+		f.expression(e.Object)
+		if e.Optional {
+			f.out.WriteString("?.")
+		} else {
+			f.out.WriteString(".")
+		}
+		f.out.WriteString(e.Property.String() + ")")
+	case *CallExpression:
+		f.expression(e.Function)
+		f.out.WriteString("(")
+		f.expressions(e.Arguments)
+		f.out.WriteString(")")
+	case *ArrayLiteral:
+		f.out.WriteString("[")
+		f.expressions(e.Elements)
+		f.out.WriteString("]")
+	case *HashLiteral:
+		f.out.WriteString("{")
+		for i, pair := range e.Pairs {
+			if i > 0 {
+				f.out.WriteString(", ")
+			}
+			f.expression(pair.Key)
+			f.out.WriteString(": ")
+			f.expression(pair.Value)
+		}
+		f.out.WriteString("}")
+	case *FunctionLiteral:
+		params := make([]string, len(e.Parameters))
+		for i, p := range e.Parameters {
+			params[i] = p.String()
+		}
+		f.out.WriteString(e.TokenLiteral() + "(" + strings.Join(params, ", ") + ") ")
+		f.block(e.Body)
+	case *IfExpression:
+		f.out.WriteString("if ")
+		f.condition(e.Condition)
+		f.block(e.Consequence)
+		if e.Alternative != nil {
+			f.out.WriteString(" else ")
+			f.block(e.Alternative)
+		}
+	case *IntegerLiteral, *FloatLiteral, *DecimalLiteral:
+		// The optimizer folds -2 into a literal of its own, which must
+		// print in parentheses so that (-2) ** x does not read -(2 ** x).
+		if s := e.String(); strings.HasPrefix(s, "-") {
+			f.out.WriteString("(" + s + ")")
+		} else {
+			f.out.WriteString(s)
+		}
+	default:
+		f.out.WriteString(e.String())
+	}
+}
+
+// condition prints the condition of an if expression or a while loop in
+// parentheses, unless the expression already prints them itself.
+func (f *formatter) condition(e Expression) {
+	switch e.(type) {
+	case *PrefixExpression, *InfixExpression, *AssignExpression, *PostfixExpression,
+		*IndexExpression, *MemberExpression:
+		f.expression(e)
+	default:
+		f.out.WriteString("(")
+		f.expression(e)
+		f.out.WriteString(")")
+	}
+	f.out.WriteString(" ")
+}
+
+func (f *formatter) expressions(list []Expression) {
+	for i, e := range list {
+		if i > 0 {
+			f.out.WriteString(", ")
+		}
+		f.expression(e)
+	}
+}
@@ -14,6 +14,8 @@ type FunctionLiteral struct {
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position  { return fl.Body.End() }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -32,10 +34,13 @@ type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
+	Rparen    token.Token // The ')' token
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce *CallExpression) End() token.Position  { return ce.Rparen.End }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -0,0 +1,37 @@
+package ast
+
+import (
+	"bytes"
+	"staq/token"
+	"strings"
+)
+
+// HashPair is a single key: value entry of a hash literal.
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+// HashLiteral keeps its pairs in source order so that printing the AST and
+// evaluating the literal are deterministic.
+type HashLiteral struct {
+	Token  token.Token // the '{' token
+	Pairs  []HashPair
+	Rbrace token.Token // the '}' token
+}
+
+func (hl *HashLiteral) expressionNode()      {}
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position  { return hl.Rbrace.End }
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, pair.Key.String()+": "+pair.Value.String())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
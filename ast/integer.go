@@ -1,12 +1,18 @@
 package ast
 
-import "staq/token"
+import (
+	"math/big"
+	"staq/token"
+)
 
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // set instead of Value if the literal does not fit in an int64
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
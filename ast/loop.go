@@ -0,0 +1,116 @@
+package ast
+
+import (
+	"bytes"
+	"staq/token"
+	"strings"
+)
+
+// WhileStatement runs Body for as long as Condition is truthy.
+type WhileStatement struct {
+	Token     token.Token // the 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position  { return ws.Body.End() }
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while (")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(") { ")
+	out.WriteString(ws.Body.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// ForStatement is a C-style for loop. Init, Condition and Post are all
+// optional, a missing Condition loops forever.
+type ForStatement struct {
+	Token     token.Token // the 'for' token
+	Init      Statement   // a let or expression statement
+	Condition Expression
+	Post      Expression
+	Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Init != nil {
+		out.WriteString(strings.TrimSuffix(fs.Init.String(), ";"))
+	}
+	out.WriteString("; ")
+	if fs.Condition != nil {
+		out.WriteString(fs.Condition.String())
+	}
+	out.WriteString("; ")
+	if fs.Post != nil {
+		out.WriteString(fs.Post.String())
+	}
+	out.WriteString(") { ")
+	out.WriteString(fs.Body.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// ForInStatement runs Body once for every element of an array, character
+// of a string or key of a hash, bound to Variable.
+type ForInStatement struct {
+	Token    token.Token // the 'for' token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForInStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") { ")
+	out.WriteString(fs.Body.String())
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// BreakStatement leaves the innermost enclosing loop.
+type BreakStatement struct {
+	Token token.Token // the 'break' token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+// ContinueStatement skips to the next iteration of the innermost enclosing
+// loop.
+type ContinueStatement struct {
+	Token token.Token // the 'continue' token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return "continue;" }
@@ -0,0 +1,13 @@
+package ast
+
+import "staq/token"
+
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (nl *NullLiteral) expressionNode()      {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) Pos() token.Position  { return nl.Token.Pos }
+func (nl *NullLiteral) End() token.Position  { return nl.Token.End }
+func (nl *NullLiteral) String() string       { return nl.Token.Literal }
@@ -1,6 +1,9 @@
 package ast
 
-import "bytes"
+import (
+	"bytes"
+	"staq/token"
+)
 
 // Program is the root node of every AST our parser produces.
 // Every valid StaQ program is a series of statements.
@@ -16,6 +19,20 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -13,6 +13,13 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	return ls.Name.End()
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -36,6 +43,13 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -57,6 +71,18 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position {
+	if es.Expression != nil {
+		return es.Expression.Pos()
+	}
+	return es.Token.Pos
+}
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -65,12 +91,15 @@ func (es *ExpressionStatement) String() string {
 }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // the '{' token
 	Statements []Statement
+	Rbrace     token.Token // the '}' token
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position  { return bs.Rbrace.End }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -0,0 +1,53 @@
+package ast
+
+import (
+	"fmt"
+	"staq/token"
+	"strings"
+	"unicode"
+)
+
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode()      {}
+func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
+
+// String re-quotes the value using the escape sequences understood by the
+// lexer, so that lexing the output yields the same value again. Control
+// characters and other runes that would not print are written as
+// \u{...} escapes.
+func (sl *StringLiteral) String() string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for _, r := range sl.Value {
+		switch r {
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\b':
+			out.WriteString(`\b`)
+		case '\f':
+			out.WriteString(`\f`)
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		default:
+			if unicode.IsPrint(r) {
+				out.WriteRune(r)
+			} else {
+				fmt.Fprintf(&out, `\u{%X}`, r)
+			}
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
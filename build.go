@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"staq/sqc"
+	"strings"
+)
+
+// build implements "staq build [-no-optimize] file.sq [-o file.sqc]",
+// which compiles a source file to a .sqc file, and returns the exit
+// status.
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	output := flags.String("o", "", "the .sqc file to write, by default the source file with a .sqc extension")
+	noOptimize := flags.Bool("no-optimize", false, "compile the program as written, without optimizing it")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: staq build [-no-optimize] file.sq [-o file.sqc]")
+		flags.PrintDefaults()
+	}
+
+	// Allow the flags both before and after the source file.
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+	flags.Parse(flags.Args()[1:])
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".sqc"
+	}
+
+	program, err := parseFile(path, !*noOptimize)
+	if err != nil {
+		return fail(err)
+	}
+	if program == nil {
+		return 1
+	}
+
+	bytecode, err := compile(program)
+	if err != nil {
+		return fail(fmt.Errorf("%s: %w", path, err))
+	}
+	data, err := sqc.Marshal(bytecode)
+	if err != nil {
+		return fail(fmt.Errorf("%s: %w", path, err))
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return fail(err)
+	}
+	return 0
+}
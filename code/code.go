@@ -0,0 +1,320 @@
+// Package code defines the bytecode that the compiler emits: opcodes, how
+// their operands are encoded and how to turn instructions back into text.
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions is a sequence of encoded instructions. Each one is an
+// Opcode followed by its operands, big-endian, with the widths given by
+// the opcode's Definition.
+type Instructions []byte
+
+// String disassembles ins, one instruction per line, prefixed with its
+// offset.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+// Unless noted otherwise, operators pop their operands off the stack and
+// push their result.
+const (
+	// OpConstant pushes the constant with the given index.
+	OpConstant Opcode = iota
+	// OpPop discards the top of the stack, OpDup pushes it again and
+	// OpDup2 pushes the two topmost values again, in the same order.
+	OpPop
+	OpDup
+	OpDup2
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	// Infix operators, in the order of their operands: for OpSub the
+	// left operand is pushed first.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpFloorDiv
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	OpEqual
+	OpNotEqual
+	OpLessThan
+	OpLessEqual
+	OpGreaterThan
+	OpGreaterEqual
+
+	// Prefix operators. OpIncrement and OpDecrement add or subtract one
+	// from a number; their operand is 1 for the postfix forms and only
+	// changes the error message for values that are not numbers.
+	OpMinus
+	OpBang
+	OpBitNot
+	OpIncrement
+	OpDecrement
+
+	// Jumps take an absolute offset into the current instructions.
+	// OpJumpNotTruthy and OpJumpTruthy pop the value they test.
+	// OpJumpNull jumps if the top of the stack is null and
+	// OpJumpNotNull if it is not, both leaving it there, except that
+	// OpJumpNotNull pops a null it does not jump on.
+	OpJump
+	OpJumpNotTruthy
+	OpJumpTruthy
+	OpJumpNull
+	OpJumpNotNull
+
+	// OpSetGlobal binds a global, for let statements, and OpAssignGlobal
+	// rebinds one, failing if it was never bound.
+	OpGetGlobal
+	OpSetGlobal
+	OpAssignGlobal
+
+	// Locals that no closure captures live directly in their slot of the
+	// call frame. Captured ones live in a cell instead, so that the
+	// closures and the frame share them: OpDefineLocalCell puts a new
+	// cell holding the popped value into the slot, and the other two
+	// read and write the value in the cell.
+	OpGetLocal
+	OpSetLocal
+	OpGetLocalCell
+	OpSetLocalCell
+	OpDefineLocalCell
+
+	// OpGetFree and OpSetFree read and write a free variable of the
+	// current closure.
+	OpGetFree
+	OpSetFree
+
+	// OpArray builds an array from the given number of values and OpHash
+	// a hash from the given number of key/value pairs.
+	OpArray
+	OpHash
+
+	// OpIndex pops an index and the value to index. OpSetIndex pops a
+	// value, an index and the value to index, stores the value and
+	// pushes it again. OpMember and OpSetMember are the same for the
+	// member whose name is the given constant.
+	OpIndex
+	OpSetIndex
+	OpMember
+	OpSetMember
+
+	// OpCall calls the function below the given number of arguments.
+	// OpReturnValue returns the top of the stack and OpReturn null.
+	OpCall
+	OpReturnValue
+	OpReturn
+
+	// OpClosure turns the function constant with the given index into a
+	// closure over the given number of cells on top of the stack, which
+	// OpCaptureLocal and OpCaptureFree push.
+	OpClosure
+	OpCaptureLocal
+	OpCaptureFree
+
+	// OpIter replaces the value on top of the stack with an iterator
+	// over it. OpIterNext pushes the next element of the iterator on
+	// top of the stack, or jumps if there are no more elements.
+	OpIter
+	OpIterNext
+)
+
+// Definition describes an opcode: its readable name and the width in
+// bytes of each of its operands.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{}},
+	OpDup2:     {"OpDup2", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpFloorDiv:     {"OpFloorDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpPow:          {"OpPow", []int{}},
+	OpBitAnd:       {"OpBitAnd", []int{}},
+	OpBitOr:        {"OpBitOr", []int{}},
+	OpBitXor:       {"OpBitXor", []int{}},
+	OpShl:          {"OpShl", []int{}},
+	OpShr:          {"OpShr", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+
+	OpMinus:     {"OpMinus", []int{}},
+	OpBang:      {"OpBang", []int{}},
+	OpBitNot:    {"OpBitNot", []int{}},
+	OpIncrement: {"OpIncrement", []int{1}},
+	OpDecrement: {"OpDecrement", []int{1}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJumpTruthy:    {"OpJumpTruthy", []int{2}},
+	OpJumpNull:      {"OpJumpNull", []int{2}},
+	OpJumpNotNull:   {"OpJumpNotNull", []int{2}},
+
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpAssignGlobal: {"OpAssignGlobal", []int{2}},
+
+	OpGetLocal:        {"OpGetLocal", []int{2}},
+	OpSetLocal:        {"OpSetLocal", []int{2}},
+	OpGetLocalCell:    {"OpGetLocalCell", []int{2}},
+	OpSetLocalCell:    {"OpSetLocalCell", []int{2}},
+	OpDefineLocalCell: {"OpDefineLocalCell", []int{2}},
+
+	OpGetFree: {"OpGetFree", []int{1}},
+	OpSetFree: {"OpSetFree", []int{1}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+
+	OpIndex:     {"OpIndex", []int{}},
+	OpSetIndex:  {"OpSetIndex", []int{}},
+	OpMember:    {"OpMember", []int{2}},
+	OpSetMember: {"OpSetMember", []int{2}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+
+	OpClosure:      {"OpClosure", []int{2, 1}},
+	OpCaptureLocal: {"OpCaptureLocal", []int{2}},
+	OpCaptureFree:  {"OpCaptureFree", []int{1}},
+
+	OpIter:     {"OpIter", []int{}},
+	OpIterNext: {"OpIterNext", []int{2}},
+}
+
+// Lookup returns the definition of op.
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes op and its operands as a single instruction. It returns
+// an empty instruction for undefined opcodes.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction described by def
+// from ins, which starts right after the opcode. It returns them along
+// with the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
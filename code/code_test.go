@@ -0,0 +1,133 @@
+package code
+
+import "testing"
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 0, 255}},
+		{OpIncrement, []int{1}, []byte{byte(OpIncrement), 1}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if len(instruction) != len(tt.expected) {
+			t.Errorf("instruction has wrong length. want=%d, got=%d",
+				len(tt.expected), len(instruction))
+		}
+
+		for i, b := range tt.expected {
+			if instruction[i] != tt.expected[i] {
+				t.Errorf("wrong byte at pos %d. want=%d, got=%d",
+					i, b, instruction[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+		Make(OpIncrement, 1),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0004 OpConstant 2
+0007 OpConstant 65535
+0010 OpClosure 65535 255
+0014 OpIncrement 1
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetFree, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestEveryOpcodeIsDefined(t *testing.T) {
+	for op := OpConstant; op <= OpIterNext; op++ {
+		if _, err := Lookup(byte(op)); err != nil {
+			t.Errorf("opcode %d has no definition", op)
+		}
+	}
+}
+
+func TestLineTable(t *testing.T) {
+	var table LineTable
+	table = table.Add(0, 1)
+	table = table.Add(3, 1)
+	table = table.Add(4, 2)
+	table = table.Add(7, 4)
+	table = table.Add(7, 3)
+
+	expected := LineTable{{0, 1}, {4, 2}, {7, 3}}
+	if len(table) != len(expected) {
+		t.Fatalf("wrong table. want=%v, got=%v", expected, table)
+	}
+	for i, entry := range expected {
+		if table[i] != entry {
+			t.Fatalf("wrong table. want=%v, got=%v", expected, table)
+		}
+	}
+
+	lines := map[int]int{0: 1, 3: 1, 4: 2, 6: 2, 7: 3, 100: 3}
+	for offset, line := range lines {
+		if got := table.Line(offset); got != line {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", offset, line, got)
+		}
+	}
+
+	table = table.Truncate(4)
+	if len(table) != 1 || table.Line(10) != 1 {
+		t.Errorf("wrong table after truncating. got=%v", table)
+	}
+}
@@ -0,0 +1,71 @@
+package code
+
+import "sort"
+
+// LineEntry says that the instructions from Offset on, up to the next
+// entry, were compiled from source line Line.
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+// LineTable maps the offsets of instructions to the source lines they
+// were compiled from. Its entries are sorted by offset, and only start
+// where the line changes.
+type LineTable []LineEntry
+
+// Add records that the instruction at offset was compiled from line. It
+// must be called with increasing offsets; an entry already recorded at
+// offset is replaced.
+func (t LineTable) Add(offset, line int) LineTable {
+	if n := len(t); n > 0 && t[n-1].Offset == offset {
+		t = t[:n-1]
+	}
+	if n := len(t); n > 0 && t[n-1].Line == line {
+		return t
+	}
+	return append(t, LineEntry{Offset: offset, Line: line})
+}
+
+// Truncate drops the entries of the instructions from offset on, after
+// they were removed.
+func (t LineTable) Truncate(offset int) LineTable {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset >= offset })
+	return t[:i]
+}
+
+// Line returns the source line of the instruction at offset, or 0 if it
+// is not known.
+func (t LineTable) Line(offset int) int {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return 0
+	}
+	return t[i-1].Line
+}
+
+// RuntimeError is an error of a machine running bytecode, raised by an
+// instruction compiled from source line Line. Its message is the message
+// of Err, the same the evaluator gives, so callers that want to show the
+// line add it themselves.
+type RuntimeError struct {
+	Err  error
+	Line int
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// AtLine returns err as a RuntimeError at line, or err itself if the line
+// is not known.
+func AtLine(err error, line int) error {
+	if line == 0 {
+		return err
+	}
+	return &RuntimeError{Err: err, Line: line}
+}
@@ -0,0 +1,1253 @@
+// Package compiler turns the AST of a StaQ program into bytecode for a
+// virtual machine. The bytecode has the same semantics as the evaluator:
+// scoping, closures, loops and short-circuiting operators all behave the
+// same way, and the operators themselves are left to the machine.
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"staq/ast"
+	"staq/code"
+	"staq/object"
+	"strconv"
+	"strings"
+)
+
+// Compiler compiles one program, or one REPL line at a time when created
+// with NewWithState.
+type Compiler struct {
+	constants []object.Object
+	// literals indexes the constants of literal values, which are added
+	// only once, see addConstant.
+	literals map[literalKey]int
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// line is the source line of the node being compiled, recorded in
+	// the line tables of the instructions it emits.
+	line int
+
+	// jumpErr reports the first jump whose target does not fit in its
+	// operand, see checkJump.
+	jumpErr error
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function being compiled,
+// or of the main program.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lines               code.LineTable
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	locals map[int]*local
+	loops  []*loop
+
+	// depth is the number of values on the stack of the frame when the
+	// last instruction has run, see stackEffect.
+	depth int
+}
+
+// local tracks how a local slot of the frame is used. Until a closure
+// captures it, a local is read and written with OpGetLocal and OpSetLocal,
+// and the positions of those instructions are kept so they can be turned
+// into their cell counterparts once it is.
+type local struct {
+	captured bool
+	uses     []localUse
+	// pending is set while the value of the local's let statement is
+	// being compiled.
+	pending bool
+}
+
+type localUse struct {
+	position int
+	define   bool // a let statement rather than an assignment
+}
+
+// loop collects the jumps of the break and continue statements of a loop
+// until their targets are known. depth is the depth of the stack at both
+// targets: a break or continue statement in the middle of an expression
+// first pops the operands computed so far.
+type loop struct {
+	breaks    []int
+	continues []int
+	depth     int
+}
+
+// Bytecode is the compiled main program. NumLocals is the number of local
+// slots of the main program's frame, used by blocks at the top level,
+// Globals holds the names of all globals by slot, for error messages, and
+// Lines maps the main program's instructions to source lines.
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	NumLocals    int
+	Globals      []string
+	Lines        code.LineTable
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"~/": code.OpFloorDiv,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShl,
+	">>": code.OpShr,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"<":  code.OpLessThan,
+	"<=": code.OpLessEqual,
+	">":  code.OpGreaterThan,
+	">=": code.OpGreaterEqual,
+}
+
+var prefixOpcodes = map[string]code.Opcode{
+	"-": code.OpMinus,
+	"!": code.OpBang,
+	"~": code.OpBitNot,
+}
+
+// New returns a compiler with an empty global scope.
+func New() *Compiler {
+	mainScope := CompilationScope{locals: make(map[int]*local)}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		literals:    make(map[literalKey]int),
+		symbolTable: NewSymbolTable(),
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState returns a compiler that keeps adding to the globals and
+// constants of previous compilations, as the REPL does for every line.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	for i, obj := range constants {
+		if key, ok := keyOf(obj); ok {
+			compiler.literals[key] = i
+		}
+	}
+	return compiler
+}
+
+// Compile compiles node and everything below it into the current scope.
+func (c *Compiler) Compile(node ast.Node) error {
+	if pos := node.Pos(); pos.IsValid() && pos.Line != c.line {
+		defer func(line int) { c.line = line }(c.line)
+		c.line = pos.Line
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		if err := c.compileStatements(node.Statements); err != nil {
+			return err
+		}
+		if err := c.checkConstants(); err != nil {
+			return err
+		}
+		return c.jumpErr
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		c.enterBlock()
+		defer c.leaveBlock()
+		c.declareAhead(node.Statements)
+		return c.compileStatements(node.Statements)
+
+	case *ast.LetStatement:
+		return c.compileLetStatement(node)
+
+	case *ast.ReturnStatement:
+		if node.ReturnValue == nil {
+			c.emit(code.OpNull)
+		} else if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+
+	case *ast.ForStatement:
+		return c.compileForStatement(node)
+
+	case *ast.ForInStatement:
+		return c.compileForInStatement(node)
+
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		loop.breaks = append(loop.breaks, c.emitBranch(loop))
+
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		loop.continues = append(loop.continues, c.emitBranch(loop))
+
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			c.emit(code.OpConstant, c.addConstant(&object.BigInteger{Value: node.Big}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+		}
+
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+
+	case *ast.DecimalLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Decimal{Value: node.Value}))
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+
+	case *ast.PrefixExpression:
+		if node.Operator == "++" || node.Operator == "--" {
+			return c.compileIncrement(node.Right, node.Operator, false)
+		}
+		op, ok := prefixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+
+	case *ast.PostfixExpression:
+		return c.compileIncrement(node.Left, node.Operator, true)
+
+	case *ast.InfixExpression:
+		switch node.Operator {
+		case "&&", "||", "??":
+			return c.compileShortCircuit(node)
+		}
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.Identifier:
+		c.loadSymbol(c.resolve(node.Value))
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		// Pairs are evaluated in source order, like in the evaluator.
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs))
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		skip := c.emitOptionalChain(node.Optional)
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+		c.patchOptionalChain(skip)
+
+	case *ast.MemberExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		skip := c.emitOptionalChain(node.Optional)
+		c.emit(code.OpMember, c.addConstant(&object.String{Value: node.Property.Value}))
+		c.patchOptionalChain(skip)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	for _, s := range statements {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileBlockValue compiles a block whose value is used, like the
+// branches of an if expression: the value of its last statement if that
+// is an expression statement, and null otherwise.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+	c.declareAhead(block.Statements)
+
+	if err := c.compileStatements(block.Statements); err != nil {
+		return err
+	}
+	if endsWithExpression(block) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// declareAhead prepares the locals that let statements of a block declare
+// after function literals that may refer to them, as in
+//
+//	let f = fn() { x }; let x = 1; f()
+//
+// where, like in the evaluator, f sees x once its let statement has run.
+// Each of these locals gets its cell when the block starts, for the
+// closures and the let statement to share, and stays pending until then.
+func (c *Compiler) declareAhead(statements []ast.Statement) {
+	if c.symbolTable.Outer == nil {
+		return
+	}
+
+	mentioned := make(map[string]bool)
+	for _, s := range statements {
+		if let, ok := s.(*ast.LetStatement); ok {
+			name := let.Name.Value
+			if mentioned[name] && !c.symbolTable.Defines(name) {
+				symbol := c.symbolTable.DeclarePending(name)
+				c.emit(code.OpNull)
+				c.emit(code.OpDefineLocalCell, symbol.Index)
+				c.local(symbol.Index).captured = true
+			}
+		}
+		functionNames(s, false, mentioned)
+	}
+}
+
+// functionNames adds to names the identifiers node refers to from inside
+// function literals, including names the function literals bind
+// themselves. inFunction is set below a function literal.
+func functionNames(node ast.Node, inFunction bool, names map[string]bool) {
+	if node == nil {
+		return
+	}
+
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if inFunction {
+			names[node.Value] = true
+		}
+	case *ast.FunctionLiteral:
+		functionNames(node.Body, true, names)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			functionNames(s, inFunction, names)
+		}
+	case *ast.ExpressionStatement:
+		functionNames(node.Expression, inFunction, names)
+	case *ast.LetStatement:
+		functionNames(node.Value, inFunction, names)
+	case *ast.ReturnStatement:
+		functionNames(node.ReturnValue, inFunction, names)
+	case *ast.WhileStatement:
+		functionNames(node.Condition, inFunction, names)
+		functionNames(node.Body, inFunction, names)
+	case *ast.ForStatement:
+		functionNames(node.Init, inFunction, names)
+		functionNames(node.Condition, inFunction, names)
+		functionNames(node.Post, inFunction, names)
+		functionNames(node.Body, inFunction, names)
+	case *ast.ForInStatement:
+		functionNames(node.Iterable, inFunction, names)
+		functionNames(node.Body, inFunction, names)
+	case *ast.PrefixExpression:
+		functionNames(node.Right, inFunction, names)
+	case *ast.PostfixExpression:
+		functionNames(node.Left, inFunction, names)
+	case *ast.InfixExpression:
+		functionNames(node.Left, inFunction, names)
+		functionNames(node.Right, inFunction, names)
+	case *ast.IfExpression:
+		functionNames(node.Condition, inFunction, names)
+		functionNames(node.Consequence, inFunction, names)
+		if node.Alternative != nil {
+			functionNames(node.Alternative, inFunction, names)
+		}
+	case *ast.AssignExpression:
+		functionNames(node.Target, inFunction, names)
+		functionNames(node.Value, inFunction, names)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			functionNames(el, inFunction, names)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			functionNames(pair.Key, inFunction, names)
+			functionNames(pair.Value, inFunction, names)
+		}
+	case *ast.IndexExpression:
+		functionNames(node.Left, inFunction, names)
+		functionNames(node.Index, inFunction, names)
+	case *ast.MemberExpression:
+		functionNames(node.Object, inFunction, names)
+	case *ast.CallExpression:
+		functionNames(node.Function, inFunction, names)
+		for _, a := range node.Arguments {
+			functionNames(a, inFunction, names)
+		}
+	}
+}
+
+func endsWithExpression(block *ast.BlockStatement) bool {
+	if len(block.Statements) == 0 {
+		return false
+	}
+	_, ok := block.Statements[len(block.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// compileLetStatement binds a global before compiling the value, since
+// globals are looked up by slot at run time just like the evaluator looks
+// them up by name. A new local only becomes visible once its value is
+// compiled, except to the function literals in it, see DefinePending.
+func (c *Compiler) compileLetStatement(node *ast.LetStatement) error {
+	name := node.Name.Value
+
+	if c.symbolTable.Outer == nil {
+		symbol := c.symbolTable.Define(name)
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.storeSymbol(symbol, true)
+		return nil
+	}
+
+	symbol, fresh := c.symbolTable.DefinePending(name)
+	if !fresh {
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.storeSymbol(symbol, false)
+		return nil
+	}
+
+	l := c.local(symbol.Index)
+	l.pending = true
+	err := c.Compile(node.Value)
+	l.pending = false
+	c.symbolTable.Define(name)
+	if err != nil {
+		return err
+	}
+
+	if l.captured {
+		// A function literal in the value captured the new local, which
+		// created its cell already.
+		c.emit(code.OpSetLocalCell, symbol.Index)
+	} else {
+		c.storeSymbol(symbol, true)
+	}
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+	depth := c.scopes[c.scopeIndex].depth
+
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.scopes[c.scopeIndex].depth = depth
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	c.scopes[c.scopeIndex].depth = depth + 1
+	return nil
+}
+
+// compileShortCircuit compiles && and ||, which always give a boolean,
+// and ??, which gives the left value unless it is null. The right side is
+// only evaluated when the left one does not decide the result.
+func (c *Compiler) compileShortCircuit(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	if node.Operator == "??" {
+		jumpPos := c.emit(code.OpJumpNotNull, 9999)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	// Both sides jump to the same place when they decide the result.
+	jump, decided, undecided := code.OpJumpNotTruthy, code.OpFalse, code.OpTrue
+	if node.Operator == "||" {
+		jump, decided, undecided = code.OpJumpTruthy, code.OpTrue, code.OpFalse
+	}
+
+	leftJump := c.emit(jump, 9999)
+	depth := c.scopes[c.scopeIndex].depth
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	rightJump := c.emit(jump, 9999)
+	c.emit(undecided)
+	endJump := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(leftJump, len(c.currentInstructions()))
+	c.changeOperand(rightJump, len(c.currentInstructions()))
+	c.scopes[c.scopeIndex].depth = depth
+	c.emit(decided)
+	c.changeOperand(endJump, len(c.currentInstructions()))
+	return nil
+}
+
+// emitOptionalChain skips the rest of a ?. link if its left side is null,
+// leaving the null as the result. It returns the position of the jump, or
+// -1 for a plain link.
+func (c *Compiler) emitOptionalChain(optional bool) int {
+	if !optional {
+		return -1
+	}
+	return c.emit(code.OpJumpNull, 9999)
+}
+
+func (c *Compiler) patchOptionalChain(pos int) {
+	if pos >= 0 {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+}
+
+// compileAssignExpression evaluates the parts of the target, then its
+// current value for compound assignments, then the value, and stores it.
+// Like in the evaluator, the stored value is the result.
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	var op code.Opcode
+	operator := strings.TrimSuffix(node.Operator, "=")
+	if operator != "" {
+		var ok bool
+		if op, ok = infixOpcodes[operator]; !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	}
+
+	return c.updateTarget(node.Target, operator != "", func() error {
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if operator != "" {
+			c.emit(op)
+		}
+		return nil
+	})
+}
+
+// compileIncrement compiles ++ and --. The prefix forms evaluate to the
+// updated value, the postfix forms to the value before the update.
+func (c *Compiler) compileIncrement(target ast.Expression, operator string, postfix bool) error {
+	op := code.OpIncrement
+	if operator == "--" {
+		op = code.OpDecrement
+	}
+	flag := 0
+	if postfix {
+		flag = 1
+	}
+
+	if !postfix {
+		return c.updateTarget(target, true, func() error {
+			c.emit(op, flag)
+			return nil
+		})
+	}
+
+	// The old value is kept aside while the new one is stored. Nothing
+	// else is kept aside in between, so every postfix expression of the
+	// frame can use the same slot.
+	temp := c.symbolTable.DefineTemp()
+	err := c.updateTarget(target, true, func() error {
+		c.emit(code.OpDup)
+		c.emit(code.OpSetLocal, temp.Index)
+		c.emit(op, flag)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	c.emit(code.OpGetLocal, temp.Index)
+	return nil
+}
+
+// updateTarget compiles the store of a new value into target, an
+// identifier, index expression or member expression, leaving the stored
+// value on the stack. If read is set, the current value of the target is
+// pushed before update, which must leave the new value on the stack.
+func (c *Compiler) updateTarget(target ast.Expression, read bool, update func() error) error {
+	switch target := target.(type) {
+	case *ast.Identifier:
+		symbol := c.resolve(target.Value)
+		if read {
+			c.loadSymbol(symbol)
+		}
+		if err := update(); err != nil {
+			return err
+		}
+		c.emit(code.OpDup)
+		c.storeSymbol(symbol, false)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if read {
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndex)
+		}
+		if err := update(); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+
+	case *ast.MemberExpression:
+		if err := c.Compile(target.Object); err != nil {
+			return err
+		}
+		name := c.addConstant(&object.String{Value: target.Property.Value})
+		if read {
+			c.emit(code.OpDup)
+			c.emit(code.OpMember, name)
+		}
+		if err := update(); err != nil {
+			return err
+		}
+		c.emit(code.OpSetMember, name)
+
+	default:
+		return fmt.Errorf("cannot assign to %s", target.String())
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	exit := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileLoopBody(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	c.changeOperand(exit, len(c.currentInstructions()))
+	c.leaveLoop(len(c.currentInstructions()), start)
+	return nil
+}
+
+// compileForStatement gives the variables of the loop header a scope of
+// their own around the loop, shared by all iterations.
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	if node.Init != nil {
+		if err := c.Compile(node.Init); err != nil {
+			return err
+		}
+	}
+
+	start := len(c.currentInstructions())
+	exit := -1
+	if node.Condition != nil {
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		exit = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+
+	if err := c.compileLoopBody(node.Body); err != nil {
+		return err
+	}
+
+	post := len(c.currentInstructions())
+	if node.Post != nil {
+		if err := c.Compile(node.Post); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	}
+	c.emit(code.OpJump, start)
+
+	end := len(c.currentInstructions())
+	if exit >= 0 {
+		c.changeOperand(exit, end)
+	}
+	c.leaveLoop(end, post)
+	return nil
+}
+
+// compileForInStatement keeps the iterator on the stack while the loop
+// runs. Every iteration binds the variable anew in the scope of the body,
+// so closures created in the body capture the element of their own
+// iteration.
+func (c *Compiler) compileForInStatement(node *ast.ForInStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+
+	start := len(c.currentInstructions())
+	c.enterLoop()
+	next := c.emit(code.OpIterNext, 9999)
+
+	c.enterBlock()
+	c.storeSymbol(c.symbolTable.Define(node.Variable.Value), true)
+	c.declareAhead(node.Body.Statements)
+	err := c.compileStatements(node.Body.Statements)
+	c.leaveBlock()
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	done := len(c.currentInstructions())
+	c.changeOperand(next, done)
+	c.leaveLoop(done, start)
+	c.emit(code.OpPop)
+	return nil
+}
+
+// compileLoopBody compiles the body of a while or C-style for loop, whose
+// block gets a fresh scope on every iteration.
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement) error {
+	c.enterLoop()
+	c.enterBlock()
+	defer c.leaveBlock()
+	c.declareAhead(body.Statements)
+	return c.compileStatements(body.Statements)
+}
+
+func (c *Compiler) enterLoop() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loop{depth: scope.depth})
+}
+
+// leaveLoop points the break statements of the innermost loop at end and
+// its continue statements at next.
+func (c *Compiler) leaveLoop(end, next int) {
+	scope := &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, end)
+	}
+	for _, pos := range loop.continues {
+		c.changeOperand(pos, next)
+	}
+}
+
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	return loops[len(loops)-1]
+}
+
+// emitBranch emits the jump of a break or continue statement out of the
+// expressions around it, popping their operands down to the depth of the
+// stack at the targets of loop. It returns the position of the jump.
+func (c *Compiler) emitBranch(loop *loop) int {
+	depth := c.scopes[c.scopeIndex].depth
+	for i := loop.depth; i < depth; i++ {
+		c.emit(code.OpPop)
+	}
+	pos := c.emit(code.OpJump, 9999)
+
+	// The code that follows is never run, but it expects the operands on
+	// the stack, and so do the machines that follow the code in order to
+	// know the depth of the stack. Placeholders take their place.
+	for i := loop.depth; i < depth; i++ {
+		c.emit(code.OpNull)
+	}
+	return pos
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	params := make([]string, len(node.Parameters))
+	for i, p := range node.Parameters {
+		params[i] = p.Value
+		c.symbolTable.Define(p.Value)
+	}
+	c.declareAhead(node.Body.Statements)
+
+	if err := c.compileStatements(node.Body.Statements); err != nil {
+		c.leaveScope()
+		return err
+	}
+	if endsWithExpression(node.Body) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	var cellParams []int
+	for i := range params {
+		if c.local(i).captured {
+			cellParams = append(cellParams, i)
+		}
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.NumLocals()
+	instructions, lines := c.leaveScope()
+
+	if len(freeSymbols) > math.MaxUint8 {
+		return fmt.Errorf("function captures too many variables: %d", len(freeSymbols))
+	}
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:   instructions,
+		Parameters:     params,
+		NumLocals:      numLocals,
+		CellParameters: cellParams,
+		Lines:          lines,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	return nil
+}
+
+// captureSymbol pushes the cell of a variable a closure captures.
+func (c *Compiler) captureSymbol(s Symbol) {
+	if s.Scope == FreeScope {
+		c.emit(code.OpCaptureFree, s.Index)
+		return
+	}
+
+	l := c.local(s.Index)
+	if l.pending && !l.captured {
+		// The let statement of the local has not run yet, so its cell is
+		// created here, for the closure and the let statement to share.
+		c.emit(code.OpNull)
+		c.emit(code.OpDefineLocalCell, s.Index)
+	}
+	c.markCaptured(s.Index)
+	c.emit(code.OpCaptureLocal, s.Index)
+}
+
+// markCaptured turns every use of a local so far into a use of its cell.
+// The cell instructions have the same operands, so they are patched in
+// place.
+func (c *Compiler) markCaptured(index int) {
+	l := c.local(index)
+	if l.captured {
+		return
+	}
+	l.captured = true
+
+	ins := c.currentInstructions()
+	for _, use := range l.uses {
+		switch {
+		case code.Opcode(ins[use.position]) == code.OpGetLocal:
+			ins[use.position] = byte(code.OpGetLocalCell)
+		case use.define:
+			ins[use.position] = byte(code.OpDefineLocalCell)
+		default:
+			ins[use.position] = byte(code.OpSetLocalCell)
+		}
+	}
+	l.uses = nil
+}
+
+// resolve looks a name up. Names that are not defined anywhere yet are
+// taken to be globals, which may still be defined by the time the code
+// runs, e.g. by a later let statement at the top level.
+func (c *Compiler) resolve(name string) Symbol {
+	if symbol, ok := c.symbolTable.Resolve(name); ok {
+		return symbol
+	}
+	return c.symbolTable.Global().Define(name)
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		l := c.local(s.Index)
+		if l.captured {
+			c.emit(code.OpGetLocalCell, s.Index)
+			return
+		}
+		l.uses = append(l.uses, localUse{position: c.emit(code.OpGetLocal, s.Index)})
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+// storeSymbol pops the top of the stack into s. define is set for let
+// statements, which bind a new variable instead of assigning to one.
+func (c *Compiler) storeSymbol(s Symbol, define bool) {
+	switch s.Scope {
+	case GlobalScope:
+		if define {
+			c.emit(code.OpSetGlobal, s.Index)
+		} else {
+			c.emit(code.OpAssignGlobal, s.Index)
+		}
+	case LocalScope:
+		l := c.local(s.Index)
+		switch {
+		case l.captured && define:
+			c.emit(code.OpDefineLocalCell, s.Index)
+		case l.captured:
+			c.emit(code.OpSetLocalCell, s.Index)
+		default:
+			pos := c.emit(code.OpSetLocal, s.Index)
+			l.uses = append(l.uses, localUse{position: pos, define: define})
+		}
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+func (c *Compiler) local(index int) *local {
+	locals := c.scopes[c.scopeIndex].locals
+	l, ok := locals[index]
+	if !ok {
+		l = &local{}
+		locals[index] = l
+	}
+	return l
+}
+
+// addConstant adds obj to the constants and returns its index. A literal
+// value that is already a constant is not added again, which keeps long
+// programs within the 65536 constants operands can refer to.
+func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := keyOf(obj)
+	if ok {
+		if i, ok := c.literals[key]; ok {
+			return i
+		}
+	}
+
+	c.constants = append(c.constants, obj)
+	if ok {
+		c.literals[key] = len(c.constants) - 1
+	}
+	return len(c.constants) - 1
+}
+
+// literalKey identifies a literal value by its type and an exact
+// representation of it.
+type literalKey struct {
+	typ   object.ObjectType
+	value string
+}
+
+func keyOf(obj object.Object) (literalKey, bool) {
+	var value string
+	switch obj := obj.(type) {
+	case *object.Integer:
+		value = strconv.FormatInt(obj.Value, 10)
+	case *object.BigInteger:
+		value = obj.Value.String()
+	case *object.Float:
+		value = strconv.FormatUint(math.Float64bits(obj.Value), 16)
+	case *object.Decimal:
+		value = obj.Inspect()
+	case *object.String:
+		value = obj.Value
+	default:
+		return literalKey{}, false
+	}
+	return literalKey{typ: obj.Type(), value: value}, true
+}
+
+// emit appends an instruction and returns its position.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+	if isJump(op) {
+		c.checkJump(pos, operands[0])
+	}
+
+	c.setLastInstruction(op, pos)
+	c.scopes[c.scopeIndex].depth += stackEffect(op, operands)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	c.scopes[c.scopeIndex].lines = c.scopes[c.scopeIndex].lines.Add(posNewInstruction, c.line)
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	new := old[:last.Position]
+
+	c.scopes[c.scopeIndex].instructions = new
+	c.scopes[c.scopeIndex].lines = c.scopes[c.scopeIndex].lines.Truncate(last.Position)
+	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].depth++
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// stackEffect returns how many values an instruction adds to the stack,
+// or removes if negative, when it does not jump. Jumps that pop or push
+// differently when they are taken are accounted for where they land.
+func stackEffect(op code.Opcode, operands []int) int {
+	switch op {
+	case code.OpConstant, code.OpDup, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetLocalCell, code.OpGetFree,
+		code.OpCaptureLocal, code.OpCaptureFree, code.OpIterNext:
+		return 1
+	case code.OpDup2:
+		return 2
+	case code.OpPop, code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpFloorDiv, code.OpMod, code.OpPow, code.OpBitAnd, code.OpBitOr,
+		code.OpBitXor, code.OpShl, code.OpShr, code.OpEqual, code.OpNotEqual,
+		code.OpLessThan, code.OpLessEqual, code.OpGreaterThan,
+		code.OpGreaterEqual, code.OpJumpNotTruthy, code.OpJumpTruthy,
+		code.OpJumpNotNull, code.OpSetGlobal, code.OpAssignGlobal,
+		code.OpSetLocal, code.OpSetLocalCell, code.OpDefineLocalCell,
+		code.OpSetFree, code.OpIndex, code.OpSetMember, code.OpReturnValue:
+		return -1
+	case code.OpSetIndex:
+		return -2
+	case code.OpArray:
+		return 1 - operands[0]
+	case code.OpHash:
+		return 1 - 2*operands[0]
+	case code.OpCall:
+		return -operands[0]
+	case code.OpClosure:
+		return 1 - operands[1]
+	}
+	return 0
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+	c.checkJump(opPos, operand)
+}
+
+func isJump(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy,
+		code.OpJumpNull, code.OpJumpNotNull, code.OpIterNext:
+		return true
+	}
+	return false
+}
+
+// checkJump records an error if target, the target of the jump at pos,
+// does not fit in the 16-bit operand of the jump. The instructions of a
+// function, or of the main program, can be longer than that, but jumps
+// only reach the first 65535 bytes of them: a loop or a condition further
+// down fails to compile, with the line of its jump.
+func (c *Compiler) checkJump(pos, target int) {
+	if target <= math.MaxUint16 || c.jumpErr != nil {
+		return
+	}
+	what := "program"
+	if c.scopeIndex > 0 {
+		what = "function"
+	}
+	line := c.scopes[c.scopeIndex].lines.Line(pos)
+	c.jumpErr = fmt.Errorf("line %d: %s too large: jump to byte %d of its bytecode, at most %d can be reached; move code into functions",
+		line, what, target, math.MaxUint16)
+}
+
+// checkConstants reports more constants than the 16-bit operands of the
+// instructions that refer to them can address.
+func (c *Compiler) checkConstants() error {
+	if n := len(c.constants); n > math.MaxUint16+1 {
+		return fmt.Errorf("too many constants: %d, at most %d are supported", n, math.MaxUint16+1)
+	}
+	return nil
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+// enterScope starts compiling a function literal.
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{locals: make(map[int]*local)}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// leaveScope finishes compiling a function literal and returns its
+// instructions and their line table.
+func (c *Compiler) leaveScope() (code.Instructions, code.LineTable) {
+	instructions := c.currentInstructions()
+	lines := c.scopes[c.scopeIndex].lines
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions, lines
+}
+
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
+// Bytecode returns the compiled main program.
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		NumLocals:    c.symbolTable.NumLocals(),
+		Globals:      c.symbolTable.Global().globalNames(),
+		Lines:        c.scopes[c.scopeIndex].lines,
+	}
+}
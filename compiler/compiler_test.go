@@ -0,0 +1,1193 @@
+package compiler
+
+import (
+	"fmt"
+	"staq/ast"
+	"staq/code"
+	"staq/lexer"
+	"staq/object"
+	"staq/parser"
+	"strings"
+	"testing"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 ** 3 ~/ 4",
+			expectedConstants: []interface{}{2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPow),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpFloorDiv),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 << 2 & 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShl),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpBitAnd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "~1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1.5 + 2.5d",
+			expectedConstants: []interface{}{1.5, "2.5d"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true != false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpFalse),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "!true",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "null",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestShortCircuit(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpFalse),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpTruthy, 12),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "null ?? 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpJumpNotNull, 7),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (true) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 13),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 2),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// A block that does not end with an expression is null, and
+			// its locals live in the main program's frame.
+			input:             "if (true) { let a = 1; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpSetLocal, 0),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let one = 1;
+			let two = 2;
+			`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input: `
+			let one = 1;
+			let one = one + 1;
+			one;
+			`,
+			// The literal 1 is a single constant.
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Names that are never defined are still globals, and the
+			// virtual machine reports them when they are read.
+			input:             "x; let y = 1;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup),
+				code.Make(code.OpAssignGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; x -= 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSub),
+				code.Make(code.OpDup),
+				code.Make(code.OpAssignGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let a = []; a[0] *= 2;",
+			expectedConstants: []interface{}{0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDup2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let h = {}; h.name = "staq";`,
+			expectedConstants: []interface{}{"name", "staq"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetMember, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIncrementExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; ++x;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpIncrement, 0),
+				code.Make(code.OpDup),
+				code.Make(code.OpAssignGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The old value is kept in a temporary local of the main
+			// program's frame.
+			input:             "let x = 1; x--;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpDecrement, 1),
+				code.Make(code.OpDup),
+				code.Make(code.OpAssignGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIncrementTempIsReused(t *testing.T) {
+	// Like the REPL, compile line after line with the same globals.
+	symbolTable := NewSymbolTable()
+	constants := []object.Object{}
+	for i := 0; i < 3; i++ {
+		compiler := NewWithState(symbolTable, constants)
+		if err := compiler.Compile(parse("let x = 1; x++; x--;")); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+		constants = bytecode.Constants
+
+		if bytecode.NumLocals != 1 {
+			t.Errorf("line %d: wrong NumLocals. want=1, got=%d", i+1, bytecode.NumLocals)
+		}
+	}
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"staq"`,
+			expectedConstants: []interface{}{"staq"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"sta" + "q"`,
+			expectedConstants: []interface{}{"sta", "q"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestArrayAndHashLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2 + 3]",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "{1: 2, 3: 4 * 5}",
+			expectedConstants: []interface{}{1, 2, 3, 4, 5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpMul),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIndexAndMemberExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1][0]",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "null?.a.b",
+			expectedConstants: []interface{}{"a", "b"},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpJumpNull, 7),
+				// 0004
+				code.Make(code.OpMember, 0),
+				// 0007
+				code.Make(code.OpMember, 1),
+				// 0010
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "null?.[0]",
+			expectedConstants: []interface{}{0},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpJumpNull, 8),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpIndex),
+				// 0008
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn() { return 5 + 10 }",
+			expectedConstants: []interface{}{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { 1; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let a = 1; }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let f = fn(a, b) { a; b }; f(1, 2);",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// Capturing a parameter moves it into a cell, for every use
+			// of it, including those compiled before the closure.
+			input: "fn(a) { a; fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { fn(b) { fn(c) { a + b + c } } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetFree, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 2),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Assignments through a closure write the shared cell.
+			input: "fn() { let n = 0; let inc = fn() { n += 1 }; n }",
+			expectedConstants: []interface{}{
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpDup),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpDefineLocalCell, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// The closure captures the cell of its own local before the
+			// let statement stores the closure in it.
+			input: "fn() { let f = fn(x) { f(x) }; f }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpNull),
+					code.Make(code.OpDefineLocalCell, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocalCell, 0),
+					code.Make(code.OpGetLocalCell, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// The value of a let statement still sees the outer binding.
+			input: "fn(x) { fn() { let x = x + 1; x } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { break; continue; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 13),
+				// 0004
+				code.Make(code.OpJump, 13),
+				// 0007
+				code.Make(code.OpJump, 0),
+				// 0010
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             "for (let i = 0; i < 3; i++) { continue; }",
+			expectedConstants: []interface{}{0, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetLocal, 0),
+				// 0006
+				code.Make(code.OpGetLocal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpLessThan),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 40),
+				// 0016
+				code.Make(code.OpJump, 19),
+				// 0019
+				code.Make(code.OpGetLocal, 0),
+				// 0022
+				code.Make(code.OpDup),
+				// 0023
+				code.Make(code.OpSetLocal, 1),
+				// 0026
+				code.Make(code.OpIncrement, 1),
+				// 0028
+				code.Make(code.OpDup),
+				// 0029
+				code.Make(code.OpSetLocal, 0),
+				// 0032
+				code.Make(code.OpPop),
+				// 0033
+				code.Make(code.OpGetLocal, 1),
+				// 0036
+				code.Make(code.OpPop),
+				// 0037
+				code.Make(code.OpJump, 6),
+			},
+		},
+		{
+			input:             "for (x in [1]) { x; break; }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 23),
+				// 0010
+				code.Make(code.OpSetLocal, 0),
+				// 0013
+				code.Make(code.OpGetLocal, 0),
+				// 0016
+				code.Make(code.OpPop),
+				// 0017
+				code.Make(code.OpJump, 23),
+				// 0020
+				code.Make(code.OpJump, 7),
+				// 0023
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// A break in an operand pops the operands before it, and
+			// the code after it expects them in place.
+			input:             "while (true) { 1 + if (true) { break; } }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 26),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpTrue),
+				// 0008
+				code.Make(code.OpJumpNotTruthy, 20),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpJump, 26),
+				// 0015
+				code.Make(code.OpNull),
+				// 0016
+				code.Make(code.OpNull),
+				// 0017
+				code.Make(code.OpJump, 21),
+				// 0020
+				code.Make(code.OpNull),
+				// 0021
+				code.Make(code.OpAdd),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			// Every iteration defines a new cell for the variable.
+			input: "for (x in []) { fn() { x } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpArray, 0),
+				// 0003
+				code.Make(code.OpIter),
+				// 0004
+				code.Make(code.OpIterNext, 21),
+				// 0007
+				code.Make(code.OpDefineLocalCell, 0),
+				// 0010
+				code.Make(code.OpCaptureLocal, 0),
+				// 0013
+				code.Make(code.OpClosure, 0, 1),
+				// 0017
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpJump, 4),
+				// 0021
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerScopes(t *testing.T) {
+	compiler := New()
+	if compiler.scopeIndex != 0 {
+		t.Errorf("scopeIndex wrong. got=%d, want=%d", compiler.scopeIndex, 0)
+	}
+	globalSymbolTable := compiler.symbolTable
+
+	compiler.emit(code.OpMul)
+
+	compiler.enterScope()
+	if compiler.scopeIndex != 1 {
+		t.Errorf("scopeIndex wrong. got=%d, want=%d", compiler.scopeIndex, 1)
+	}
+
+	compiler.emit(code.OpSub)
+
+	if len(compiler.scopes[compiler.scopeIndex].instructions) != 1 {
+		t.Errorf("instructions length wrong. got=%d",
+			len(compiler.scopes[compiler.scopeIndex].instructions))
+	}
+
+	if compiler.symbolTable.Outer != globalSymbolTable {
+		t.Errorf("compiler did not enclose symbolTable")
+	}
+
+	compiler.leaveScope()
+	if compiler.scopeIndex != 0 {
+		t.Errorf("scopeIndex wrong. got=%d, want=%d", compiler.scopeIndex, 0)
+	}
+
+	if compiler.symbolTable != globalSymbolTable {
+		t.Errorf("compiler did not restore global symbol table")
+	}
+
+	compiler.emit(code.OpAdd)
+
+	if len(compiler.scopes[compiler.scopeIndex].instructions) != 2 {
+		t.Errorf("instructions length wrong. got=%d",
+			len(compiler.scopes[compiler.scopeIndex].instructions))
+	}
+
+	last := compiler.scopes[compiler.scopeIndex].lastInstruction
+	if last.Opcode != code.OpAdd {
+		t.Errorf("lastInstruction.Opcode wrong. got=%d, want=%d",
+			last.Opcode, code.OpAdd)
+	}
+
+	previous := compiler.scopes[compiler.scopeIndex].previousInstruction
+	if previous.Opcode != code.OpMul {
+		t.Errorf("previousInstruction.Opcode wrong. got=%d, want=%d",
+			previous.Opcode, code.OpMul)
+	}
+}
+
+func TestLineTables(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+  let y = x + a;
+  y
+};
+f(2);`
+
+	compiler := New()
+	if err := compiler.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	// 0000 OpConstant 0, 0003 OpSetGlobal 0, 0006 OpClosure 1 0,
+	// 0010 OpSetGlobal 1, 0013 OpGetGlobal 1, 0016 OpConstant 2,
+	// 0019 OpCall 1, 0021 OpPop
+	expected := code.LineTable{{Offset: 0, Line: 1}, {Offset: 6, Line: 2}, {Offset: 13, Line: 6}}
+	if fmt.Sprint(bytecode.Lines) != fmt.Sprint(expected) {
+		t.Errorf("wrong lines for the main program. want=%v, got=%v", expected, bytecode.Lines)
+	}
+
+	// 0000 OpGetLocal 0, 0003 OpGetGlobal 0, 0006 OpAdd,
+	// 0007 OpSetLocal 1, 0010 OpGetLocal 1, 0013 OpReturnValue
+	fn := bytecode.Constants[1].(*object.CompiledFunction)
+	expected = code.LineTable{{Offset: 0, Line: 3}, {Offset: 10, Line: 4}}
+	if fmt.Sprint(fn.Lines) != fmt.Sprint(expected) {
+		t.Errorf("wrong lines for the function. want=%v, got=%v", expected, fn.Lines)
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", tt.input, err)
+		}
+
+		err = testConstants(tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("testConstants failed for %q: %s", tt.input, err)
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testInstructions(expected []code.Instructions, actual code.Instructions) error {
+	concatted := concatInstructions(expected)
+
+	if len(actual) != len(concatted) {
+		return fmt.Errorf("wrong instructions length.\nwant=\n%s\ngot =\n%s",
+			concatted, actual)
+	}
+
+	for i, ins := range concatted {
+		if actual[i] != ins {
+			return fmt.Errorf("wrong instruction at %d.\nwant=\n%s\ngot =\n%s",
+				i, concatted, actual)
+		}
+	}
+
+	return nil
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
+
+func testConstants(expected []interface{}, actual []object.Object) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("wrong number of constants. got=%d, want=%d",
+			len(actual), len(expected))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				return fmt.Errorf("constant %d - want integer %d, got=%s",
+					i, constant, actual[i].Inspect())
+			}
+
+		case float64:
+			float, ok := actual[i].(*object.Float)
+			if !ok || float.Value != constant {
+				return fmt.Errorf("constant %d - want float %g, got=%s",
+					i, constant, actual[i].Inspect())
+			}
+
+		case string:
+			// Decimals are given as they print, e.g. "2.5d".
+			if actual[i].Type() != object.STRING_OBJ && actual[i].Type() != object.DECIMAL_OBJ {
+				return fmt.Errorf("constant %d - want %q, got=%T", i, constant, actual[i])
+			}
+			if str, ok := actual[i].(*object.String); ok && str.Value != constant {
+				return fmt.Errorf("constant %d - want string %q, got=%q",
+					i, constant, str.Value)
+			}
+			if dec, ok := actual[i].(*object.Decimal); ok && dec.Inspect() != constant {
+				return fmt.Errorf("constant %d - want decimal %s, got=%s",
+					i, constant, dec.Inspect())
+			}
+
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+
+			if err := testInstructions(constant, fn.Instructions); err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestTooManyConstants(t *testing.T) {
+	// Four functions of 16400 constants each: every function fits the
+	// size limit, but the constants do not fit their operands.
+	var b strings.Builder
+	for f := 0; f < 4; f++ {
+		fmt.Fprintf(&b, "fn() { [%d", f*16400)
+		for i := 1; i < 16400; i++ {
+			fmt.Fprintf(&b, ", %d", f*16400+i)
+		}
+		b.WriteString("] };")
+	}
+
+	err := New().Compile(parse(b.String()))
+	expected := "too many constants: 65604, at most 65536 are supported"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
+
+func TestLongPrograms(t *testing.T) {
+	// 6000 statements of 12 bytes each: longer than jumps can reach.
+	input := "let x = 0;\n" + strings.Repeat("x = x + 1;\n", 6000)
+
+	if err := New().Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err := New().Compile(parse(input + "while (false) { }"))
+	expected := "line 6002: program too large: jump to byte 72006 of its bytecode, at most 65535 can be reached; move code into functions"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
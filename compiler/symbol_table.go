@@ -0,0 +1,207 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	FreeScope   SymbolScope = "FREE"
+)
+
+// Symbol is a name resolved at compile time. Index is the slot of a global
+// or a local, or the position of a free variable in its closure.
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable maps names to symbols for one scope. There are three kinds
+// of tables, mirroring the environments of the evaluator:
+//
+//   - the global table, whose definitions are globals
+//   - function tables, one per function literal, whose definitions are
+//     locals of the function's frame
+//   - block tables, for the blocks of if expressions and loops, whose
+//     definitions are locals of the frame of the enclosing function, or of
+//     the main program's frame at the top level
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store map[string]Symbol
+	// pending holds the symbols of let statements whose value is being
+	// compiled, see DefinePending, or that are declared ahead of them, see
+	// DeclarePending.
+	pending map[string]Symbol
+	// root is the table that owns the frame the locals of this table
+	// live in: the table itself for the global and function tables.
+	root  *SymbolTable
+	block bool
+
+	numGlobals int
+	numLocals  int
+	// temp is the slot returned by DefineTemp, once it is reserved.
+	temp *Symbol
+
+	FreeSymbols []Symbol
+}
+
+// NewSymbolTable returns an empty global symbol table.
+func NewSymbolTable() *SymbolTable {
+	s := &SymbolTable{store: make(map[string]Symbol), pending: make(map[string]Symbol)}
+	s.root = s
+	return s
+}
+
+// NewEnclosedSymbolTable returns the table of a function literal defined in
+// the scope of outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable returns the table of a block nested in outer, within
+// the same function.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.root = outer.root
+	s.block = true
+	return s
+}
+
+// Define binds name in this scope. Defining a name that this scope already
+// defines returns the existing symbol, since a second let statement in
+// the same scope rebinds the name like the evaluator does. Defining a
+// pending name ends its pending state.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.pending[name]; ok {
+		delete(s.pending, name)
+		s.store[name] = symbol
+		return symbol
+	}
+	if symbol, ok := s.store[name]; ok && symbol.Scope != FreeScope {
+		return symbol
+	}
+
+	var symbol Symbol
+	if s.Outer == nil {
+		symbol = Symbol{Name: name, Scope: GlobalScope, Index: s.numGlobals}
+		s.numGlobals++
+	} else {
+		symbol = Symbol{Name: name, Scope: LocalScope, Index: s.root.numLocals}
+		s.root.numLocals++
+	}
+
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefinePending defines name for the duration of compiling the value of
+// its let statement, until Define is called for it. During that time the
+// new binding is only visible to function literals in the value, which
+// may call it recursively, while the value itself still sees the outer
+// binding, as in `let x = x + 1`. If this scope already defines name, the
+// let statement rebinds it: DefinePending returns the existing symbol and
+// false. A name declared ahead keeps its pending symbol.
+func (s *SymbolTable) DefinePending(name string) (Symbol, bool) {
+	if symbol, ok := s.pending[name]; ok {
+		return symbol, true
+	}
+	if symbol, ok := s.store[name]; ok && symbol.Scope != FreeScope {
+		return symbol, false
+	}
+
+	symbol := Symbol{Name: name, Scope: LocalScope, Index: s.root.numLocals}
+	s.root.numLocals++
+	s.pending[name] = symbol
+	return symbol, true
+}
+
+// DeclarePending makes name pending ahead of its let statement, for the
+// function literals that come before the statement in the same scope and
+// that see the new binding once it is made, see Compiler.declareAhead.
+func (s *SymbolTable) DeclarePending(name string) Symbol {
+	symbol, _ := s.DefinePending(name)
+	return symbol
+}
+
+// Defines reports whether this scope itself defines name, or has it
+// pending.
+func (s *SymbolTable) Defines(name string) bool {
+	if _, ok := s.pending[name]; ok {
+		return true
+	}
+	symbol, ok := s.store[name]
+	return ok && symbol.Scope != FreeScope
+}
+
+// DefineTemp returns an unnamed local slot in the current frame for a
+// value the compiled code needs to keep aside. The frame has only one such
+// slot, reserved the first time, so a value must be read back before the
+// code keeps another one aside.
+func (s *SymbolTable) DefineTemp() Symbol {
+	if s.root.temp == nil {
+		s.root.temp = &Symbol{Scope: LocalScope, Index: s.root.numLocals}
+		s.root.numLocals++
+	}
+	return *s.root.temp
+}
+
+// Resolve looks name up in this scope and the enclosing ones. Locals of
+// enclosing functions become free variables of every function in between.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+func (s *SymbolTable) resolve(name string, fromInnerFunction bool) (Symbol, bool) {
+	symbol, ok := s.pending[name]
+	if !ok || !fromInnerFunction {
+		symbol, ok = s.store[name]
+	}
+	if ok {
+		return symbol, true
+	}
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	symbol, ok = s.Outer.resolve(name, fromInnerFunction || !s.block)
+	if !ok || s.block || symbol.Scope == GlobalScope {
+		return symbol, ok
+	}
+	return s.defineFree(symbol), true
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Global returns the global table at the root of all scopes.
+func (s *SymbolTable) Global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
+// globalNames returns the names of the globals of this global table,
+// indexed by slot.
+func (s *SymbolTable) globalNames() []string {
+	names := make([]string, s.numGlobals)
+	for name, symbol := range s.store {
+		names[symbol.Index] = name
+	}
+	return names
+}
+
+// NumLocals returns the number of local slots the frame of this scope needs
+// so far.
+func (s *SymbolTable) NumLocals() int {
+	return s.root.numLocals
+}
@@ -0,0 +1,101 @@
+package compiler
+
+import "testing"
+
+func TestDefineAndResolve(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	if a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("a wrong. got=%+v", a)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	b := local.Define("b")
+	block := NewBlockSymbolTable(local)
+	c := block.Define("c")
+
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: LocalScope, Index: 0},
+		"c": {Name: "c", Scope: LocalScope, Index: 1},
+	}
+	for name, want := range expected {
+		got, ok := block.Resolve(name)
+		if !ok {
+			t.Errorf("name %s not resolvable", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", name, want, got)
+		}
+	}
+
+	if b.Index == c.Index || local.NumLocals() != 2 {
+		t.Errorf("block locals must share the frame of their function. got %d locals", local.NumLocals())
+	}
+	if _, ok := local.Resolve("c"); ok {
+		t.Errorf("c resolvable outside of its block")
+	}
+	if local.Define("b") != b {
+		t.Errorf("redefining b in the same scope must reuse its slot")
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	outer := NewEnclosedSymbolTable(global)
+	outer.Define("b")
+
+	inner := NewEnclosedSymbolTable(outer)
+	inner.Define("c")
+
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: FreeScope, Index: 0},
+		"c": {Name: "c", Scope: LocalScope, Index: 0},
+	}
+	for name, want := range expected {
+		got, ok := inner.Resolve(name)
+		if !ok {
+			t.Errorf("name %s not resolvable", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", name, want, got)
+		}
+	}
+
+	if len(inner.FreeSymbols) != 1 || inner.FreeSymbols[0] != (Symbol{Name: "b", Scope: LocalScope, Index: 0}) {
+		t.Errorf("wrong free symbols. got=%+v", inner.FreeSymbols)
+	}
+}
+
+func TestDefinePending(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("x")
+
+	local := NewEnclosedSymbolTable(global)
+	x, fresh := local.DefinePending("x")
+	if !fresh {
+		t.Fatalf("x must be a new local")
+	}
+
+	if got, _ := local.Resolve("x"); got.Scope != GlobalScope {
+		t.Errorf("pending x visible to its own scope: %+v", got)
+	}
+
+	inner := NewEnclosedSymbolTable(local)
+	if got, _ := inner.Resolve("x"); got.Scope != FreeScope || inner.FreeSymbols[0] != x {
+		t.Errorf("pending x not visible to inner function: %+v", got)
+	}
+
+	local.Define("x")
+	if got, _ := local.Resolve("x"); got != x {
+		t.Errorf("x not defined after its let statement: %+v", got)
+	}
+	if _, fresh := local.DefinePending("x"); fresh {
+		t.Errorf("letting x again must reuse its slot")
+	}
+}
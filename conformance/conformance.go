@@ -0,0 +1,309 @@
+// Package conformance holds programs together with the results that every
+// way of running StaQ must give for them: the evaluator and both virtual
+// machines run the same suites in their tests.
+package conformance
+
+import (
+	"staq/ast"
+	"staq/diagnostic"
+	"staq/lexer"
+	"staq/parser"
+	"testing"
+)
+
+// Test is a program and its expected result as the REPL prints it, so
+// errors are written as "ERROR: message".
+type Test struct {
+	Input    string
+	Expected string
+}
+
+// Suite is a named group of tests.
+type Suite struct {
+	Name  string
+	Tests []Test
+}
+
+var Suites = []Suite{
+	{"IntegerArithmetic", []Test{
+		{"1", "1"},
+		{"1 + 2", "3"},
+		{"50 / 2 * 2 + 10 - 5", "55.0"},
+		{"5 * (2 + 10)", "60"},
+		{"-5 + 10", "5"},
+		{"7 ~/ 2", "3"},
+		{"-7 % 3", "2"},
+		{"2 ** 3 ** 2", "512"},
+		{"~5", "-6"},
+		{"6 & 3 | 8", "10"},
+		{"-7 >> 1", "-4"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"2 ** 64 - 2 ** 64 + 1", "1"},
+		{"1.5 * 2", "3.0"},
+		{"0.1d + 0.2d", "0.3d"},
+	}},
+	{"BooleanExpressions", []Test{
+		{"true", "true"},
+		{"1 < 2", "true"},
+		{"1 >= 2", "false"},
+		{"1 == 1.0", "true"},
+		{"(1 < 2) == true", "true"},
+		{"!5", "false"},
+		{"!!null", "false"},
+		{`"a" < "b"`, "true"},
+		{"null == null", "true"},
+		{"[] == []", "false"},
+	}},
+	{"ShortCircuit", []Test{
+		{"1 && 2", "true"},
+		{"0 && x", "false"},
+		{"0 || \"\"", "false"},
+		{"1 || x", "true"},
+		{"null ?? 5", "5"},
+		{"0 ?? x", "0"},
+		{"let h = {}; h.a?.b", "null"},
+		{"let h = null; h?.a.b", "ERROR: member access not supported: NULL.b"},
+		{"let a = null; a?.[0]", "null"},
+	}},
+	{"Conditionals", []Test{
+		{"if (true) { 10 }", "10"},
+		{"if (false) { 10 }", "null"},
+		{"if (1 > 2) { 10 } else { 20 }", "20"},
+		{"if ([]) { 10 } else { 20 }", "20"},
+		{"if (true) { let a = 1; }", "null"},
+		{"let a = 1; if (true) { let a = 2; }; a", "1"},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", "20"},
+	}},
+	{"GlobalLetStatements", []Test{
+		{"let one = 1; one", "1"},
+		{"let one = 1; let two = one + one; one + two", "3"},
+		{"let a = 1; let a = a + 1; a", "2"},
+		{"x", "ERROR: identifier not found: x"},
+		{"x = 1", "ERROR: assignment to undeclared variable: x"},
+	}},
+	{"Collections", []Test{
+		{"[1, 2 * 2, 3 + 3]", "[1, 4, 6]"},
+		{"[1, 2, 3][1]", "2"},
+		{"[1, 2, 3][3]", "null"},
+		{"{1: 2, 2: 3}[2]", "3"},
+		{`{"a": 1}.a`, "1"},
+		{`{"a": 1}.b`, "null"},
+		{`{1.5: "a"}[1.5d]`, "a"},
+		{`{1e19: "a"}[10000000000000000000]`, "a"},
+		{`{2: "a"}[2.0]`, "a"},
+		{"{[]: 1}", "ERROR: unusable as hash key: ARRAY"},
+		{"1[0]", "ERROR: index operator not supported: INTEGER[INTEGER]"},
+		{"1.a", "ERROR: member access not supported: INTEGER.a"},
+	}},
+	{"Assignments", []Test{
+		{"let x = 1; x = x + 1; x", "2"},
+		{"let x = 1; x += 2", "3"},
+		{"let x = 2; x *= x", "4"},
+		{"let a = [1, 2]; a[1] = 5; a", "[1, 5]"},
+		{"let a = [1, 2]; a[0] -= 3; a[0]", "-2"},
+		{"let a = [1]; a[1] = 2", "ERROR: index out of range: 1"},
+		{`let h = {}; h.name = "staq"; h["name"]`, "staq"},
+		{`let h = {"n": 1}; h.n += 1`, "2"},
+		{"let s = 1; s.a = 1", "ERROR: member access not supported: INTEGER.a"},
+		{"let x = 1; x++", "1"},
+		{"let x = 1; x++; x", "2"},
+		{"let x = 1; ++x", "2"},
+		{"let x = 1; x--; --x", "-1"},
+		{"let a = [1]; a[0]++; a[0]++", "2"},
+		{`let h = {"n": 1}; ++h.n`, "2"},
+		{`let s = "a"; s++`, "ERROR: unknown operator: STRING++"},
+		{`let s = "a"; --s`, "ERROR: unknown operator: --STRING"},
+	}},
+	{"CallingFunctions", []Test{
+		{"let f = fn() { 5 + 10 }; f()", "15"},
+		{"let f = fn() { return 1; 2 }; f()", "1"},
+		{"let f = fn() { }; f()", "null"},
+		{"let f = fn() { let a = 1; }; f()", "null"},
+		{"let f = fn() { return; }; f()", "null"},
+		{"let f = fn(a, b) { a + b }; f(1, 2)", "3"},
+		{"let f = fn(a) { let b = a * 2; b + a }; f(1) + f(2)", "9"},
+		{"let g = fn() { 1 }; let f = fn() { g }; f()()", "1"},
+		{"fn(a) { a }()", "ERROR: wrong number of arguments: want=1, got=0"},
+		{"1()", "ERROR: not a function: INTEGER"},
+		{"return 5; 10", "5"},
+		{"let f = fn(x) { x == 1 }; if (f(1)) { 2 }", "2"},
+	}},
+	{"Closures", []Test{
+		{"let adder = fn(x) { fn(y) { x + y } }; adder(2)(3)", "5"},
+		{"fn(a) { fn(b) { fn(c) { a + b + c } } }(1)(2)(3)", "6"},
+		{`
+			let counter = fn() {
+				let n = 0;
+				fn() { n += 1 }
+			};
+			let c = counter();
+			c(); c();
+			c()
+			`, "3"},
+		{`
+			let pair = fn() {
+				let n = 0;
+				let inc = fn() { n++ };
+				let get = fn() { n };
+				[inc, get]
+			};
+			let p = pair();
+			p[0](); p[0]();
+			p[1]()
+			`, "2"},
+		{`
+			let f = fn(x) {
+				let g = fn() { x };
+				x = x * 10;
+				g()
+			};
+			f(2)
+			`, "20"},
+		{`
+			let countDown = fn(x) {
+				if (x == 0) { return 0; }
+				countDown(x - 1)
+			};
+			countDown(5)
+			`, "0"},
+		{`
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) { return 0; }
+					countDown(x - 1)
+				};
+				countDown(3)
+			};
+			wrapper()
+			`, "0"},
+		{`
+			let f = fn(x) {
+				let x = x + 1;
+				x
+			};
+			f(1)
+			`, "2"},
+		{"let g = fn() { let f = fn() { x }; let x = 1; f() }; g()", "1"},
+		{`
+			let g = fn() {
+				let fs = {};
+				for (let i = 0; i < 2; i++) {
+					if (true) { fs[i] = fn() { i + y }; }
+					let y = i * 10;
+				}
+				fs[0]() + fs[1]()
+			};
+			g()
+			`, "14"},
+	}},
+	{"Loops", []Test{
+		{"let i = 0; while (i < 5) { i++ } i", "5"},
+		{"let s = 0; for (let i = 0; i < 5; i++) { s += i } s", "10"},
+		{"let s = 0; for (x in [1, 2, 3]) { s += x } s", "6"},
+		{`let s = ""; for (c in "año") { s = c + s } s`, "oña"},
+		{`let s = ""; for (k in {"a": 1, "b": 2}) { s += k } s`, "ab"},
+		{"for (x in 1) { }", "ERROR: cannot iterate over INTEGER"},
+		{`
+			let s = 0;
+			for (let i = 0; i < 10; i++) {
+				if (i % 2 == 0) { continue; }
+				if (i > 6) { break; }
+				s += i;
+			}
+			s
+			`, "9"},
+		{`
+			let s = 0;
+			for (x in [1, 2, 3]) {
+				for (y in [10, 20, 30]) {
+					if (y == 20) { break; }
+					s += x * y;
+				}
+			}
+			s
+			`, "60"},
+		{`
+			let find = fn(xs, v) {
+				for (let i = 0; i < 10; i++) {
+					for (x in xs) {
+						if (x == v) { return i; }
+					}
+				}
+				-1
+			};
+			find([1, 2], 2)
+			`, "0"},
+		{`
+			let fns = {};
+			let n = 0;
+			for (x in [1, 2, 3]) {
+				let y = x * 10;
+				fns[n] = fn() { x + y };
+				n++;
+			}
+			fns[0]() + fns[2]()
+			`, "44"},
+		{`
+			let fns = {};
+			for (let i = 0; i < 3; i++) {
+				fns[i] = fn() { i };
+			}
+			fns[0]()
+			`, "3"},
+	}},
+	{"ControlFlowInExpressions", []Test{
+		{"let i = 0; while (true) { let x = if (i > 2) { break; } else { i }; i++; } i", "3"},
+		{"let s = 0; for (let i = 0; i < 4; i++) { s = s + if (i == 2) { continue; } else { i } } s", "4"},
+		{"let s = 0; for (x in [1, 2, 3]) { s += [if (x == 2) { continue; } else { x }][0] } s", "4"},
+		{"let x = true; let y = if (x) { return 5; } else { 2 }; y + 100", "5"},
+		{"[if (true) { return 7; }]", "7"},
+		{"let f = fn(x) { f(if (x) { return 1; } else { 0 }) + 10 }; f(true)", "1"},
+	}},
+	{"RuntimeErrors", []Test{
+		{"1 + true", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "ERROR: unknown operator: -BOOLEAN"},
+		{"1 / 0", "ERROR: division by zero"},
+		{"1.5 & 1", "ERROR: bitwise operator & requires integers, got FLOAT & INTEGER"},
+		{"1 << -1", "ERROR: negative shift count: -1"},
+		{"let f = fn() { 1 + true }; f(); 2", "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", "ERROR: stack overflow"},
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(300)", "300"},
+		// The main program and the 1023 calls of f(1022) reach the call
+		// depth limit.
+		{"let f = fn(n) { if (n == 0) { return 0; } n + f(n - 1) }; f(1022)", "522753"},
+		{"let f = fn(n) { if (n == 0) { return 0; } n + f(n - 1) }; f(1023)", "ERROR: stack overflow"},
+		{`
+			let f = fn(n) {
+				let a = 1; let b = 2; let c = 3; let d = 4; let e = 5;
+				let g = 6; let h = 7; let i = 8; let j = 9; let k = 10;
+				let l = 11; let m = 12; let o = 13; let p = 14; let q = 15;
+				let r = 16; let s = 17; let t = 18; let u = 19; let v = 20;
+				if (n == 0) { return a + v; }
+				f(n - 1) + 1
+			};
+			f(1022)
+			`, "1043"},
+	}},
+}
+
+// Run runs every suite as a subtest. run executes a program and returns
+// its result as the REPL prints it.
+func Run(t *testing.T, run func(program *ast.Program) string) {
+	for _, suite := range Suites {
+		t.Run(suite.Name, func(t *testing.T) {
+			for _, tt := range suite.Tests {
+				l := lexer.New(tt.Input)
+				p := parser.New(l)
+				program := p.ParseProgram()
+				if diagnostic.HasErrors(p.Diagnostics()) {
+					t.Fatalf("parser errors for %q: %v", tt.Input, p.Diagnostics())
+				}
+
+				if got := run(program); got != tt.Expected {
+					t.Errorf("wrong result for %q. want=%q, got=%q", tt.Input, tt.Expected, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+// Package decimal implements exact base-10 numbers for StaQ's decimal
+// literals, such as 1.10d. A Decimal is an arbitrary-precision integer
+// scaled by a power of ten, so 1.10 is 110 with a scale of 2. Addition,
+// subtraction and multiplication are always exact; division is exact when
+// the quotient has few enough digits and is rounded otherwise.
+package decimal
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// QuoScale is the number of digits after the decimal point that Quo keeps
+// when a quotient does not terminate, e.g. 1d / 3d.
+const QuoScale = 28
+
+// MaxScale bounds the number of digits after the decimal point, and
+// MaxDigits the number of digits before it, so that a single literal or
+// operation cannot exhaust memory.
+const (
+	MaxScale  = 1 << 16
+	MaxDigits = 1 << 20
+)
+
+var (
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrOutOfRange     = errors.New("decimal out of range")
+)
+
+var (
+	bigOne = big.NewInt(1)
+	bigTen = big.NewInt(10)
+)
+
+// Decimal is the exact value unscaled * 10**-scale. Decimals are
+// immutable, operations always return a new value. The zero value is 0.
+type Decimal struct {
+	unscaled *big.Int // nil means 0
+	scale    int32    // never negative
+}
+
+// New returns unscaled * 10**-scale. A negative scale multiplies unscaled
+// by the corresponding power of ten.
+func New(unscaled *big.Int, scale int32) Decimal {
+	u := new(big.Int).Set(unscaled)
+	if scale < 0 {
+		u.Mul(u, pow10(-scale))
+		scale = 0
+	}
+	return Decimal{unscaled: u, scale: scale}
+}
+
+// FromInt returns the decimal with the same value as x and a scale of 0.
+func FromInt(x *big.Int) Decimal {
+	return New(x, 0)
+}
+
+// Parse reads a decimal number written like a StaQ float literal: digits
+// with an optional fraction and exponent, where underscores may separate
+// digits. A leading sign is accepted. The scale of the result is the
+// number of digits after the decimal point minus the exponent, so "1.10"
+// keeps its trailing zero.
+func Parse(s string) (Decimal, error) {
+	text := strings.ReplaceAll(s, "_", "")
+
+	mantissa, exponent := text, int64(0)
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		mantissa = text[:i]
+		var err error
+		if exponent, err = strconv.ParseInt(text[i+1:], 10, 32); err != nil {
+			return Decimal{}, fmt.Errorf("%w: %q", ErrOutOfRange, s)
+		}
+	}
+
+	var scale int64
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		scale = int64(len(mantissa) - i - 1)
+		mantissa = mantissa[:i] + mantissa[i+1:]
+	}
+	scale -= exponent
+	if scale > MaxScale || -scale > MaxDigits {
+		return Decimal{}, fmt.Errorf("%w: %q", ErrOutOfRange, s)
+	}
+
+	unscaled, ok := new(big.Int).SetString(mantissa, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return New(unscaled, int32(scale)), nil
+}
+
+// Unscaled returns a copy of the unscaled integer value of d.
+func (d Decimal) Unscaled() *big.Int {
+	return new(big.Int).Set(d.int())
+}
+
+// Scale returns the number of digits of d after the decimal point.
+func (d Decimal) Scale() int32 { return d.scale }
+
+// Sign returns -1, 0 or +1 depending on the sign of d.
+func (d Decimal) Sign() int { return d.int().Sign() }
+
+// IsInt reports whether d has no fractional part.
+func (d Decimal) IsInt() bool {
+	return d.Reduce().scale == 0
+}
+
+// Int returns the integer part of d, truncated towards zero.
+func (d Decimal) Int() *big.Int {
+	return new(big.Int).Quo(d.int(), pow10(d.scale))
+}
+
+// Rat returns the exact value of d as a fraction.
+func (d Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(d.int(), pow10(d.scale))
+}
+
+// Reduce returns d with trailing zeros after the decimal point removed, so
+// that equal decimals reduce to the same unscaled value and scale.
+func (d Decimal) Reduce() Decimal {
+	u, scale := d.Unscaled(), d.scale
+	if u.Sign() == 0 {
+		return Decimal{}
+	}
+	r := new(big.Int)
+	for scale > 0 {
+		q, _ := new(big.Int).QuoRem(u, bigTen, r)
+		if r.Sign() != 0 {
+			break
+		}
+		u, scale = q, scale-1
+	}
+	return Decimal{unscaled: u, scale: scale}
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return Decimal{unscaled: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// Add returns d + e, whose scale is the larger of the two.
+func (d Decimal) Add(e Decimal) Decimal {
+	a, b, scale := align(d, e)
+	return Decimal{unscaled: a.Add(a, b), scale: scale}
+}
+
+// Sub returns d - e, whose scale is the larger of the two.
+func (d Decimal) Sub(e Decimal) Decimal {
+	a, b, scale := align(d, e)
+	return Decimal{unscaled: a.Sub(a, b), scale: scale}
+}
+
+// Mul returns d * e, whose scale is the sum of both. It fails only if that
+// scale exceeds MaxScale.
+func (d Decimal) Mul(e Decimal) (Decimal, error) {
+	scale := int64(d.scale) + int64(e.scale)
+	if scale > MaxScale {
+		return Decimal{}, ErrOutOfRange
+	}
+	return Decimal{unscaled: new(big.Int).Mul(d.int(), e.int()), scale: int32(scale)}, nil
+}
+
+// Quo returns d / e. The quotient is computed to QuoScale digits after the
+// decimal point, or more if either operand has more, and rounded half to
+// even. Trailing zeros are then dropped down to the larger scale of the
+// operands, so 1.10d / 2d is 0.55 and 10d / 4d is 2.5.
+func (d Decimal) Quo(e Decimal) (Decimal, error) {
+	if e.Sign() == 0 {
+		return Decimal{}, ErrDivisionByZero
+	}
+
+	scale := maxInt32(QuoScale, maxInt32(d.scale, e.scale))
+	// d / e = (du * 10**(scale + es - ds)) / eu * 10**-scale
+	num := new(big.Int).Mul(d.int(), pow10(scale+e.scale-d.scale))
+	q, r := new(big.Int).QuoRem(num, e.int(), new(big.Int))
+	roundHalfEven(q, r, e.int())
+
+	quo := Decimal{unscaled: q, scale: scale}.Reduce()
+	return quo.Rescale(maxInt32(d.scale, e.scale)), nil
+}
+
+// QuoFloor returns d / e rounded towards negative infinity, as an integer
+// with a scale of 0.
+func (d Decimal) QuoFloor(e Decimal) (Decimal, error) {
+	if e.Sign() == 0 {
+		return Decimal{}, ErrDivisionByZero
+	}
+	a, b, _ := align(d, e)
+	q, r := a.QuoRem(a, b, new(big.Int))
+	if r.Sign() != 0 && r.Sign() != b.Sign() {
+		q.Sub(q, bigOne)
+	}
+	return Decimal{unscaled: q}, nil
+}
+
+// Mod returns the remainder of QuoFloor, which has the sign of e, so that
+// d == d.QuoFloor(e) * e + d.Mod(e) always holds.
+func (d Decimal) Mod(e Decimal) (Decimal, error) {
+	q, err := d.QuoFloor(e)
+	if err != nil {
+		return Decimal{}, err
+	}
+	p, _ := q.Mul(e) // q has a scale of 0
+	return d.Sub(p), nil
+}
+
+// Pow returns d raised to the integer power n. A negative n divides 1 by
+// d**-n, with the rounding of Quo.
+func (d Decimal) Pow(n *big.Int) (Decimal, error) {
+	if n.Sign() < 0 {
+		p, err := d.Pow(new(big.Int).Neg(n))
+		if err != nil {
+			return Decimal{}, err
+		}
+		return FromInt(bigOne).Quo(p)
+	}
+
+	u := d.int()
+	if u.Sign() == 0 || u.CmpAbs(bigOne) == 0 && d.scale == 0 {
+		// 0, 1 and -1 to any power stay small.
+		if n.Sign() == 0 {
+			return FromInt(bigOne), nil
+		}
+		if u.Sign() < 0 && n.Bit(0) == 0 {
+			return FromInt(bigOne), nil
+		}
+		return d, nil
+	}
+
+	limit := int64(MaxDigits + MaxScale)
+	if !n.IsInt64() || n.Int64() > limit || int64(len(u.String()))*n.Int64() > limit ||
+		int64(d.scale)*n.Int64() > MaxScale {
+		return Decimal{}, ErrOutOfRange
+	}
+	return Decimal{
+		unscaled: new(big.Int).Exp(u, n, nil),
+		scale:    d.scale * int32(n.Int64()),
+	}, nil
+}
+
+// Rescale returns d with at least scale digits after the decimal point,
+// appending zeros if needed. It never drops digits.
+func (d Decimal) Rescale(scale int32) Decimal {
+	if scale <= d.scale {
+		return d
+	}
+	u := new(big.Int).Mul(d.int(), pow10(scale-d.scale))
+	return Decimal{unscaled: u, scale: scale}
+}
+
+// Cmp compares d and e by value and returns -1, 0 or +1. Trailing zeros
+// don't matter, 1.10 and 1.1 are equal.
+func (d Decimal) Cmp(e Decimal) int {
+	a, b, _ := align(d, e)
+	return a.Cmp(b)
+}
+
+// String formats d with exactly Scale digits after the decimal point.
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.int()).String()
+
+	var out strings.Builder
+	if d.Sign() < 0 {
+		out.WriteByte('-')
+	}
+	if d.scale == 0 {
+		out.WriteString(digits)
+		return out.String()
+	}
+
+	if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	point := len(digits) - int(d.scale)
+	out.WriteString(digits[:point])
+	out.WriteByte('.')
+	out.WriteString(digits[point:])
+	return out.String()
+}
+
+func (d Decimal) int() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return d.unscaled
+}
+
+// align returns copies of the unscaled values of d and e, brought to the
+// same, larger scale.
+func align(d, e Decimal) (*big.Int, *big.Int, int32) {
+	a, b := d.Rescale(e.scale), e.Rescale(d.scale)
+	return a.Unscaled(), b.Unscaled(), a.scale
+}
+
+// roundHalfEven rounds the truncated quotient q of some n / divisor with
+// remainder r to the nearest integer, and to the even one on a tie.
+func roundHalfEven(q, r, divisor *big.Int) {
+	if r.Sign() == 0 {
+		return
+	}
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+	c := twice.CmpAbs(divisor)
+	if c < 0 || c == 0 && q.Bit(0) == 0 {
+		return
+	}
+	// The exact quotient lies beyond q, away from zero.
+	if r.Sign() == divisor.Sign() {
+		q.Add(q, bigOne)
+	} else {
+		q.Sub(q, bigOne)
+	}
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+func maxInt32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
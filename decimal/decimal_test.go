@@ -0,0 +1,177 @@
+package decimal
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q) failed: %s", s, err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedScale int32
+	}{
+		{"1", "1", 0},
+		{"1.10", "1.10", 2},
+		{"0.05", "0.05", 2},
+		{"-0.5", "-0.5", 1},
+		{"1_000.000_1", "1000.0001", 4},
+		{"1.5e-3", "0.0015", 4},
+		{"1.5e3", "1500", 0},
+		{"12e+2", "1200", 0},
+		{"123456789012345678901234567890.5", "123456789012345678901234567890.5", 1},
+	}
+
+	for _, tt := range tests {
+		d := mustParse(t, tt.input)
+		if d.String() != tt.expected {
+			t.Errorf("Parse(%q) wrong. expected=%q, got=%q", tt.input, tt.expected, d.String())
+		}
+		if d.Scale() != tt.expectedScale {
+			t.Errorf("Parse(%q) scale wrong. expected=%d, got=%d", tt.input, tt.expectedScale, d.Scale())
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{"1e99999999999", "1e-70000", "1e2000000", "abc"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q) should fail", input)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		left, operator, right string
+		expected              string
+	}{
+		{"0.1", "+", "0.2", "0.3"},
+		{"1.10", "+", "1", "2.10"},
+		{"1", "-", "0.01", "0.99"},
+		{"1.10", "*", "3", "3.30"},
+		{"0.1", "*", "0.1", "0.01"},
+		{"1.10", "/", "2", "0.55"},
+		{"10", "/", "4", "2.5"},
+		{"6", "/", "3", "2"},
+		{"1", "/", "3", "0.3333333333333333333333333333"},
+		{"2", "/", "3", "0.6666666666666666666666666667"},
+		{"-2", "/", "3", "-0.6666666666666666666666666667"},
+		{"1.00", "/", "8", "0.125"},
+		{"7.5", "//", "2", "3"},
+		{"-7.5", "//", "2", "-4"},
+		{"7.5", "%", "2", "1.5"},
+		{"-7.5", "%", "2", "0.5"},
+		{"7.5", "%", "-2", "-0.5"},
+	}
+
+	for _, tt := range tests {
+		left, right := mustParse(t, tt.left), mustParse(t, tt.right)
+
+		var got Decimal
+		var err error
+		switch tt.operator {
+		case "+":
+			got = left.Add(right)
+		case "-":
+			got = left.Sub(right)
+		case "*":
+			got, err = left.Mul(right)
+		case "/":
+			got, err = left.Quo(right)
+		case "//":
+			got, err = left.QuoFloor(right)
+		case "%":
+			got, err = left.Mod(right)
+		}
+		if err != nil {
+			t.Errorf("%s %s %s failed: %s", tt.left, tt.operator, tt.right, err)
+			continue
+		}
+		if got.String() != tt.expected {
+			t.Errorf("%s %s %s wrong. expected=%q, got=%q",
+				tt.left, tt.operator, tt.right, tt.expected, got.String())
+		}
+	}
+}
+
+func TestRoundHalfEven(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0.5", "0"},
+		{"1.5", "2"},
+		{"2.5", "2"},
+		{"2.51", "3"},
+		{"-2.5", "-2"},
+		{"-3.5", "-4"},
+	}
+
+	for _, tt := range tests {
+		d := mustParse(t, tt.input)
+		// Dividing by 10**scale and back rounds to an integer.
+		q, r := new(big.Int).QuoRem(d.Unscaled(), pow10(d.Scale()), new(big.Int))
+		roundHalfEven(q, r, pow10(d.Scale()))
+		if q.String() != tt.expected {
+			t.Errorf("round(%s) wrong. expected=%s, got=%s", tt.input, tt.expected, q)
+		}
+	}
+}
+
+func TestPow(t *testing.T) {
+	tests := []struct {
+		base     string
+		exponent int64
+		expected string
+	}{
+		{"1.1", 2, "1.21"},
+		{"2", 10, "1024"},
+		{"0.5", 0, "1"},
+		{"2", -2, "0.25"},
+		{"-1", 1 << 40, "1"},
+		{"-1", 1<<40 + 1, "-1"},
+		{"0", 1 << 40, "0"},
+	}
+
+	for _, tt := range tests {
+		got, err := mustParse(t, tt.base).Pow(big.NewInt(tt.exponent))
+		if err != nil {
+			t.Errorf("%s ** %d failed: %s", tt.base, tt.exponent, err)
+			continue
+		}
+		if got.String() != tt.expected {
+			t.Errorf("%s ** %d wrong. expected=%q, got=%q", tt.base, tt.exponent, tt.expected, got.String())
+		}
+	}
+
+	if _, err := mustParse(t, "0").Pow(big.NewInt(-1)); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("0 ** -1 should be a division by zero, got %v", err)
+	}
+	if _, err := mustParse(t, "1.5").Pow(big.NewInt(1 << 40)); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("1.5 ** 2**40 should be out of range, got %v", err)
+	}
+}
+
+func TestCmpAndReduce(t *testing.T) {
+	a, b := mustParse(t, "1.10"), mustParse(t, "1.1")
+	if a.Cmp(b) != 0 {
+		t.Errorf("1.10 and 1.1 should be equal")
+	}
+	if a.Reduce().String() != "1.1" || !mustParse(t, "2.000").IsInt() {
+		t.Errorf("Reduce wrong. got=%q", a.Reduce().String())
+	}
+	if mustParse(t, "-0.01").Cmp(Decimal{}) >= 0 {
+		t.Errorf("-0.01 should be less than 0")
+	}
+}
@@ -0,0 +1,88 @@
+package diagnostic
+
+import (
+	"fmt"
+	"staq/token"
+)
+
+// Severity tells how serious a diagnostic is.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// MarshalText encodes the severity by name, so that diagnostics serialized
+// as JSON read "error" instead of 0.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Code is a stable identifier for a kind of diagnostic, e.g. "E0001".
+// Messages may change between versions, codes do not.
+type Code string
+
+// Span is the range of source text a diagnostic refers to. End is the
+// position immediately after the last character.
+type Span struct {
+	Start token.Position `json:"start"`
+	End   token.Position `json:"end"`
+}
+
+// SpanOf returns the span covered by a token.
+func SpanOf(tok token.Token) Span {
+	return Span{Start: tok.Pos, End: tok.End}
+}
+
+// Related points at another piece of source that helps explain a
+// diagnostic, e.g. the opening delimiter of an unclosed list.
+type Related struct {
+	Span    Span   `json:"span"`
+	Message string `json:"message"`
+}
+
+// Diagnostic is a single problem found in a source file.
+type Diagnostic struct {
+	Severity Severity  `json:"severity"`
+	Code     Code      `json:"code"`
+	Message  string    `json:"message"`
+	Span     Span      `json:"span"`
+	Hints    []string  `json:"hints,omitempty"`
+	Related  []Related `json:"related,omitempty"`
+}
+
+// String formats the diagnostic on a single line as position: message.
+func (d Diagnostic) String() string {
+	return d.Span.Start.String() + ": " + d.Message
+}
+
+// Error makes a Diagnostic usable as an error value.
+func (d Diagnostic) Error() string {
+	return d.String()
+}
+
+// HasErrors reports whether any of diagnostics is an error, as opposed to
+// warnings and notes.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package diagnostic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Render writes d to w the way a compiler would print it to a terminal:
+// a header with the severity, code and message, followed by the offending
+// source line with the span underlined by carets, any related spans and
+// finally the hints. source must be the text the span refers to.
+//
+//	error[E0001]: expected next token to be ), got ; instead
+//	 --> main.sq:1:9
+//	  |
+//	1 | add(1, 2;
+//	  |         ^
+//	  = hint: ...
+func Render(w io.Writer, source string, d Diagnostic) error {
+	var out bytes.Buffer
+
+	out.WriteString(d.Severity.String())
+	if d.Code != "" {
+		out.WriteString("[" + string(d.Code) + "]")
+	}
+	out.WriteString(": " + d.Message + "\n")
+
+	gutter := gutterWidth(d)
+	writeSnippet(&out, source, d.Span, "", gutter)
+
+	for _, r := range d.Related {
+		writeSnippet(&out, source, r.Span, r.Message, gutter)
+	}
+
+	for _, hint := range d.Hints {
+		fmt.Fprintf(&out, "%s = hint: %s\n", strings.Repeat(" ", gutter), hint)
+	}
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// RenderAll renders every diagnostic, separated by blank lines.
+func RenderAll(w io.Writer, source string, diagnostics []Diagnostic) error {
+	for i, d := range diagnostics {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if err := Render(w, source, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func gutterWidth(d Diagnostic) int {
+	width := len(strconv.Itoa(d.Span.Start.Line))
+	for _, r := range d.Related {
+		if w := len(strconv.Itoa(r.Span.Start.Line)); w > width {
+			width = w
+		}
+	}
+	return width
+}
+
+// writeSnippet prints the first line of span with a caret underline. Spans
+// that continue on later lines are underlined up to the end of their first
+// line. label, if any, is printed after the carets.
+func writeSnippet(out *bytes.Buffer, source string, span Span, label string, gutter int) {
+	pad := strings.Repeat(" ", gutter)
+	fmt.Fprintf(out, "%s--> %s\n", pad, span.Start)
+
+	start := span.Start.Offset
+	if !span.Start.IsValid() || start < 0 || start > len(source) {
+		return
+	}
+
+	lineStart := strings.LastIndexByte(source[:start], '\n') + 1
+	lineEnd := strings.IndexByte(source[start:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(source)
+	} else {
+		lineEnd += start
+	}
+
+	end := span.End.Offset
+	if end > lineEnd {
+		end = lineEnd
+	}
+	if end < start {
+		end = start
+	}
+
+	line := strings.TrimRight(source[lineStart:lineEnd], "\r")
+
+	// Keep tabs in the indentation so the carets line up with the source.
+	var indent strings.Builder
+	for _, r := range source[lineStart:start] {
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+
+	width := utf8.RuneCountInString(source[start:end])
+	if width == 0 {
+		width = 1
+	}
+
+	fmt.Fprintf(out, "%s |\n", pad)
+	fmt.Fprintf(out, "%*d | %s\n", gutter, span.Start.Line, line)
+	fmt.Fprintf(out, "%s | %s%s", pad, indent.String(), strings.Repeat("^", width))
+	if label != "" {
+		out.WriteString(" " + label)
+	}
+	out.WriteString("\n")
+}
@@ -0,0 +1,90 @@
+package diagnostic
+
+import (
+	"bytes"
+	"staq/token"
+	"testing"
+)
+
+func pos(offset, line, column int) token.Position {
+	return token.Position{Filename: "main.sq", Offset: offset, Line: line, Column: column}
+}
+
+func TestRender(t *testing.T) {
+	source := "let x = 1;\nadd(1, 2;\n"
+
+	d := Diagnostic{
+		Severity: Error,
+		Code:     "E0001",
+		Message:  "expected next token to be ), got ; instead",
+		Span:     Span{Start: pos(19, 2, 9), End: pos(20, 2, 10)},
+		Hints:    []string{"close the argument list"},
+		Related: []Related{
+			{Span: Span{Start: pos(14, 2, 4), End: pos(15, 2, 5)}, Message: "unclosed ( opened here"},
+		},
+	}
+
+	expected := `error[E0001]: expected next token to be ), got ; instead
+ --> main.sq:2:9
+  |
+2 | add(1, 2;
+  |         ^
+ --> main.sq:2:4
+  |
+2 | add(1, 2;
+  |    ^ unclosed ( opened here
+  = hint: close the argument list
+`
+
+	var out bytes.Buffer
+	if err := Render(&out, source, d); err != nil {
+		t.Fatalf("Render returned an error: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("Render output wrong.\nexpected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRenderUnderlinesWholeSpan(t *testing.T) {
+	tests := []struct {
+		source   string
+		span     Span
+		expected string
+	}{
+		{
+			"\tfoo(\"unterminated",
+			Span{Start: pos(5, 1, 6), End: pos(18, 1, 19)},
+			"warning: msg\n --> main.sq:1:6\n  |\n1 | \tfoo(\"unterminated\n  | \t    ^^^^^^^^^^^^^\n",
+		},
+		{
+			// Spans running past the end of the line stop at the newline.
+			"fn() {\n}",
+			Span{Start: pos(5, 1, 6), End: pos(8, 2, 2)},
+			"warning: msg\n --> main.sq:1:6\n  |\n1 | fn() {\n  |      ^\n",
+		},
+		{
+			// Empty spans, e.g. at EOF, still get a caret.
+			"1 +",
+			Span{Start: pos(3, 1, 4), End: pos(3, 1, 4)},
+			"warning: msg\n --> main.sq:1:4\n  |\n1 | 1 +\n  |    ^\n",
+		},
+	}
+
+	for i, tt := range tests {
+		var out bytes.Buffer
+		Render(&out, tt.source, Diagnostic{Severity: Warning, Message: "msg", Span: tt.span})
+
+		if out.String() != tt.expected {
+			t.Errorf("tests[%d] - Render output wrong.\nexpected:\n%q\ngot:\n%q", i, tt.expected, out.String())
+		}
+	}
+}
+
+func TestDiagnosticString(t *testing.T) {
+	d := Diagnostic{Message: "boom", Span: Span{Start: pos(0, 3, 14)}}
+
+	if d.String() != "main.sq:3:14: boom" {
+		t.Errorf("d.String() wrong. got=%q", d.String())
+	}
+}
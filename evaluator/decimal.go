@@ -0,0 +1,108 @@
+package evaluator
+
+import (
+	"math"
+	"math/big"
+	"staq/decimal"
+	"staq/object"
+)
+
+// evalDecimalInfixExpression evaluates operator when at least one operand
+// is a decimal. Integers are converted to decimals exactly, so the result
+// is a decimal too. Floats are inexact, so mixing them with decimals in
+// arithmetic is an error, but they can still be compared.
+func evalDecimalInfixExpression(operator string, left, right object.Object) object.Object {
+	if left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ {
+		if isComparison(operator) {
+			return compareExactly(operator, left, right)
+		}
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	leftVal, rightVal := toDecimal(left), toDecimal(right)
+
+	var result decimal.Decimal
+	var err error
+	switch operator {
+	case "+":
+		result = leftVal.Add(rightVal)
+	case "-":
+		result = leftVal.Sub(rightVal)
+	case "*":
+		result, err = leftVal.Mul(rightVal)
+	case "/":
+		result, err = leftVal.Quo(rightVal)
+	case "~/":
+		result, err = leftVal.QuoFloor(rightVal)
+	case "%":
+		result, err = leftVal.Mod(rightVal)
+	case "**":
+		if !rightVal.IsInt() {
+			return newError("decimal exponent must be a whole number, got %s", right.Inspect())
+		}
+		result, err = leftVal.Pow(rightVal.Int())
+	case "<", ">", "<=", ">=", "==", "!=":
+		return compare(operator, leftVal.Cmp(rightVal))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+
+	if err != nil {
+		return newError("%s", err)
+	}
+	return &object.Decimal{Value: result}
+}
+
+// compareExactly compares a float with a decimal by their exact values,
+// not by rounding the decimal to a float.
+func compareExactly(operator string, left, right object.Object) object.Object {
+	for _, obj := range []object.Object{left, right} {
+		if f, ok := obj.(*object.Float); ok && (math.IsNaN(f.Value) || math.IsInf(f.Value, 0)) {
+			return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
+		}
+	}
+	return compare(operator, toRat(left).Cmp(toRat(right)))
+}
+
+func isComparison(operator string) bool {
+	switch operator {
+	case "<", ">", "<=", ">=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+// compare turns the result of a Cmp method into the result of operator.
+func compare(operator string, cmp int) object.Object {
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(cmp < 0)
+	case ">":
+		return nativeBoolToBooleanObject(cmp > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(cmp <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(cmp >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(cmp == 0)
+	default:
+		return nativeBoolToBooleanObject(cmp != 0)
+	}
+}
+
+func toDecimal(obj object.Object) decimal.Decimal {
+	if d, ok := obj.(*object.Decimal); ok {
+		return d.Value
+	}
+	return decimal.FromInt(toBigInt(obj))
+}
+
+func toRat(obj object.Object) *big.Rat {
+	switch obj := obj.(type) {
+	case *object.Float:
+		return new(big.Rat).SetFloat64(obj.Value)
+	case *object.Decimal:
+		return obj.Value.Rat()
+	}
+	return new(big.Rat).SetInt(toBigInt(obj))
+}
@@ -0,0 +1,907 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"staq/ast"
+	"staq/object"
+	"strings"
+)
+
+// MaxCallDepth is the number of nested function calls a program can make,
+// counting the top level, before it fails with a stack overflow.
+const MaxCallDepth = 1024
+
+var (
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
+)
+
+// Eval evaluates the given node in env and returns the resulting value.
+// Runtime errors are returned as *object.Error values.
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+
+	// Statements
+	case *ast.Program:
+		return evalProgram(node, env)
+
+	case *ast.BlockStatement:
+		return evalBlockStatement(node, object.NewEnclosedEnvironment(env))
+
+	case *ast.ExpressionStatement:
+		return Eval(node.Expression, env)
+
+	case *ast.ReturnStatement:
+		if node.ReturnValue == nil {
+			return &object.ReturnValue{Value: NULL}
+		}
+		val := Eval(node.ReturnValue, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+
+	case *ast.LetStatement:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		env.Set(node.Name.Value, val)
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
+	// Expressions
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInteger{Value: node.Big}
+		}
+		return &object.Integer{Value: node.Value}
+
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
+	case *ast.DecimalLiteral:
+		return &object.Decimal{Value: node.Value}
+
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}
+
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(node.Value)
+
+	case *ast.NullLiteral:
+		return NULL
+
+	case *ast.PrefixExpression:
+		if node.Operator == "++" || node.Operator == "--" {
+			return evalIncrement(node.Right, node.Operator, false, env)
+		}
+		right := Eval(node.Right, env)
+		if isAbrupt(right) {
+			return right
+		}
+		return evalPrefixExpression(node.Operator, right)
+
+	case *ast.InfixExpression:
+		left := Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		switch node.Operator {
+		case "&&", "||", "??":
+			return evalShortCircuit(node.Operator, left, node.Right, env)
+		}
+		right := Eval(node.Right, env)
+		if isAbrupt(right) {
+			return right
+		}
+		return evalInfixExpression(node.Operator, left, right)
+
+	case *ast.IfExpression:
+		return evalIfExpression(node, env)
+
+	case *ast.Identifier:
+		return evalIdentifier(node, env)
+
+	case *ast.FunctionLiteral:
+		return &object.Function{Parameters: node.Parameters, Body: node.Body, Env: env}
+
+	case *ast.CallExpression:
+		function := Eval(node.Function, env)
+		if isAbrupt(function) {
+			return function
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
+			return args[0]
+		}
+		return applyFunction(function, args, env)
+
+	case *ast.ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
+			return elements[0]
+		}
+		return &object.Array{Elements: elements}
+
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env)
+
+	case *ast.IndexExpression:
+		left := Eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		if node.Optional && left == NULL {
+			return NULL
+		}
+		index := Eval(node.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		return evalIndexExpression(left, index)
+
+	case *ast.MemberExpression:
+		object := Eval(node.Object, env)
+		if isAbrupt(object) {
+			return object
+		}
+		if node.Optional && object == NULL {
+			return NULL
+		}
+		return evalMemberExpression(object, node.Property.Value)
+
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+
+	case *ast.PostfixExpression:
+		return evalIncrement(node.Left, node.Operator, true, env)
+	}
+
+	return nil
+}
+
+func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	var result object.Object
+
+	for _, statement := range program.Statements {
+		result = Eval(statement, env)
+
+		switch result := result.(type) {
+		case *object.ReturnValue:
+			return result.Value
+		case *object.Error:
+			return result
+		}
+	}
+
+	return result
+}
+
+// evalBlockStatement stops at the first return value or error but, unlike
+// evalProgram, does not unwrap return values so that they keep bubbling up
+// to the enclosing function call. Callers decide which environment the
+// block runs in: nested blocks get a fresh scope so that their let
+// statements shadow outer bindings instead of overwriting them.
+func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	var result object.Object
+
+	for _, statement := range block.Statements {
+		result = Eval(statement, env)
+
+		if isAbrupt(result) {
+			return result
+		}
+	}
+
+	return result
+}
+
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !object.IsTruthy(condition) {
+			return nil
+		}
+
+		if result, done := unwindLoop(Eval(ws.Body, env)); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	// Variables declared in the loop header are scoped to the loop.
+	loopEnv := object.NewEnclosedEnvironment(env)
+
+	if fs.Init != nil {
+		if init := Eval(fs.Init, loopEnv); isAbrupt(init) {
+			return init
+		}
+	}
+
+	for {
+		if fs.Condition != nil {
+			condition := Eval(fs.Condition, loopEnv)
+			if isAbrupt(condition) {
+				return condition
+			}
+			if !object.IsTruthy(condition) {
+				return nil
+			}
+		}
+
+		if result, done := unwindLoop(Eval(fs.Body, loopEnv)); done {
+			return result
+		}
+
+		if fs.Post != nil {
+			if post := Eval(fs.Post, loopEnv); isAbrupt(post) {
+				return post
+			}
+		}
+	}
+}
+
+func evalForInStatement(fs *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+
+	elements, err := Iterate(iterable)
+	if err != nil {
+		return err
+	}
+
+	for _, element := range elements {
+		// Every iteration gets a fresh binding, so closures created in
+		// the body capture the element of their own iteration.
+		iterEnv := object.NewEnclosedEnvironment(env)
+		iterEnv.Set(fs.Variable.Value, element)
+
+		if result, done := unwindLoop(evalBlockStatement(fs.Body, iterEnv)); done {
+			return result
+		}
+	}
+
+	return nil
+}
+
+// unwindLoop inspects the result of a loop body and reports whether the
+// loop is done. A break ends the loop, and a return value or an error ends
+// it too, but keeps unwinding past it. A continue, like a body that ran to
+// completion, moves on to the next iteration.
+func unwindLoop(result object.Object) (object.Object, bool) {
+	switch result.(type) {
+	case *object.Break:
+		return nil, true
+	case *object.ReturnValue, *object.Error:
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+	var result []object.Object
+
+	for _, e := range exps {
+		evaluated := Eval(e, env)
+		if isAbrupt(evaluated) {
+			return []object.Object{evaluated}
+		}
+		result = append(result, evaluated)
+	}
+
+	return result
+}
+
+func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if val, ok := env.Get(node.Value); ok {
+		return val
+	}
+	return newError("identifier not found: " + node.Value)
+}
+
+func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := Eval(ie.Condition, env)
+	if isAbrupt(condition) {
+		return condition
+	}
+
+	if object.IsTruthy(condition) {
+		return Eval(ie.Consequence, env)
+	} else if ie.Alternative != nil {
+		return Eval(ie.Alternative, env)
+	} else {
+		return NULL
+	}
+}
+
+// evalShortCircuit evaluates the operators that only evaluate their
+// right side when the left one does not decide the result. && and ||
+// always evaluate to a boolean, following object.IsTruthy, while ?? gives
+// back the left value unless it is null.
+func evalShortCircuit(operator string, left object.Object, right ast.Expression, env *object.Environment) object.Object {
+	switch {
+	case operator == "&&" && !object.IsTruthy(left):
+		return FALSE
+	case operator == "||" && object.IsTruthy(left):
+		return TRUE
+	case operator == "??" && left != NULL:
+		return left
+	}
+
+	val := Eval(right, env)
+	if isAbrupt(val) || operator == "??" {
+		return val
+	}
+	return nativeBoolToBooleanObject(object.IsTruthy(val))
+}
+
+func evalPrefixExpression(operator string, right object.Object) object.Object {
+	switch operator {
+	case "!":
+		return evalBangOperatorExpression(right)
+	case "-":
+		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalBitNotPrefixOperatorExpression(right)
+	default:
+		return newError("unknown operator: %s%s", operator, right.Type())
+	}
+}
+
+func evalBangOperatorExpression(right object.Object) object.Object {
+	return nativeBoolToBooleanObject(!object.IsTruthy(right))
+}
+
+func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		if negated, ok := negInt(right.Value); ok {
+			return &object.Integer{Value: negated}
+		}
+		return object.NewInteger(new(big.Int).Neg(big.NewInt(right.Value)))
+	case *object.BigInteger:
+		return object.NewInteger(new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	case *object.Decimal:
+		return &object.Decimal{Value: right.Value.Neg()}
+	default:
+		return newError("unknown operator: -%s", right.Type())
+	}
+}
+
+func evalBitNotPrefixOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.BigInteger:
+		return object.NewInteger(new(big.Int).Not(right.Value))
+	default:
+		return newError("unknown operator: ~%s", right.Type())
+	}
+}
+
+func evalInfixExpression(operator string, left, right object.Object) object.Object {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return evalIntegerInfixExpression(operator, left, right)
+	case isBitwiseOperator(operator):
+		return newError("bitwise operator %s requires integers, got %s %s %s",
+			operator, left.Type(), operator, right.Type())
+	case isNumber(left) && isNumber(right) &&
+		(left.Type() == object.DECIMAL_OBJ || right.Type() == object.DECIMAL_OBJ):
+		return evalDecimalInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
+	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
+		return evalBooleanInfixExpression(operator, left, right)
+	case operator == "==":
+		return nativeBoolToBooleanObject(left == right)
+	case operator == "!=":
+		return nativeBoolToBooleanObject(left != right)
+	case left.Type() != right.Type():
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalIntegerInfixExpression computes with int64s as long as the operands
+// and the result fit, and with big integers otherwise.
+func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+	if !leftOk || !rightOk {
+		return evalBigIntegerInfixExpression(operator, toBigInt(left), toBigInt(right))
+	}
+	leftVal, rightVal := leftInt.Value, rightInt.Value
+
+	switch operator {
+	case "+":
+		if sum, ok := addInt(leftVal, rightVal); ok {
+			return &object.Integer{Value: sum}
+		}
+	case "-":
+		if diff, ok := subInt(leftVal, rightVal); ok {
+			return &object.Integer{Value: diff}
+		}
+	case "*":
+		if product, ok := mulInt(leftVal, rightVal); ok {
+			return &object.Integer{Value: product}
+		}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: float64(leftVal) / float64(rightVal)}
+	case "~/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		if quotient, ok := floorDiv(leftVal, rightVal); ok {
+			return &object.Integer{Value: quotient}
+		}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: floorMod(leftVal, rightVal)}
+	case "**":
+		if rightVal < 0 {
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		if power, ok := powInt(leftVal, rightVal); ok {
+			return &object.Integer{Value: power}
+		}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if shifted, ok := shlInt(leftVal, rightVal); ok {
+			return &object.Integer{Value: shifted}
+		}
+	case ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		return &object.Integer{Value: shrInt(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+	return evalBigIntegerInfixExpression(operator, big.NewInt(leftVal), big.NewInt(rightVal))
+}
+
+func evalFloatInfixExpression(operator string, leftVal, rightVal float64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "~/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Floor(leftVal / rightVal)}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal - rightVal*math.Floor(leftVal/rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", object.FLOAT_OBJ, operator, object.FLOAT_OBJ)
+	}
+}
+
+func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalBooleanInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.Boolean).Value
+	rightVal := right.(*object.Boolean).Value
+
+	switch operator {
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalIndexExpression(left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(left, index)
+	default:
+		return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+func evalHashIndexExpression(hash, index object.Object) object.Object {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+
+	value, ok := hashObject.Get(key)
+	if !ok {
+		return NULL
+	}
+
+	return value
+}
+
+// evalMemberExpression looks name up in a hash, obj.name is the same as
+// obj["name"].
+func evalMemberExpression(obj object.Object, name string) object.Object {
+	if obj.Type() != object.HASH_OBJ {
+		return newError("member access not supported: %s.%s", obj.Type(), name)
+	}
+	return evalHashIndexExpression(obj, &object.String{Value: name})
+}
+
+// evalAssignExpression evaluates the parts of the target, then the value,
+// and stores the value. Compound assignments combine the current value of
+// the target with the new one using the operator without its trailing =,
+// so a[f()] += 1 calls f only once. The result is the stored value.
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	operator := strings.TrimSuffix(node.Operator, "=")
+
+	return updateTarget(node.Target, env, operator != "", func(current object.Object) object.Object {
+		val := Eval(node.Value, env)
+		if isAbrupt(val) || operator == "" {
+			return val
+		}
+		return evalInfixExpression(operator, current, val)
+	})
+}
+
+// evalIncrement evaluates ++ and -- on target. The prefix forms evaluate to
+// the updated value, the postfix forms to the value before the update.
+func evalIncrement(target ast.Expression, operator string, postfix bool, env *object.Environment) object.Object {
+	var old object.Object
+
+	updated := updateTarget(target, env, true, func(current object.Object) object.Object {
+		old = current
+		return Increment(operator, postfix, current)
+	})
+
+	if isAbrupt(updated) || !postfix {
+		return updated
+	}
+	return old
+}
+
+// updateTarget stores the result of update in target, an identifier, index
+// expression or member expression, and returns it. The parts of the target
+// are evaluated first and then, if read is set, its current value, which is
+// passed to update. Assigning to an undeclared variable is an error.
+func updateTarget(target ast.Expression, env *object.Environment, read bool, update func(current object.Object) object.Object) object.Object {
+	switch target := target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if read {
+			current = evalIdentifier(target, env)
+			if isAbrupt(current) {
+				return current
+			}
+		} else if _, ok := env.Get(target.Value); !ok {
+			return newError("assignment to undeclared variable: %s", target.Value)
+		}
+
+		val := update(current)
+		if isAbrupt(val) {
+			return val
+		}
+		env.Assign(target.Value, val)
+		return val
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+
+		var current object.Object
+		if read {
+			current = evalIndexExpression(left, index)
+			if isAbrupt(current) {
+				return current
+			}
+		}
+
+		val := update(current)
+		if isAbrupt(val) {
+			return val
+		}
+		return evalIndexAssignment(left, index, val)
+
+	case *ast.MemberExpression:
+		obj := Eval(target.Object, env)
+		if isAbrupt(obj) {
+			return obj
+		}
+
+		// Reading the member also rejects objects that are not hashes.
+		var current object.Object
+		if read || obj.Type() != object.HASH_OBJ {
+			current = evalMemberExpression(obj, target.Property.Value)
+			if isAbrupt(current) {
+				return current
+			}
+		}
+
+		val := update(current)
+		if isAbrupt(val) {
+			return val
+		}
+		return evalIndexAssignment(obj, &object.String{Value: target.Property.Value}, val)
+
+	default:
+		return newError("cannot assign to %s", target.String())
+	}
+}
+
+func evalIndexAssignment(left, index, val object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		idx, ok := index.(*object.Integer)
+		if !ok || idx.Value < 0 || idx.Value >= int64(len(elements)) {
+			return newError("index out of range: %s", index.Inspect())
+		}
+		elements[idx.Value] = val
+		return val
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Set(key, val)
+		return val
+	default:
+		return newError("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	hash := object.NewHash()
+
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
+		if isAbrupt(key) {
+			return key
+		}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+
+		value := Eval(pair.Value, env)
+		if isAbrupt(value) {
+			return value
+		}
+
+		hash.Set(hashKey, value)
+	}
+
+	return hash
+}
+
+// evalArrayIndexExpression returns NULL for indexes outside the array
+// instead of failing, so that probing past the end is not an error.
+func evalArrayIndexExpression(array, index object.Object) object.Object {
+	arrayObject := array.(*object.Array)
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return NULL
+	}
+	idx := integer.Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	if idx < 0 || idx > max {
+		return NULL
+	}
+
+	return arrayObject.Elements[idx]
+}
+
+// applyFunction calls fn from the environment env.
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
+	function, ok := fn.(*object.Function)
+	if !ok {
+		return newError("not a function: %s", fn.Type())
+	}
+
+	if len(args) != len(function.Parameters) {
+		return newError("wrong number of arguments: want=%d, got=%d",
+			len(function.Parameters), len(args))
+	}
+
+	// The top level counts as a call, like the main frame of the virtual
+	// machines.
+	if env.CallDepth()+1 >= MaxCallDepth {
+		return newError("stack overflow")
+	}
+
+	extendedEnv := extendFunctionEnv(function, args, env)
+	evaluated := evalBlockStatement(function.Body, extendedEnv)
+	return unwrapReturnValue(evaluated)
+}
+
+func extendFunctionEnv(fn *object.Function, args []object.Object, caller *object.Environment) *object.Environment {
+	env := object.NewCallEnvironment(fn.Env, caller)
+
+	for paramIdx, param := range fn.Parameters {
+		env.Set(param.Value, args[paramIdx])
+	}
+
+	return env
+}
+
+func unwrapReturnValue(obj object.Object) object.Object {
+	if returnValue, ok := obj.(*object.ReturnValue); ok {
+		return returnValue.Value
+	}
+	if obj == nil {
+		return NULL
+	}
+	return obj
+}
+
+func isNumber(obj object.Object) bool {
+	switch obj.Type() {
+	case object.INTEGER_OBJ, object.FLOAT_OBJ, object.DECIMAL_OBJ:
+		return true
+	}
+	return false
+}
+
+// toFloat converts any number to the nearest float64.
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInteger:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *object.Float:
+		return obj.Value
+	case *object.Decimal:
+		f, _ := obj.Value.Rat().Float64()
+		return f
+	}
+	return 0
+}
+
+// isBitwiseOperator reports whether operator only works on integers. Floats
+// have no meaningful bit pattern to operate on, so they are never converted.
+func isBitwiseOperator(operator string) bool {
+	switch operator {
+	case "&", "|", "^", "<<", ">>":
+		return true
+	}
+	return false
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// isAbrupt reports whether obj stops the evaluation of the enclosing
+// expressions and statements: an error, a return value, or the signal of a
+// break or continue statement. Such results are passed up unchanged until
+// a loop, a function call or the program handles them, even from inside an
+// if expression used as a value.
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,1047 @@
+package evaluator
+
+import (
+	"staq/ast"
+	"staq/conformance"
+	"staq/lexer"
+	"staq/object"
+	"staq/parser"
+	"testing"
+)
+
+func TestEvalIntegerExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"5", 5},
+		{"10", 10},
+		{"-5", -5},
+		{"-10", -10},
+		{"5 + 5 + 5 + 5 - 10", 10},
+		{"2 * 2 * 2 * 2 * 2", 32},
+		{"-50 + 100 + -50", 0},
+		{"5 * 2 + 10", 20},
+		{"5 + 2 * 10", 25},
+		{"20 + 2 * -10", 0},
+		{"2 * (5 + 10)", 30},
+		{"3 * 3 * 3 + 10", 37},
+		{"3 * (3 * 3) + 10", 37},
+		{"7 ~/ 2", 3},
+		{"-7 ~/ 2", -4},
+		{"7 % 3", 1},
+		{"-7 % 3", 2},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 2", 4},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"256 >> 4", 16},
+		{"0xFF", 255},
+		{"0o77", 63},
+		{"0b1010 | 0b0101", 15},
+		{"1_000 * 0x10", 16000},
+		{"-1 << 62", -4611686018427387904},
+		{"0 << 100", 0},
+		{"-7 >> 1", -4},
+		{"1 >> 64", 0},
+		{"-1 >> 100", -1},
+		{"9223372036854775807 + -9223372036854775807", 0},
+		{"-9223372036854775807 - 1", -9223372036854775808},
+		{"(-9223372036854775807 - 1) ~/ 1", -9223372036854775808},
+		{"(-2) ** 63", -9223372036854775808},
+		{"(-1) ** 9223372036854775807", -1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2", 3.5},
+		{"10 * (20 / 2)", 100.0},
+		{"7.5 ~/ 2", 3.0},
+		{"2 ** -1", 0.5},
+		{"1.5e3", 1500.0},
+		{"2.5e-1 * 4", 1.0},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		big      bool
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808", true},
+		{"-9223372036854775807 - 2", "-9223372036854775809", true},
+		{"4294967296 * 4294967296", "18446744073709551616", true},
+		{"let min = -9223372036854775807 - 1; min * -1", "9223372036854775808", true},
+		{"let min = -9223372036854775807 - 1; -min", "9223372036854775808", true},
+		{"let min = -9223372036854775807 - 1; min ~/ -1", "9223372036854775808", true},
+		{"2 ** 63", "9223372036854775808", true},
+		{"2 ** 100", "1267650600228229401496703205376", true},
+		{"1 << 64", "18446744073709551616", true},
+		{"let x = 9223372036854775807; x += 1", "9223372036854775808", true},
+		{"let x = 9223372036854775807; x++; x", "9223372036854775808", true},
+		{"99999999999999999999", "99999999999999999999", true},
+		{"-99999999999999999999 ~/ 7", "-14285714285714285715", true},
+		{"-99999999999999999999 % 7", "6", false},
+		{"~99999999999999999999", "-100000000000000000000", true},
+		{"99999999999999999999 & 0xFF", "255", false},
+		{"-99999999999999999999 >> 1000", "-1", false},
+		// Results that fit in an int64 again are plain integers.
+		{"(9223372036854775807 + 1) - 1", "9223372036854775807", false},
+		{"-9223372036854775808", "-9223372036854775808", false},
+		{"99999999999999999999 / 99999999999999999999", "1.0", false},
+		{"99999999999999999999 > 9223372036854775807", "true", false},
+		{"99999999999999999999 == 99999999999999999999", "true", false},
+		{"99999999999999999999 < 1.5", "false", false},
+		{"99999999999999999999 + 0.5", "1e+20", false},
+		{"(-1) ** 99999999999999999999", "-1", false},
+		{"{99999999999999999999: 1}[99999999999999999998 + 1]", "1", false},
+		{"if (99999999999999999999) { 1 } else { 2 }", "1", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - wrong result. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+			continue
+		}
+		if _, isBig := evaluated.(*object.BigInteger); isBig != tt.big {
+			t.Errorf("%q - expected a big integer: %t, got=%T", tt.input, tt.big, evaluated)
+		}
+		if tt.big && evaluated.Type() != object.INTEGER_OBJ {
+			t.Errorf("%q - big integers should be INTEGER, got=%s", tt.input, evaluated.Type())
+		}
+	}
+}
+
+func TestDecimals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.10d", "1.10d"},
+		{"0.1d + 0.2d", "0.3d"},
+		{"0.1d + 0.2d == 0.3d", "true"},
+		{"1.10d + 1", "2.10d"},
+		{"1 - 0.01d", "0.99d"},
+		{"19.99d * 3", "59.97d"},
+		{"1.10d / 2", "0.55d"},
+		{"1d / 3", "0.3333333333333333333333333333d"},
+		{"10d ~/ 3", "3d"},
+		{"-7.5d % 2", "0.5d"},
+		{"1.1d ** 2", "1.21d"},
+		{"2 ** 3d", "8d"},
+		{"2d ** -2", "0.25d"},
+		{"-1.5d", "-1.5d"},
+		{"99999999999999999999 + 0.5d", "99999999999999999999.5d"},
+		{"let total = 0d; for (p in [0.10d, 0.20d, 0.30d]) { total += p }; total", "0.60d"},
+		{"let x = 0.5d; x++; x", "1.5d"},
+		{"1.10d == 1.1d", "true"},
+		{"1d == 1", "true"},
+		{"1.5d > 1", "true"},
+		{"0.1d == 0.1", "false"},
+		{"0.5d == 0.5", "true"},
+		{"0.1d < 0.1", "true"},
+		{"2.5d != 2.5", "false"},
+		{"{1.10d: \"a\"}[1.1d]", "a"},
+		{"{1: \"a\"}[1.00d]", "a"},
+		{"if (0.00d) { 1 } else { 2 }", "2"},
+		{"!0.01d", "false"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q - wrong result. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestEvalBooleanExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 <= 1", true},
+		{"1 >= 2", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"1 == 1.0", true},
+		{"1.5 < 2", true},
+		{"true == true", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"(1 > 2) == true", false},
+		{"true && false", false},
+		{"true || false", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestBangOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"!true", false},
+		{"!false", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!!false", false},
+		{"!!5", true},
+		{"!0", true},
+		{"!0.0", true},
+		{`!""`, true},
+		{`!"a"`, false},
+		{"![]", true},
+		{"![0]", false},
+		{"!{}", true},
+		{"!null", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestIfElseExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"if (true) { 10 }", 10},
+		{"if (false) { 10 }", nil},
+		{"if (1) { 10 }", 10},
+		{"if (1 < 2) { 10 }", 10},
+		{"if (1 > 2) { 10 }", nil},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestReturnStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"return 10;", 10},
+		{"return 10; 9;", 10},
+		{"return 2 * 5; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{`
+if (10 > 1) {
+	if (10 > 1) {
+		return 10;
+	}
+
+	return 1;
+}
+`, 10},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestErrorHandling(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+		{"for (x in 1) { x }", "cannot iterate over INTEGER"},
+		{"x = 1", "assignment to undeclared variable: x"},
+		{"x++", "identifier not found: x"},
+		{"null?.a.b", "member access not supported: NULL.b"},
+		{"let n = 5; n?.x", "member access not supported: INTEGER.x"},
+		{"null ?? missing", "identifier not found: missing"},
+		{"true && missing", "identifier not found: missing"},
+		{"false || 1 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"let s = \"a\"; s++", "unknown operator: STRING++"},
+		{"let b = true; --b", "unknown operator: --BOOLEAN"},
+		{"let h = {}; h.n++", "unknown operator: NULL++"},
+		{"x += 1", "identifier not found: x"},
+		{"let f = fn() { y = 1 }; f()", "assignment to undeclared variable: y"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{"let a = [1]; a[-1] = 2", "index out of range: -1"},
+		{"let s = \"abc\"; s[0] = \"x\"", "index assignment not supported: STRING[INTEGER]"},
+		{"let h = {}; h[fn(x) { x }] = 1", "unusable as hash key: FUNCTION"},
+		{"let n = 5; n.x = 1", "member access not supported: INTEGER.x"},
+		{"let n = 5; n.x", "member access not supported: INTEGER.x"},
+		{"let h = {}; h.count += 1", "type mismatch: NULL + INTEGER"},
+		{"for (x in [1]) { x } x", "identifier not found: x"},
+		{"for (let i = 0; false; ) { } i", "identifier not found: i"},
+		{"while (true) { 1 + true }", "type mismatch: INTEGER + BOOLEAN"},
+		{"for (; 1 + true; ) { }", "type mismatch: INTEGER + BOOLEAN"},
+		{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{"~1.5", "unknown operator: ~FLOAT"},
+		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"5; true + false; 5", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
+		{`
+if (10 > 1) {
+	if (10 > 1) {
+		return true + false;
+	}
+
+	return 1;
+}
+`, "unknown operator: BOOLEAN + BOOLEAN"},
+		{"foobar", "identifier not found: foobar"},
+		{"1 / 0", "division by zero"},
+		{"1 ~/ 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{"1 >> -1", "negative shift count: -1"},
+		{"1 << 99999999999999999999", "integer too large: the result of << would exceed 16777216 bits"},
+		{"1 >> -99999999999999999999", "negative shift count: -99999999999999999999"},
+		{"3 ** 99999999999999999999", "integer too large: the result of ** would exceed 16777216 bits"},
+		{"1.5d + 1.0", "type mismatch: DECIMAL + FLOAT"},
+		{"0.5 * 2d", "type mismatch: FLOAT * DECIMAL"},
+		{"1d / 0", "division by zero"},
+		{"1.5d % 0d", "division by zero"},
+		{"0d ** -1", "division by zero"},
+		{"2d ** 0.5d", "decimal exponent must be a whole number, got 0.5d"},
+		{"~1.5d", "unknown operator: ~DECIMAL"},
+		{"1d & 1", "bitwise operator & requires integers, got DECIMAL & INTEGER"},
+		{"let a = [1]; a[99999999999999999999] = 2", "index out of range: 99999999999999999999"},
+		{"1.5 & 1", "bitwise operator & requires integers, got FLOAT & INTEGER"},
+		{"1 | 2.0", "bitwise operator | requires integers, got INTEGER | FLOAT"},
+		{"1.0 << 2", "bitwise operator << requires integers, got FLOAT << INTEGER"},
+		{"true ^ false", "bitwise operator ^ requires integers, got BOOLEAN ^ BOOLEAN"},
+		{"5(1)", "not a function: INTEGER"},
+		{"fn(x) { x }(1, 2)", "wrong number of arguments: want=1, got=2"},
+		{"[1, 2][true]", "index operator not supported: ARRAY[BOOLEAN]"},
+		{"5[0]", "index operator not supported: INTEGER[INTEGER]"},
+		{"{fn(x) { x }: 1}", "unusable as hash key: FUNCTION"},
+		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{`"Hello" + 1`, "type mismatch: STRING + INTEGER"},
+		{"{1: 2}[[1]]", "unusable as hash key: ARRAY"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a;", 5},
+		{"let a = 5 * 5; a;", 25},
+		{"let a = 5; let b = a; b;", 5},
+		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestFunctionObject(t *testing.T) {
+	input := "fn(x) { x + 2; };"
+
+	evaluated := testEval(input)
+	fn, ok := evaluated.(*object.Function)
+	if !ok {
+		t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if len(fn.Parameters) != 1 {
+		t.Fatalf("function has wrong parameters. Parameters=%+v",
+			fn.Parameters)
+	}
+
+	if fn.Parameters[0].String() != "x" {
+		t.Fatalf("parameter is not 'x'. got=%q", fn.Parameters[0])
+	}
+
+	expectedBody := "(x + 2)"
+
+	if fn.Body.String() != expectedBody {
+		t.Fatalf("body is not %q. got=%q", expectedBody, fn.Body.String())
+	}
+}
+
+func TestFunctionApplication(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let identity = fn(x) { x; }; identity(5);", 5},
+		{"let identity = fn(x) { return x; }; identity(5);", 5},
+		{"let double = fn(x) { x * 2; }; double(5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+		{"fn(x) { x; }(5)", 5},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestReadmeExamples(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+let twice = fn(f, x) {
+	f(f(x));
+};
+
+let multiplyByTwo = fn(x) {
+	x * 2;
+};
+
+twice(multiplyByTwo, 10);
+`, 40},
+		{`
+let fibonacci = fn(x) {
+	if (x == 0) {
+		0;
+	} else {
+		if (x == 1) {
+			1;
+		} else {
+			fibonacci(x - 1) + fibonacci(x - 2);
+		}
+	}
+};
+fibonacci(15);
+`, 610},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if len(result.Elements) != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d",
+			len(result.Elements))
+	}
+
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"let fs = [fn(x) { [x, x * 2] }]; fs[0](5)[1]", 10},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = 2;
+{
+	1: 10 - 9,
+	two: 1 + 1,
+	2 + 1: 3,
+	4.5: 4,
+	true: 5,
+	false: 6
+}`
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.Integer{Value: 1}).HashKey(): 1,
+		(&object.Integer{Value: 2}).HashKey(): 2,
+		(&object.Integer{Value: 3}).HashKey(): 3,
+		(&object.Float{Value: 4.5}).HashKey(): 4,
+		TRUE.HashKey():                        5,
+		FALSE.HashKey():                       6,
+	}
+
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+		}
+
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{1: 5}[1]`, 5},
+		{`{1: 5}[1.0]`, 5},
+		{`{1: 5}[2]`, nil},
+		{`let key = 1; {1: 5}[key]`, 5},
+		{`{}[1]`, nil},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+		{`{1: 1, 1: 2}[1]`, 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestStringLiteral(t *testing.T) {
+	input := `"Hello World!"`
+
+	evaluated := testEval(input)
+	str, ok := evaluated.(*object.String)
+	if !ok {
+		t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if str.Value != "Hello World!" {
+		t.Errorf("String has wrong value. got=%q", str.Value)
+	}
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"Hello" + " " + "World!"`, "Hello World!"},
+		{`let name = "StaQ"; "Hello, " + name`, "Hello, StaQ"},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" < "b"`, true},
+		{`"b" >= "c"`, false},
+		{`let myMap = {"name": "StaQ", "version": 0.1}; myMap["name"];`, "StaQ"},
+		{`let saludo = "¡Hola, "; let año = "mañana"; saludo + año + "!"`, "¡Hola, mañana!"},
+		{`"caf\u{e9} \u{2615}"`, "café ☕"},
+		{`let días = {"lunes": 1}; días["lunes"] == 1`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+// TestConformance runs the programs the virtual machines must agree on.
+// Programs that end with a statement have no value in the evaluator,
+// where the virtual machines leave null.
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(program *ast.Program) string {
+		evaluated := Eval(program, object.NewEnvironment())
+		if evaluated == nil {
+			return NULL.Inspect()
+		}
+		return evaluated.Inspect()
+	})
+}
+
+func testEval(input string) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+
+	return Eval(program, env)
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%f, want=%f",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
+	result, ok := obj.(*object.Boolean)
+	if !ok {
+		t.Errorf("object is not Boolean. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%t, want=%t",
+			result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func testNullObject(t *testing.T, obj object.Object) bool {
+	if obj != NULL {
+		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
+		return false
+	}
+	return true
+}
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+let newAdder = fn(x) {
+	fn(y) { x + y };
+};
+let addTwo = newAdder(2);
+addTwo(3);
+`, 5},
+		{`
+let makeCounter = fn(start) {
+	fn(step) { start + step };
+};
+let a = makeCounter(0);
+let b = makeCounter(100);
+a(1) + a(1) + b(1);
+`, 103},
+		{`
+let x = 1;
+let f = fn() { x };
+let g = fn() { let x = 2; f() };
+g();
+`, 1},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestShadowing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let x = 1; if (true) { let x = 2; } x;", 1},
+		{"let x = 1; if (true) { let x = 2; x } ", 2},
+		{"let x = 1; let f = fn(x) { x }; f(5) + x;", 6},
+		{"let x = 1; let f = fn() { let x = 10; x }; f() + x;", 11},
+		{"let x = 1; let x = x + 1; x;", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestRecursion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`
+let factorial = fn(n) { if (n == 0) { 1 } else { n * factorial(n - 1) } };
+factorial(10);
+`, 3628800},
+		{`
+let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+isEven(10);
+`, true},
+		{`
+let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+isOdd(7);
+`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestEmptyReturn(t *testing.T) {
+	testNullObject(t, testEval("let f = fn() { return; 1 }; f()"))
+	testNullObject(t, testEval("let f = fn() { if (true) { return } 1 }; f()"))
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`
+let first = fn(arr, pred) {
+	for (x in arr) {
+		if (pred(x)) { return x; }
+	}
+	return -1;
+};
+first([1, 5, 8], fn(x) { x > 4 });
+`, 5},
+		{"let f = fn(arr) { for (x in arr) { if (x < 3) { continue } return x } }; f([1, 2, 3, 4])", 3},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { break } return x * 10 } }; f()", 10},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 1) { break } } return 99 }; f()", 99},
+		{"let f = fn() { for (a in [1, 2]) { for (b in [3, 4]) { if (a * b == 8) { return a + b } } } }; f()", 6},
+		{"let f = fn() { for (a in [1, 2]) { for (b in [1, 2]) { break } if (a == 2) { return a } } }; f()", 2},
+		{"let f = fn() { while (true) { if (true) { return 7 } } }; f()", 7},
+		{"let f = fn() { for (let i = 1; i < 10; ) { return i } }; f()", 1},
+		{"let f = fn() { for (;;) { break } return 3 }; f()", 3},
+		{"let f = fn(s) { for (c in s) { if (c == \"ñ\") { return 1 } } return 0 }; f(\"año\")", 1},
+		{"let f = fn(h) { for (k in h) { return h[k] } }; f({\"b\": 1, \"a\": 2})", 1},
+		{"let f = fn() { for (x in []) { return 1 } }; f()", nil},
+		{"let f = fn() { while (false) { return 1 } }; f()", nil},
+		{"let x = 5; for (x in [1]) { } x", 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestLoopClosures(t *testing.T) {
+	input := `
+let makeAdders = fn() {
+	for (n in [1, 2, 3]) {
+		if (n == 2) {
+			return fn(x) { x + n };
+		}
+	}
+};
+makeAdders()(10);
+`
+	testIntegerObject(t, testEval(input), 12)
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 5; x", 5},
+		{"let x = 1; x = 5", 5},
+		{"let a = 1; let b = 2; a = b = 7; a + b", 14},
+		{"let x = 10; x += 5; x", 15},
+		{"let x = 10; x -= 5; x", 5},
+		{"let x = 10; x *= 5; x", 50},
+		{"let x = 10; x /= 4; x", 2.5},
+		{"let x = 1; let f = fn() { x = x + 1 }; f(); f(); x", 3},
+		{"let x = 1; if (true) { let x = 2; x = 3 } x", 1},
+		{"let x = 1; if (true) { x = 3 } x", 3},
+		{"let a = [1, 2, 3]; a[1] = 20; a[1]", 20},
+		{"let a = [1, 2, 3]; a[2] += 10; a[2]", 13},
+		{"let a = [1, 2, 3]; let b = a; b[0] = 9; a[0]", 9},
+		{"let h = {}; h[\"n\"] = 1; h[\"n\"]", 1},
+		{"let h = {\"n\": 1}; h.n *= 4; h[\"n\"]", 4},
+		{"let h = {}; h.inner = {}; h.inner.n = 6; h[\"inner\"][\"n\"]", 6},
+		{"let h = {\"name\": \"staq\"}; h.name", "staq"},
+		{"let h = {}; h.missing", nil},
+		{"let calls = 0; let next = fn() { calls += 1; 0 }; let a = [1]; a[next()] += 1; calls", 1},
+		{"let i = 0; let sum = 0; while (i < 5) { i += 1; sum += i } sum", 15},
+		{"let sum = 0; for (let i = 0; i < 5; i += 1) { if (i == 1) { continue } sum += i } sum", 9},
+		{"let n = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break } n += x } n", 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestIncrementExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 1; i++", 1},
+		{"let i = 1; i++; i", 2},
+		{"let i = 1; ++i", 2},
+		{"let i = 1; i--", 1},
+		{"let i = 1; i--; i", 0},
+		{"let i = 1; --i", 0},
+		{"let i = 1; let j = i++ + i; j", 3},
+		{"let i = 1; let j = ++i + i; j", 4},
+		{"let x = 1.5; x++; x", 2.5},
+		{"let a = [1, 2]; a[1]++; a[1]", 3},
+		{"let a = [1, 2]; let i = 0; a[i++]++; a[0] * 10 + i", 21},
+		{"let h = {\"n\": 1}; ++h.n", 2},
+		{"let n = 0; for (let i = 0; i < 5; i++) { n += i } n", 10},
+		{"let n = 0; let i = 3; while (i-- > 0) { n++ } n", 3},
+		{"let n = 0; for (let i = 10; i > 0; --i) { if (i % 2 == 0) { continue } n++ } n", 5},
+		{"let count = 0; let inc = fn() { count++ }; inc(); inc(); count", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestNullLiteral(t *testing.T) {
+	testNullObject(t, testEval("null"))
+	testBooleanObject(t, testEval("null == null"), true)
+	testBooleanObject(t, testEval("let f = fn() { return; }; f() == null"), true)
+	testBooleanObject(t, testEval("null != 0"), true)
+}
+
+func TestNullCoalescing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"null ?? 5", 5},
+		{"1 ?? 5", 1},
+		{"0 ?? 5", 0},
+		{"false ?? true", false},
+		{`"" ?? "default"`, ""},
+		{"null ?? null ?? 3", 3},
+		{"null ?? null", nil},
+		{"let h = {}; h[\"missing\"] ?? 7", 7},
+		{"let calls = 0; let f = fn() { calls++ }; 1 ?? f(); calls", 0},
+		{"let calls = 0; let f = fn() { calls++; 2 }; null ?? f(); calls", 1},
+		{"1 ?? missing", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("%q - expected String %q. got=%T (%+v)", tt.input, expected, evaluated, evaluated)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestOptionalChaining(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let h = null; h?.name", nil},
+		{"let h = {\"name\": 1}; h?.name", 1},
+		{"let h = {\"a\": {\"b\": 2}}; h?.a?.b", 2},
+		{"let h = {\"a\": null}; h.a?.b", nil},
+		{"let a = null; a?.[0]", nil},
+		{"let a = [7]; a?.[0]", 7},
+		{"let h = {}; h.user?.name ?? 3", 3},
+		{"let calls = 0; let f = fn() { calls++ }; let a = null; a?.[f()]; calls", 0},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"0 || null", false},
+		{`"" || [1]`, true},
+		{"0 && missing", false},
+		{"1 || missing", true},
+		{"false && 1 + true", false},
+		{"let calls = 0; let f = fn() { calls++; true }; false && f(); true || f(); calls", 0},
+		{"let calls = 0; let f = fn() { calls++; true }; true && f(); false || f(); calls", 2},
+		{"let a = null; a != null && a.x > 0", false},
+		{"let n = 0; let i = 5; while (i && n < 10) { i--; n++ } n", 5},
+		{"if (0) { 1 } else { 2 }", 2},
+		{`if ("") { 1 } else { 2 }`, 2},
+		{"if ([]) { 1 } else { 2 }", 2},
+		{"if ({}) { 1 } else { 2 }", 2},
+		{"if ([0]) { 1 } else { 2 }", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package evaluator
+
+import (
+	"math"
+	"math/big"
+	"staq/object"
+)
+
+// maxIntegerBits limits the size of the results of **, << and *, so that a
+// runaway computation fails instead of exhausting memory.
+const maxIntegerBits = 1 << 24
+
+// Integer arithmetic never wraps around silently. The helpers below report
+// false when the exact result does not fit in an int64, so the caller can
+// redo the operation with big integers.
+
+func addInt(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+func subInt(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (c < a) == (b > 0)
+}
+
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return c, false
+	}
+	return c, c/b == a
+}
+
+func negInt(a int64) (int64, bool) {
+	return -a, a != math.MinInt64
+}
+
+// powInt raises base to a non-negative exp by repeated squaring.
+func powInt(base, exp int64) (int64, bool) {
+	result := int64(1)
+	for exp > 0 {
+		var ok bool
+		if exp&1 == 1 {
+			if result, ok = mulInt(result, base); !ok {
+				return 0, false
+			}
+		}
+		exp >>= 1
+		if exp > 0 {
+			if base, ok = mulInt(base, base); !ok {
+				return 0, false
+			}
+		}
+	}
+	return result, true
+}
+
+// shlInt shifts a left by a non-negative count, which is the same as
+// multiplying it by 2**count.
+func shlInt(a, count int64) (int64, bool) {
+	if a == 0 {
+		return 0, true
+	}
+	if count >= 64 {
+		return 0, false
+	}
+	c := a << uint64(count)
+	return c, c>>uint64(count) == a
+}
+
+// shrInt shifts a right by a non-negative count. The shift is arithmetic,
+// so it rounds towards negative infinity like floorDiv(a, 2**count), and
+// shifting by 64 or more gives 0 or -1.
+func shrInt(a, count int64) int64 {
+	if count >= 64 {
+		count = 63
+	}
+	return a >> uint64(count)
+}
+
+// floorDiv divides rounding towards negative infinity. It only overflows
+// for math.MinInt64 // -1.
+func floorDiv(a, b int64) (int64, bool) {
+	if a == math.MinInt64 && b == -1 {
+		return 0, false
+	}
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q, true
+}
+
+// floorMod returns the remainder of floorDiv, which has the sign of b.
+func floorMod(a, b int64) int64 {
+	m := a % b
+	if m != 0 && ((m < 0) != (b < 0)) {
+		m += b
+	}
+	return m
+}
+
+// evalBigIntegerInfixExpression evaluates operator on integers of any
+// size. Results that fit in an int64 are demoted to plain Integers.
+func evalBigIntegerInfixExpression(operator string, x, y *big.Int) object.Object {
+	switch operator {
+	case "+":
+		return object.NewInteger(new(big.Int).Add(x, y))
+	case "-":
+		return object.NewInteger(new(big.Int).Sub(x, y))
+	case "*":
+		if x.BitLen()+y.BitLen() > maxIntegerBits {
+			return integerTooLarge(operator)
+		}
+		return object.NewInteger(new(big.Int).Mul(x, y))
+	case "/":
+		if y.Sign() == 0 {
+			return newError("division by zero")
+		}
+		f, _ := new(big.Rat).SetFrac(x, y).Float64()
+		return &object.Float{Value: f}
+	case "~/", "%":
+		if y.Sign() == 0 {
+			return newError("division by zero")
+		}
+		q, m := floorDivBig(x, y)
+		if operator == "~/" {
+			return object.NewInteger(q)
+		}
+		return object.NewInteger(m)
+	case "**":
+		if y.Sign() < 0 {
+			return &object.Float{Value: math.Pow(toFloat(object.NewInteger(x)), toFloat(object.NewInteger(y)))}
+		}
+		return powBig(x, y)
+	case "&":
+		return object.NewInteger(new(big.Int).And(x, y))
+	case "|":
+		return object.NewInteger(new(big.Int).Or(x, y))
+	case "^":
+		return object.NewInteger(new(big.Int).Xor(x, y))
+	case "<<":
+		if y.Sign() < 0 {
+			return newError("negative shift count: %s", y)
+		}
+		if x.Sign() == 0 {
+			return &object.Integer{Value: 0}
+		}
+		if !y.IsInt64() || int64(x.BitLen())+y.Int64() > maxIntegerBits {
+			return integerTooLarge(operator)
+		}
+		return object.NewInteger(new(big.Int).Lsh(x, uint(y.Int64())))
+	case ">>":
+		if y.Sign() < 0 {
+			return newError("negative shift count: %s", y)
+		}
+		if !y.IsInt64() || y.Int64() > int64(x.BitLen()) {
+			// Every bit is shifted out, only the sign remains.
+			if x.Sign() < 0 {
+				return &object.Integer{Value: -1}
+			}
+			return &object.Integer{Value: 0}
+		}
+		return object.NewInteger(new(big.Int).Rsh(x, uint(y.Int64())))
+	case "<", ">", "<=", ">=", "==", "!=":
+		return compare(operator, x.Cmp(y))
+	default:
+		return newError("unknown operator: %s %s %s", object.INTEGER_OBJ, operator, object.INTEGER_OBJ)
+	}
+}
+
+// floorDivBig is floorDiv and floorMod for big integers.
+func floorDivBig(x, y *big.Int) (*big.Int, *big.Int) {
+	q, m := new(big.Int).QuoRem(x, y, new(big.Int))
+	if m.Sign() != 0 && m.Sign() != y.Sign() {
+		q.Sub(q, big.NewInt(1))
+		m.Add(m, y)
+	}
+	return q, m
+}
+
+// powBig raises x to a non-negative power y. Only 0, 1 and -1 can be
+// raised to powers that don't fit in an int64.
+func powBig(x, y *big.Int) object.Object {
+	switch {
+	case x.Sign() == 0 && y.Sign() == 0:
+		return &object.Integer{Value: 1}
+	case x.Sign() == 0:
+		return &object.Integer{Value: 0}
+	case x.CmpAbs(big.NewInt(1)) == 0:
+		if x.Sign() < 0 && y.Bit(0) == 1 {
+			return &object.Integer{Value: -1}
+		}
+		return &object.Integer{Value: 1}
+	}
+
+	// The result has at least (x.BitLen() - 1) * y bits.
+	if !y.IsInt64() || y.Int64() > maxIntegerBits || int64(x.BitLen()-1)*y.Int64() > maxIntegerBits {
+		return integerTooLarge("**")
+	}
+	return object.NewInteger(new(big.Int).Exp(x, y, nil))
+}
+
+func integerTooLarge(operator string) *object.Error {
+	return newError("integer too large: the result of %s would exceed %d bits", operator, maxIntegerBits)
+}
+
+func toBigInt(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	case *object.BigInteger:
+		return obj.Value
+	}
+	return new(big.Int)
+}
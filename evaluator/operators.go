@@ -0,0 +1,75 @@
+package evaluator
+
+import "staq/object"
+
+// The functions in this file apply operators to values that are already
+// evaluated. The virtual machine uses them too, so that both agree on
+// every result and error message. Like Eval, they report runtime errors
+// by returning an *object.Error.
+
+// Infix applies a binary operator other than &&, || and ??, which need
+// their right side unevaluated.
+func Infix(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+// Prefix applies the unary operator !, - or ~.
+func Prefix(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+// Increment applies ++ or -- to the current value of a variable, element
+// or member and returns the updated value.
+func Increment(operator string, postfix bool, current object.Object) object.Object {
+	if !isNumber(current) {
+		if postfix {
+			return newError("unknown operator: %s%s", current.Type(), operator)
+		}
+		return newError("unknown operator: %s%s", operator, current.Type())
+	}
+	return evalInfixExpression(operator[:1], current, &object.Integer{Value: 1})
+}
+
+// Index evaluates left[index].
+func Index(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// Member evaluates obj.name.
+func Member(obj object.Object, name string) object.Object {
+	return evalMemberExpression(obj, name)
+}
+
+// SetIndex evaluates left[index] = val.
+func SetIndex(left, index, val object.Object) object.Object {
+	return evalIndexAssignment(left, index, val)
+}
+
+// SetMember evaluates obj.name = val.
+func SetMember(obj object.Object, name string, val object.Object) object.Object {
+	if obj.Type() != object.HASH_OBJ {
+		return evalMemberExpression(obj, name)
+	}
+	return evalIndexAssignment(obj, &object.String{Value: name}, val)
+}
+
+// Iterate returns the elements a for-in loop over iterable visits: the
+// elements of an array, the characters of a string or the keys of a hash.
+func Iterate(iterable object.Object) ([]object.Object, *object.Error) {
+	var elements []object.Object
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		elements = iterable.Elements
+	case *object.String:
+		for _, ch := range iterable.Value {
+			elements = append(elements, &object.String{Value: string(ch)})
+		}
+	case *object.Hash:
+		for _, pair := range iterable.Ordered() {
+			elements = append(elements, pair.Key)
+		}
+	default:
+		return nil, newError("cannot iterate over %s", iterable.Type())
+	}
+	return elements, nil
+}
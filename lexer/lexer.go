@@ -2,40 +2,113 @@ package lexer
 
 import (
 	"errors"
+	"fmt"
 	"staq/token"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Mode controls optional lexer behaviour.
+type Mode uint
+
+const (
+	// ScanComments makes the lexer return comments as token.COMMENT
+	// tokens instead of skipping them, e.g. for a formatter that must
+	// preserve them.
+	ScanComments Mode = 1 << iota
 )
 
 type Lexer struct {
+	filename     string
 	input        string
+	mode         Mode
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
-	ch           byte // current char under examination
+	ch           rune // current char under examination
+	line         int  // line of the current char
+	column       int  // column of the current char
 }
 
 // New returns a new lexer.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile returns a new lexer whose token positions refer to filename.
+func NewFile(filename, input string) *Lexer {
+	return NewWithMode(filename, input, 0)
+}
+
+// NewWithMode returns a new lexer for filename with the given mode.
+func NewWithMode(filename, input string, mode Mode) *Lexer {
+	l := &Lexer{filename: filename, input: input, mode: mode, line: 1}
 	l.readChar()
+	if l.ch == bom {
+		l.readChar()
+		l.column = 1
+	}
 	return l
 }
 
+// bom is the byte order mark, which is skipped at the start of the input.
+const bom = 0xFEFF
+
+// readChar decodes the next UTF-8 encoded character. Invalid encodings are
+// read one byte at a time as utf8.RuneError.
 func (l *Lexer) readChar() {
+	if l.readPosition > len(l.input) {
+		// Already at EOF, keep reporting the same position.
+		return
+	}
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
+	l.column += 1
+}
+
+// pos returns the position of the current char.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
 }
 
 // NextToken is the main function of the lexer.
-// It returns the next token in the input string.
+// It returns the next token in the input string. Comments are skipped
+// unless the lexer was created with the ScanComments mode.
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	for {
+		l.skipWhitespace()
+
+		start := l.pos()
+		tok := l.readToken()
+		tok.Pos = start
+		tok.End = l.pos()
+
+		if tok.Type == token.COMMENT && l.mode&ScanComments == 0 {
+			continue
+		}
+		return tok
+	}
+}
 
-	l.skipWhitespace()
+// readToken reads the token starting at the current char.
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
@@ -79,14 +152,24 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		if l.peekChar() == '=' {
+		// Integer division is spelled ~/, so // always starts a comment.
+		if l.peekChar() == '/' {
+			tok.Type = token.COMMENT
+			tok.Literal = l.readLineComment()
+			return tok
+		} else if l.peekChar() == '*' {
+			position := l.position
+			if err := l.skipBlockComment(); err != nil {
+				tok.Type = token.ILLEGAL
+			} else {
+				tok.Type = token.COMMENT
+			}
+			tok.Literal = l.input[position:l.position]
+			return tok
+		} else if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.DIVASSIGN, Literal: string(ch) + string(l.ch)}
-		} else if l.peekChar() == '/' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.INTDIV, Literal: string(ch) + string(l.ch)}
 		} else {
 			tok = newToken(token.SLASH, l.ch)
 		}
@@ -131,6 +214,10 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.NULLCOAL, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '.' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.OPTCHAIN, Literal: string(ch) + string(l.ch)}
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
@@ -153,16 +240,39 @@ func (l *Lexer) NextToken() token.Token {
 	case '^':
 		tok = newToken(token.BITXOR, l.ch)
 	case '~':
-		tok = newToken(token.BITNOT, l.ch)
+		if l.peekChar() == '/' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.INTDIV, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.BITNOT, l.ch)
+		}
 	case '%':
 		tok = newToken(token.MOD, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		position := l.position
+		str, err := l.readString()
+		if err != nil {
+			// Include the closing quote, if any, so the parser can
+			// tell a bad escape from a missing quote with CheckString.
+			end := l.position
+			if l.ch == '"' {
+				end++
+			}
+			tok.Type = token.ILLEGAL
+			tok.Literal = l.input[position:end]
+		} else {
+			tok.Type = token.STRING
+			tok.Literal = str
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -171,6 +281,10 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch)
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -181,15 +295,14 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tokLiteral, tokType, err := l.readNumber()
-			if err != nil {
-				tok = newToken(token.ILLEGAL, l.ch)
-			}
-			tok.Literal = tokLiteral
-			tok.Type = tokType
+			// Malformed numbers come back as ILLEGAL, the parser
+			// reports them using CheckNumber.
+			tok.Literal, tok.Type, _ = l.readNumber()
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			// Keep the raw bytes, the char may not be valid UTF-8.
+			tok.Type = token.ILLEGAL
+			tok.Literal = l.input[l.position:l.readPosition]
 		}
 	}
 	l.readChar()
@@ -211,40 +324,149 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-// readNumber reads a number from the input string, including floats.
-// Fails is the number is malformed (i.e. 3.1415.92),
-// in that case it returns an error.
+// readNumber reads a number from the input string: decimal integers and
+// floats, which may have an exponent (1.5e-3) or a d suffix that makes them
+// exact decimals (1.10d), and integers with a 0x, 0o or 0b prefix. Digits may be separated by underscores (1_000_000).
+// Everything that looks like part of the literal is consumed, so that a
+// malformed number such as 3.1415.92 or 0b102 is reported as a whole, in
+// that case it returns an error.
 func (l *Lexer) readNumber() (string, token.TokenType, error) {
-	var tokType token.TokenType
 	position := l.position
-	dotCount := 0
-	tokType = token.INT
-
-	for isDigit(l.ch) || l.ch == '.' {
-		if l.ch == '.' {
-			dotCount++
-			if dotCount > 1 {
-				tokType = token.ILLEGAL
-				// Gather the entire literal
-				for isDigit(l.ch) || l.ch == '.' {
-					l.readChar()
-				}
-				// Return the literal, the token type and an error
-				return l.input[position:l.position], tokType, errors.New("malformed number")
+	decimal := !(l.ch == '0' && strings.ContainsRune("xXoObB", l.peekChar()))
+
+	for {
+		prev := l.ch
+		l.readChar()
+		switch {
+		case isLetter(l.ch) || isDigit(l.ch):
+		case l.ch == '.' && isDigit(l.peekChar()):
+		case (l.ch == '+' || l.ch == '-') && decimal && (prev == 'e' || prev == 'E') && isDigit(l.peekChar()):
+		default:
+			literal := l.input[position:l.position]
+			tokType, err := scanNumber(literal)
+			return literal, tokType, err
+		}
+	}
+}
+
+// CheckNumber reports why literal, the text of an ILLEGAL token starting
+// with a digit, is not a valid number. It returns nil if it is one.
+func CheckNumber(literal string) error {
+	_, err := scanNumber(literal)
+	return err
+}
+
+// scanNumber validates a number literal read by readNumber and returns
+// whether it is an INT, a FLOAT or, if it has a d suffix, a DECIMAL.
+func scanNumber(literal string) (token.TokenType, error) {
+	if len(literal) >= 2 && literal[0] == '0' {
+		switch literal[1] {
+		case 'x', 'X':
+			return scanPrefixedInteger(literal, 16, "hexadecimal")
+		case 'o', 'O':
+			return scanPrefixedInteger(literal, 8, "octal")
+		case 'b', 'B':
+			return scanPrefixedInteger(literal, 2, "binary")
+		}
+	}
+
+	text, isDecimal := strings.CutSuffix(literal, "d")
+
+	mantissa, exponent, hasExponent := text, "", false
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		mantissa, exponent, hasExponent = text[:i], text[i+1:], true
+	}
+
+	groups := strings.Split(mantissa, ".")
+	if len(groups) > 2 {
+		return token.ILLEGAL, fmt.Errorf("malformed number %q: more than one decimal point", literal)
+	}
+	for _, group := range groups {
+		if err := checkDigits(literal, group, 10, "decimal literal"); err != nil {
+			return token.ILLEGAL, err
+		}
+	}
+
+	if hasExponent {
+		digits := strings.TrimPrefix(strings.TrimPrefix(exponent, "+"), "-")
+		if digits == "" {
+			return token.ILLEGAL, fmt.Errorf("exponent has no digits in %q", literal)
+		}
+		if err := checkDigits(literal, digits, 10, "exponent of"); err != nil {
+			return token.ILLEGAL, err
+		}
+	}
+
+	switch {
+	case !hasExponent && len(groups) == 1 && len(mantissa) > 1 && mantissa[0] == '0':
+		return token.ILLEGAL, fmt.Errorf("leading zeros are not allowed in decimal literal %q, use the 0o prefix for octal numbers", literal)
+	case isDecimal:
+		return token.DECIMAL, nil
+	case hasExponent || len(groups) == 2:
+		return token.FLOAT, nil
+	default:
+		return token.INT, nil
+	}
+}
+
+// scanPrefixedInteger validates an integer literal written with a 0x, 0o
+// or 0b prefix.
+func scanPrefixedInteger(literal string, base int, name string) (token.TokenType, error) {
+	// A separator may follow the prefix, as in 0x_FF.
+	digits := strings.TrimPrefix(literal[2:], "_")
+	if digits == "" {
+		return token.ILLEGAL, fmt.Errorf("%s literal %q has no digits", name, literal)
+	}
+	if err := checkDigits(literal, digits, base, name+" literal"); err != nil {
+		return token.ILLEGAL, err
+	}
+	return token.INT, nil
+}
+
+// checkDigits reports an error if digits, a part of literal, contains
+// anything but digits of the given base and underscores separating them.
+func checkDigits(literal, digits string, base int, what string) error {
+	for i, ch := range digits {
+		if ch == '_' {
+			if i == 0 || i == len(digits)-1 || digits[i+1] == '_' {
+				return fmt.Errorf("'_' must separate successive digits in %q", literal)
 			}
-			tokType = token.FLOAT
+			continue
+		}
+		if digitValue(ch) >= base {
+			return fmt.Errorf("invalid digit %q in %s %q", ch, what, literal)
 		}
-		l.readChar()
 	}
+	return nil
+}
 
-	return l.input[position:l.position], tokType, nil
+// digitValue returns the value of ch as a digit in bases up to 16, or 16
+// if it is not a digit at all.
+func digitValue(ch rune) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int(ch - 'a' + 10)
+	case 'A' <= ch && ch <= 'F':
+		return int(ch - 'A' + 10)
+	}
+	return 16
 }
 
+// ErrUnterminatedString is returned by CheckString for a string literal
+// that is missing its closing quote.
+var ErrUnterminatedString = errors.New("unterminated string")
+
 // readString reads a string from the input string.
 // It returns the string without the surrounding quotes.
-// Supports escape sequences.
-func (l *Lexer) readString() string {
+// Supports escape sequences, including \u{...} for any Unicode code point.
+// Fails if the input ends before the closing quote or if an escape is
+// malformed, in that case it returns an error. A malformed escape does not
+// stop the string from being read up to its closing quote.
+func (l *Lexer) readString() (string, error) {
 	var out strings.Builder
+	var escapeErr error
 
 	for {
 		l.readChar()
@@ -265,36 +487,126 @@ func (l *Lexer) readString() string {
 				out.WriteRune('"')
 			case '\\':
 				out.WriteRune('\\')
+			case 'u':
+				ch, err := l.readUnicodeEscape()
+				if err != nil && escapeErr == nil {
+					escapeErr = err
+				}
+				out.WriteRune(ch)
+			case 0:
+				return out.String(), ErrUnterminatedString
 			default:
-				out.WriteRune(rune(l.ch))
+				out.WriteString(l.input[l.position:l.readPosition])
 			}
-		} else if l.ch == '"' || l.ch == 0 {
+		} else if l.ch == '"' {
 			break
+		} else if l.ch == 0 {
+			return out.String(), ErrUnterminatedString
 		} else {
-			out.WriteRune(rune(l.ch))
+			// Copy the raw bytes, so that invalid UTF-8 is kept as is.
+			out.WriteString(l.input[l.position:l.readPosition])
+		}
+	}
+
+	return out.String(), escapeErr
+}
+
+// readUnicodeEscape reads the {...} part of a \u{...} escape, which holds
+// the hexadecimal value of a code point, and leaves the lexer on the
+// closing brace.
+func (l *Lexer) readUnicodeEscape() (rune, error) {
+	if l.peekChar() != '{' {
+		return utf8.RuneError, errors.New(`\u escape must be written as \u{XXXX}`)
+	}
+	l.readChar()
+
+	position := l.readPosition
+	for l.peekChar() != '}' {
+		if l.peekChar() == '"' || l.peekChar() == 0 {
+			return utf8.RuneError, errors.New(`\u{...} escape is missing its closing }`)
+		}
+		l.readChar()
+		if digitValue(l.ch) >= 16 {
+			return utf8.RuneError, fmt.Errorf(`invalid character %q in \u{...} escape`, l.ch)
 		}
 	}
+	digits := l.input[position:l.readPosition]
+	l.readChar()
+
+	if digits == "" {
+		return utf8.RuneError, errors.New(`\u{} escape has no digits`)
+	}
+	value, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || value > unicode.MaxRune || 0xD800 <= value && value <= 0xDFFF {
+		return utf8.RuneError, fmt.Errorf(`\u{%s} is not a valid Unicode code point`, digits)
+	}
+	return rune(value), nil
+}
+
+// CheckString reports why literal, the text of an ILLEGAL token starting
+// with a double quote, is not a valid string. It returns
+// ErrUnterminatedString if the closing quote is missing.
+func CheckString(literal string) error {
+	_, err := New(literal).readString()
+	return err
+}
+
+// readLineComment reads a comment up to, but not including, the end of the
+// line.
+func (l *Lexer) readLineComment() string {
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	return l.input[position:l.position]
+}
 
-	return out.String()
+// skipBlockComment skips a /* ... */ comment. Block comments nest, so
+// commenting out code that already contains one works as expected.
+// Fails if the input ends before the comment is closed, in that case it
+// returns an error.
+func (l *Lexer) skipBlockComment() error {
+	depth := 0
+	for {
+		switch {
+		case l.ch == 0:
+			return errors.New("unterminated block comment")
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+			if depth == 0 {
+				l.readChar()
+				return nil
+			}
+		}
+		l.readChar()
+	}
 }
 
 // peekChar returns the next character in the input string without advancing the
 // lexer's position.
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	}
-	return l.input[l.readPosition]
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// isLetter reports whether ch can appear in an identifier. Any Unicode
+// letter is accepted, so identifiers such as año or número work.
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
@@ -39,7 +39,7 @@ func TestNextToken(t *testing.T) {
 }
 
 func TestOtherTokens(t *testing.T) {
-	input := `!-/*5;
+	input := `!-/ *5;
 	10 < 20 > 10;`
 	tests := []struct {
 		expectedType    token.TokenType
@@ -130,7 +130,7 @@ func TestCompositeOperators(t *testing.T) {
 	10++;
 	10--;
 	10 ** 2;
-	10 // 2;
+	10 ~/ 2;
 	10 % 2;
 	10 << 2;
 	10 >> 2;
@@ -172,7 +172,7 @@ func TestCompositeOperators(t *testing.T) {
 		{token.INT, "2"},
 		{token.SEMICOLON, ";"},
 		{token.INT, "10"},
-		{token.INTDIV, "//"},
+		{token.INTDIV, "~/"},
 		{token.INT, "2"},
 		{token.SEMICOLON, ";"},
 		{token.INT, "10"},
@@ -296,6 +296,171 @@ func TestInvalidNumber(t *testing.T) {
 	}
 }
 
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{"0", token.INT, "0"},
+		{"1_000_000", token.INT, "1_000_000"},
+		{"0xFF", token.INT, "0xFF"},
+		{"0Xdead_beef", token.INT, "0Xdead_beef"},
+		{"0x_FF", token.INT, "0x_FF"},
+		{"0o77", token.INT, "0o77"},
+		{"0b1010", token.INT, "0b1010"},
+		{"0.5", token.FLOAT, "0.5"},
+		{"1_000.000_1", token.FLOAT, "1_000.000_1"},
+		{"1.5e-3", token.FLOAT, "1.5e-3"},
+		{"2E10", token.FLOAT, "2E10"},
+		{"6e+2", token.FLOAT, "6e+2"},
+		{"1.10d", token.DECIMAL, "1.10d"},
+		{"1d", token.DECIMAL, "1d"},
+		{"1_000.5e-3d", token.DECIMAL, "1_000.5e-3d"},
+		{"0x1d", token.INT, "0x1d"},
+		{"1.10dd", token.ILLEGAL, "1.10dd"},
+		{"0777d", token.ILLEGAL, "0777d"},
+		{"0x", token.ILLEGAL, "0x"},
+		{"0b102", token.ILLEGAL, "0b102"},
+		{"0o8", token.ILLEGAL, "0o8"},
+		{"0xFG", token.ILLEGAL, "0xFG"},
+		{"0x1.5", token.ILLEGAL, "0x1.5"},
+		{"1__0", token.ILLEGAL, "1__0"},
+		{"1_", token.ILLEGAL, "1_"},
+		{"1_.5", token.ILLEGAL, "1_.5"},
+		{"1e", token.ILLEGAL, "1e"},
+		{"1.5e3.2", token.ILLEGAL, "1.5e3.2"},
+		{"123abc", token.ILLEGAL, "123abc"},
+		{"0777", token.ILLEGAL, "0777"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input + ";")
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("%q - tokentype wrong. expected=%q, got=%q",
+				tt.input, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%q - literal wrong. expected=%q, got=%q",
+				tt.input, tt.expectedLiteral, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.SEMICOLON {
+			t.Errorf("%q - expected the whole literal to be read, got %q next",
+				tt.input, next.Literal)
+		}
+	}
+}
+
+func TestNumbersBeforeOperators(t *testing.T) {
+	input := `1e-3-2 0x1F-1 3.5.foo`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "1e-3"},
+		{token.MINUS, "-"},
+		{token.INT, "2"},
+		{token.INT, "0x1F"},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.FLOAT, "3.5"},
+		{token.DOT, "."},
+		{token.IDENT, "foo"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestCheckNumber(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0xFF", ""},
+		{"0x", `hexadecimal literal "0x" has no digits`},
+		{"0b102", `invalid digit '2' in binary literal "0b102"`},
+		{"0o8", `invalid digit '8' in octal literal "0o8"`},
+		{"1__0", `'_' must separate successive digits in "1__0"`},
+		{"3.1415.1", `malformed number "3.1415.1": more than one decimal point`},
+		{"1e+", `exponent has no digits in "1e+"`},
+		{"1e", `exponent has no digits in "1e"`},
+		{"1.5d", ""},
+		{"1.5D", `invalid digit 'D' in decimal literal "1.5D"`},
+		{"0777", `leading zeros are not allowed in decimal literal "0777", use the 0o prefix for octal numbers`},
+	}
+
+	for _, tt := range tests {
+		err := CheckNumber(tt.input)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q - unexpected error %q", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q - wrong error. expected=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestNullAndOptionalChaining(t *testing.T) {
+	input := `a ?? null; a?.b?.[0]; a ? b`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.NULLCOAL, "??"},
+		{token.NULL, "null"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.OPTCHAIN, "?."},
+		{token.IDENT, "b"},
+		{token.OPTCHAIN, "?."},
+		{token.LBRACKET, "["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.ILLEGAL, "?"},
+		{token.IDENT, "b"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
 func TestString(t *testing.T) {
 	input := `"Hello World!";`
 
@@ -331,3 +496,397 @@ func TestEscapedtring(t *testing.T) {
 			"Hello World from the \"StaG\" programming language!\nEnjoy your day!\t", tok.Literal)
 	}
 }
+
+func TestBrackets(t *testing.T) {
+	input := `[1, 2][0]; {1: 2};`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACKET, "]"},
+		{token.LBRACKET, "["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.LBRACE, "{"},
+		{token.INT, "1"},
+		{token.COLON, ":"},
+		{token.INT, "2"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	input := `"Hello World!`
+
+	l := New(input)
+
+	tok := l.NextToken()
+
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q",
+			token.ILLEGAL, tok.Type)
+	}
+
+	if tok.Literal != `"Hello World!` {
+		t.Fatalf("literal wrong. expected=%q, got=%q",
+			`"Hello World!`, tok.Literal)
+	}
+
+	tok = l.NextToken()
+
+	if tok.Type != token.EOF {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q",
+			token.EOF, tok.Type)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "let x = 5;\n  \"two\"\tx\n"
+
+	tests := []struct {
+		expectedType  token.TokenType
+		expectedStart token.Position
+		expectedEnd   token.Position
+	}{
+		{token.LET, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 9, Line: 1, Column: 10}},
+		{token.SEMICOLON, token.Position{Offset: 9, Line: 1, Column: 10}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{token.STRING, token.Position{Offset: 13, Line: 2, Column: 3}, token.Position{Offset: 18, Line: 2, Column: 8}},
+		{token.IDENT, token.Position{Offset: 19, Line: 2, Column: 9}, token.Position{Offset: 20, Line: 2, Column: 10}},
+		{token.EOF, token.Position{Offset: 21, Line: 3, Column: 1}, token.Position{Offset: 21, Line: 3, Column: 1}},
+		{token.EOF, token.Position{Offset: 21, Line: 3, Column: 1}, token.Position{Offset: 21, Line: 3, Column: 1}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedStart {
+			t.Fatalf("tests[%d] - start position wrong. expected=%+v, got=%+v",
+				i, tt.expectedStart, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end position wrong. expected=%+v, got=%+v",
+				i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let año = \"¿Qué?\";\nañoÑ"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedStart   token.Position
+		expectedEnd     token.Position
+	}{
+		{token.LET, "let", token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, "año", token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 8, Line: 1, Column: 8}},
+		{token.ASSIGN, "=", token.Position{Offset: 9, Line: 1, Column: 9}, token.Position{Offset: 10, Line: 1, Column: 10}},
+		{token.STRING, "¿Qué?", token.Position{Offset: 11, Line: 1, Column: 11}, token.Position{Offset: 20, Line: 1, Column: 18}},
+		{token.SEMICOLON, ";", token.Position{Offset: 20, Line: 1, Column: 18}, token.Position{Offset: 21, Line: 1, Column: 19}},
+		{token.IDENT, "añoÑ", token.Position{Offset: 22, Line: 2, Column: 1}, token.Position{Offset: 28, Line: 2, Column: 5}},
+		{token.EOF, "", token.Position{Offset: 28, Line: 2, Column: 5}, token.Position{Offset: 28, Line: 2, Column: 5}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos != tt.expectedStart {
+			t.Fatalf("tests[%d] - start position wrong. expected=%+v, got=%+v",
+				i, tt.expectedStart, tok.Pos)
+		}
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end position wrong. expected=%+v, got=%+v",
+				i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	l := New("\uFEFFlet")
+	tok := l.NextToken()
+
+	if tok.Type != token.LET {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.LET, tok.Type)
+	}
+	expected := token.Position{Offset: 3, Line: 1, Column: 1}
+	if tok.Pos != expected {
+		t.Fatalf("position wrong. expected=%+v, got=%+v", expected, tok.Pos)
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	l := New("\xff \"a\xffb\"")
+
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != "\xff" {
+		t.Fatalf("wrong token. expected=ILLEGAL %q, got=%s %q", "\xff", tok.Type, tok.Literal)
+	}
+
+	tok = l.NextToken()
+	if tok.Type != token.STRING || tok.Literal != "a\xffb" {
+		t.Fatalf("wrong token. expected=STRING %q, got=%s %q", "a\xffb", tok.Type, tok.Literal)
+	}
+}
+
+func TestUnicodeEscapes(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"\u{41}"`, token.STRING, "A"},
+		{`"\u{f1}and\u{00fa}"`, token.STRING, "ñandú"},
+		{`"\u{1F600}"`, token.STRING, "😀"},
+		{`"\u{10FFFF}"`, token.STRING, "\U0010FFFF"},
+		{`"\u41"`, token.ILLEGAL, `"\u41"`},
+		{`"\u{}"`, token.ILLEGAL, `"\u{}"`},
+		{`"\u{12G}"`, token.ILLEGAL, `"\u{12G}"`},
+		{`"\u{110000}"`, token.ILLEGAL, `"\u{110000}"`},
+		{`"\u{D800}"`, token.ILLEGAL, `"\u{D800}"`},
+		{`"\u{41"`, token.ILLEGAL, `"\u{41"`},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input + ";")
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("%s - tokentype wrong. expected=%q, got=%q",
+				tt.input, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%s - literal wrong. expected=%q, got=%q",
+				tt.input, tt.expectedLiteral, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.SEMICOLON {
+			t.Errorf("%s - expected the whole string to be read, got %q next",
+				tt.input, next.Literal)
+		}
+	}
+}
+
+func TestCheckString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"ok"`, ""},
+		{`"abc`, "unterminated string"},
+		{`"\u41"`, `\u escape must be written as \u{XXXX}`},
+		{`"\u{}"`, `\u{} escape has no digits`},
+		{`"\u{12G}"`, `invalid character 'G' in \u{...} escape`},
+		{`"\u{110000}"`, `\u{110000} is not a valid Unicode code point`},
+		{`"\u{41"`, `\u{...} escape is missing its closing }`},
+	}
+
+	for _, tt := range tests {
+		err := CheckString(tt.input)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%s - unexpected error %q", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s - wrong error. expected=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestFilename(t *testing.T) {
+	l := NewFile("main.sq", "\n  x")
+
+	tok := l.NextToken()
+
+	if tok.Pos.String() != "main.sq:2:3" {
+		t.Fatalf("position wrong. expected=%q, got=%q", "main.sq:2:3", tok.Pos.String())
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// Numbers can be written in hexadecimal notation
+let a = 10 ~/ 3; // integer division, then a comment
+let b = a; // just a comment
+f(a) ~/ 2;
+/* block
+   comment */ let c = /* inline */ 1;
+/* outer /* nested */ still a comment */ c
+a
+// comment on its own line after an operand
+`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "a"},
+		{token.ASSIGN, "="},
+		{token.INT, "10"},
+		{token.INTDIV, "~/"},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.LET, "let"},
+		{token.IDENT, "b"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "a"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.RPAREN, ")"},
+		{token.INTDIV, "~/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.LET, "let"},
+		{token.IDENT, "c"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "c"},
+		{token.IDENT, "a"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestCommentsAfterOperands(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedTypes []token.TokenType
+	}{
+		{"f(4) // (see above)", []token.TokenType{token.IDENT, token.LPAREN, token.INT, token.RPAREN}},
+		{`!"" // true`, []token.TokenType{token.BANG, token.STRING}},
+		{"let x = 10 // TODO: tidy", []token.TokenType{token.LET, token.IDENT, token.ASSIGN, token.INT}},
+		{"a // 2 items", []token.TokenType{token.IDENT}},
+		{"let x = 5 // 5 is five", []token.TokenType{token.LET, token.IDENT, token.ASSIGN, token.INT}},
+		{"f(3) // call f", []token.TokenType{token.IDENT, token.LPAREN, token.INT, token.RPAREN}},
+		{"a //b", []token.TokenType{token.IDENT}},
+		{"a // b\nc", []token.TokenType{token.IDENT, token.IDENT}},
+		{"a ~/ b", []token.TokenType{token.IDENT, token.INTDIV, token.IDENT}},
+		{"a~/-1", []token.TokenType{token.IDENT, token.INTDIV, token.MINUS, token.INT}},
+		{"~a", []token.TokenType{token.BITNOT, token.IDENT}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+
+		for i, expected := range append(tt.expectedTypes, token.EOF) {
+			if tok := l.NextToken(); tok.Type != expected {
+				t.Fatalf("%q: tests[%d] - tokentype wrong. expected=%q, got=%q",
+					tt.input, i, expected, tok.Type)
+			}
+		}
+	}
+}
+
+func TestScanComments(t *testing.T) {
+	input := "let x = 1; // one\n/* two /* three */ */ x ~/ 2"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// one"},
+		{token.COMMENT, "/* two /* three */ */"},
+		{token.IDENT, "x"},
+		{token.INTDIV, "~/"},
+		{token.INT, "2"},
+		{token.EOF, ""},
+	}
+
+	l := NewWithMode("", input, ScanComments)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	input := "1 /* open /* nested */"
+
+	l := New(input)
+	l.NextToken()
+	tok := l.NextToken()
+
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.ILLEGAL, tok.Type)
+	}
+
+	if tok.Literal != "/* open /* nested */" {
+		t.Fatalf("literal wrong. expected=%q, got=%q", "/* open /* nested */", tok.Literal)
+	}
+}
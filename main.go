@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/user"
@@ -8,6 +9,39 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		os.Exit(build(os.Args[2:]))
+	}
+
+	engine := flag.String("engine", string(repl.Evaluator), "what runs the programs: eval, vm or regvm")
+	dumpAST := flag.Bool("dump-ast", false, "print the AST of the programs instead of running them")
+	noOptimize := flag.Bool("no-optimize", false, "run the programs as written, without optimizing them")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: staq [-engine eval|vm|regvm] [-dump-ast] [-no-optimize] [file.sq|file.sqc]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       staq build [-no-optimize] file.sq [-o file.sqc]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch repl.Engine(*engine) {
+	case repl.Evaluator, repl.VM, repl.RegisterVM:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown engine %q, use eval, vm or regvm\n", *engine)
+		os.Exit(2)
+	}
+
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		if *dumpAST {
+			os.Exit(dumpFile(flag.Arg(0), !*noOptimize))
+		}
+		os.Exit(runFile(flag.Arg(0), repl.Engine(*engine), !*noOptimize))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -15,5 +49,5 @@ func main() {
 	fmt.Print("The StaQ Programming Language")
 	fmt.Printf("Version 0.0.1\n")
 	fmt.Printf("Welcome, %s!\n", user.Username)
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, repl.Engine(*engine), *dumpAST, !*noOptimize)
 }
@@ -0,0 +1,52 @@
+package object
+
+import (
+	"fmt"
+	"staq/code"
+	"strings"
+)
+
+const (
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
+)
+
+// CompiledFunction is the bytecode of a function literal, as produced by
+// the compiler. It only becomes a callable value once it is wrapped in a
+// Closure.
+type CompiledFunction struct {
+	Instructions code.Instructions
+	Parameters   []string
+	NumLocals    int
+	// CellParameters lists the parameters captured by a closure, which
+	// must be moved into cells when the function is called.
+	CellParameters []int
+	// Lines maps the instructions to the source lines they were
+	// compiled from.
+	Lines code.LineTable
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("fn(%s) { <compiled> }", strings.Join(cf.Parameters, ", "))
+}
+
+// Closure is a compiled function together with the cells of the variables
+// it captured from enclosing functions. To StaQ programs it is simply a
+// FUNCTION, like the closures of the evaluator.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string  { return c.Fn.Inspect() }
+
+// Cell holds a variable that closures capture, so that assignments made
+// through the closure and through the enclosing function are seen by both.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
@@ -0,0 +1,68 @@
+package object
+
+// Environment maps identifiers to the values bound to them. Environments
+// created for function calls point to the environment the function was
+// defined in, which is how closures see their surrounding bindings.
+type Environment struct {
+	store map[string]Object
+	outer *Environment
+	// depth is the number of function calls the environment is in.
+	depth int
+}
+
+// NewEnvironment returns a new, empty top-level environment.
+func NewEnvironment() *Environment {
+	s := make(map[string]Object)
+	return &Environment{store: s, outer: nil}
+}
+
+// NewEnclosedEnvironment returns a new environment whose lookups fall back
+// to outer.
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	env := NewEnvironment()
+	env.outer = outer
+	env.depth = outer.depth
+	return env
+}
+
+// NewCallEnvironment returns the environment of a call, made from the
+// environment caller, to a function defined in outer.
+func NewCallEnvironment(outer, caller *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.depth = caller.depth + 1
+	return env
+}
+
+// CallDepth returns the number of function calls the environment is in,
+// zero at the top level.
+func (e *Environment) CallDepth() int {
+	return e.depth
+}
+
+// Get looks name up in this environment and then in every enclosing one.
+func (e *Environment) Get(name string) (Object, bool) {
+	obj, ok := e.store[name]
+	if !ok && e.outer != nil {
+		obj, ok = e.outer.Get(name)
+	}
+	return obj, ok
+}
+
+// Set binds name to val in this environment.
+func (e *Environment) Set(name string, val Object) Object {
+	e.store[name] = val
+	return val
+}
+
+// Assign rebinds an existing name in the innermost environment that
+// declares it, leaving shadowed bindings in outer environments untouched.
+// It reports false if name is not declared anywhere in the chain.
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return val, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,77 @@
+package object
+
+import "testing"
+
+func TestEnvironmentLookupFallsBackToOuter(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	inner := NewEnclosedEnvironment(outer)
+
+	val, ok := inner.Get("a")
+	if !ok {
+		t.Fatalf("inner.Get(\"a\") not found")
+	}
+	testInteger(t, val, 1)
+
+	if _, ok := inner.Get("b"); ok {
+		t.Errorf("inner.Get(\"b\") found an undeclared binding")
+	}
+}
+
+func TestEnvironmentShadowing(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("a", &Integer{Value: 2})
+
+	val, _ := inner.Get("a")
+	testInteger(t, val, 2)
+
+	val, _ = outer.Get("a")
+	testInteger(t, val, 1)
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("count", &Integer{Value: 0})
+	inner := NewEnclosedEnvironment(outer)
+
+	for i := int64(1); i <= 3; i++ {
+		cur, _ := inner.Get("count")
+		if _, ok := inner.Assign("count", &Integer{Value: cur.(*Integer).Value + 1}); !ok {
+			t.Fatalf("Assign(\"count\") failed")
+		}
+		val, _ := outer.Get("count")
+		testInteger(t, val, i)
+	}
+
+	if _, ok := inner.Assign("missing", &Integer{Value: 1}); ok {
+		t.Errorf("Assign to an undeclared name must fail")
+	}
+}
+
+func TestEnvironmentAssignRespectsShadowing(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("a", &Integer{Value: 2})
+
+	inner.Assign("a", &Integer{Value: 3})
+
+	val, _ := inner.Get("a")
+	testInteger(t, val, 3)
+	val, _ = outer.Get("a")
+	testInteger(t, val, 1)
+}
+
+func testInteger(t *testing.T, obj Object, expected int64) {
+	t.Helper()
+	result, ok := obj.(*Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d", result.Value, expected)
+	}
+}
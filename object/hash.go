@@ -0,0 +1,136 @@
+package object
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// HashKey identifies a hashable value inside a Hash. Value is the value
+// itself in a canonical form, not a digest of it, so different values
+// never share a key. Two values that are equal according to == produce the
+// same HashKey, except for integers that no float can hold exactly: ==
+// rounds those before comparing them with a float, while their keys stay
+// exact.
+type HashKey struct {
+	Type  ObjectType
+	Value string
+}
+
+// Hashable is implemented by every value that can be used as a hash key.
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+func (b *Boolean) HashKey() HashKey {
+	return HashKey{Type: b.Type(), Value: strconv.FormatBool(b.Value)}
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: strconv.FormatInt(i.Value, 10)}
+}
+
+// HashKey gives a float the key of its exact value as a number, which is
+// the key of the equal integer or decimal, since 1 == 1.0 and
+// 1.5 == 1.5d hold. Infinities and NaN equal no other kind of number.
+func (f *Float) HashKey() HashKey {
+	if math.IsNaN(f.Value) || math.IsInf(f.Value, 0) {
+		return HashKey{Type: f.Type(), Value: strconv.FormatFloat(f.Value, 'g', -1, 64)}
+	}
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return HashKey{Type: INTEGER_OBJ, Value: strconv.FormatInt(int64(f.Value), 10)}
+	}
+	return numberKey(new(big.Rat).SetFloat64(f.Value))
+}
+
+func (bi *BigInteger) HashKey() HashKey {
+	return HashKey{Type: INTEGER_OBJ, Value: bi.Value.String()}
+}
+
+// HashKey gives a decimal the key of its exact value, so trailing zeros
+// make no difference, since 1.10d == 1.1d holds.
+func (d *Decimal) HashKey() HashKey {
+	return numberKey(d.Value.Rat())
+}
+
+// numberKey returns the key of the number r. Every kind of number with
+// the same value gets the same key, holding r as a reduced fraction, or
+// as an integer in base 10 like the key of an Integer.
+func numberKey(r *big.Rat) HashKey {
+	return HashKey{Type: INTEGER_OBJ, Value: r.RatString()}
+}
+
+func (s *String) HashKey() HashKey {
+	return HashKey{Type: s.Type(), Value: s.Value}
+}
+
+// HashPair keeps the original key next to its value so a Hash can be
+// printed and iterated over.
+type HashPair struct {
+	Key   Hashable
+	Value Object
+}
+
+// Hash is a mapping from hashable keys to values that remembers the order
+// in which keys were first inserted.
+type Hash struct {
+	Pairs map[HashKey]HashPair
+	keys  []HashKey
+}
+
+// NewHash returns an empty hash.
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range h.Ordered() {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Get returns the value stored under key.
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	pair, ok := h.Pairs[key.HashKey()]
+	if !ok {
+		return nil, false
+	}
+	return pair.Value, true
+}
+
+// Set stores value under key, replacing any previous value but keeping the
+// key's original position.
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		h.keys = append(h.keys, hashKey)
+	}
+	h.Pairs[hashKey] = HashPair{Key: key, Value: value}
+}
+
+// Len returns the number of entries in the hash.
+func (h *Hash) Len() int {
+	return len(h.keys)
+}
+
+// Ordered returns the entries of the hash in insertion order.
+func (h *Hash) Ordered() []HashPair {
+	pairs := make([]HashPair, 0, len(h.keys))
+	for _, k := range h.keys {
+		pairs = append(pairs, h.Pairs[k])
+	}
+	return pairs
+}
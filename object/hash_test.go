@@ -0,0 +1,148 @@
+package object
+
+import (
+	"math/big"
+	"staq/decimal"
+	"strconv"
+	"testing"
+)
+
+func TestStringHashKey(t *testing.T) {
+	hello1 := &String{Value: "Hello World"}
+	hello2 := &String{Value: "Hello World"}
+	diff1 := &String{Value: "My name is johnny"}
+	diff2 := &String{Value: "My name is johnny"}
+
+	if hello1.HashKey() != hello2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+
+	if diff1.HashKey() != diff2.HashKey() {
+		t.Errorf("strings with same content have different hash keys")
+	}
+
+	if hello1.HashKey() == diff1.HashKey() {
+		t.Errorf("strings with different content have same hash keys")
+	}
+}
+
+func TestNumberHashKey(t *testing.T) {
+	if (&Integer{Value: 1}).HashKey() != (&Float{Value: 1.0}).HashKey() {
+		t.Errorf("1 and 1.0 have different hash keys")
+	}
+
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 2.5}).HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+
+	if (&Integer{Value: 1}).HashKey() != (&Decimal{Value: mustDecimal(t, "1.00")}).HashKey() {
+		t.Errorf("1 and 1.00d have different hash keys")
+	}
+
+	if (&Decimal{Value: mustDecimal(t, "1.10")}).HashKey() != (&Decimal{Value: mustDecimal(t, "1.1")}).HashKey() {
+		t.Errorf("1.10d and 1.1d have different hash keys")
+	}
+
+	big1 := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+	big2 := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+	if big1.HashKey() != big2.HashKey() {
+		t.Errorf("equal big integers have different hash keys")
+	}
+	if big1.HashKey() != (&Decimal{Value: decimal.FromInt(big1.Value)}).HashKey() {
+		t.Errorf("2**64 and 2**64d have different hash keys")
+	}
+
+	// Numbers that are equal under == have the same key, whatever their
+	// kinds.
+	equal := []struct {
+		name string
+		a, b Hashable
+	}{
+		{"1.5 and 1.5d", &Float{Value: 1.5}, &Decimal{Value: mustDecimal(t, "1.50")}},
+		{"1e19 and 10000000000000000000", &Float{Value: 1e19},
+			&BigInteger{Value: new(big.Int).Exp(big.NewInt(10), big.NewInt(19), nil)}},
+		{"1e19 and 10000000000000000000d", &Float{Value: 1e19}, &Decimal{Value: mustDecimal(t, "10000000000000000000")}},
+		{"-2.0 and -2", &Float{Value: -2}, &Integer{Value: -2}},
+		{"0.25 and 0.25d", &Float{Value: 0.25}, &Decimal{Value: mustDecimal(t, "0.25")}},
+	}
+	for _, tt := range equal {
+		if tt.a.HashKey() != tt.b.HashKey() {
+			t.Errorf("%s have different hash keys", tt.name)
+		}
+	}
+	// 0.1 is not exactly 1/10, so 0.1 == 0.1d is false.
+	if (&Float{Value: 0.1}).HashKey() == (&Decimal{Value: mustDecimal(t, "0.1")}).HashKey() {
+		t.Errorf("0.1 and 0.1d have same hash keys")
+	}
+
+	if (&Integer{Value: 1}).HashKey() == (&Boolean{Value: true}).HashKey() {
+		t.Errorf("1 and true have same hash keys")
+	}
+
+	if (&Integer{Value: 1}).HashKey() == (&String{Value: "1"}).HashKey() {
+		t.Errorf("1 and \"1\" have same hash keys")
+	}
+}
+
+func TestHashKeyIsExact(t *testing.T) {
+	tests := []struct {
+		key      Hashable
+		expected HashKey
+	}{
+		{&String{Value: "a\x00b"}, HashKey{Type: STRING_OBJ, Value: "a\x00b"}},
+		{&Boolean{Value: false}, HashKey{Type: BOOLEAN_OBJ, Value: "false"}},
+		{&Integer{Value: -3}, HashKey{Type: INTEGER_OBJ, Value: "-3"}},
+		{&BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}, HashKey{Type: INTEGER_OBJ, Value: "18446744073709551616"}},
+		{&Float{Value: 0.5}, HashKey{Type: INTEGER_OBJ, Value: "1/2"}},
+		{&Decimal{Value: mustDecimal(t, "-1.50")}, HashKey{Type: INTEGER_OBJ, Value: "-3/2"}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.key.HashKey(); got != tt.expected {
+			t.Errorf("%s - hash key wrong. expected=%+v, got=%+v", tt.key.Inspect(), tt.expected, got)
+		}
+	}
+
+	hash := NewHash()
+	for i := int64(0); i < 1000; i++ {
+		hash.Set(&String{Value: strconv.FormatInt(i, 10)}, &Integer{Value: i})
+		hash.Set(&BigInteger{Value: new(big.Int).Lsh(big.NewInt(i+1), 64)}, &Integer{Value: -i})
+	}
+	if hash.Len() != 2000 {
+		t.Fatalf("hash.Len() wrong. expected=2000, got=%d", hash.Len())
+	}
+	for i := int64(0); i < 1000; i++ {
+		if val, ok := hash.Get(&String{Value: strconv.FormatInt(i, 10)}); !ok || val.(*Integer).Value != i {
+			t.Fatalf("hash.Get(%q) wrong. got=%v", strconv.FormatInt(i, 10), val)
+		}
+	}
+}
+
+func TestHashKeepsInsertionOrder(t *testing.T) {
+	hash := NewHash()
+	hash.Set(&String{Value: "name"}, &String{Value: "StaQ"})
+	hash.Set(&String{Value: "version"}, &Float{Value: 0.1})
+	hash.Set(&Integer{Value: 1}, &Boolean{Value: true})
+	hash.Set(&String{Value: "name"}, &String{Value: "staq"})
+
+	if hash.Len() != 3 {
+		t.Fatalf("hash.Len() wrong. expected=3, got=%d", hash.Len())
+	}
+
+	expected := "{name: staq, version: 0.1, 1: true}"
+	if hash.Inspect() != expected {
+		t.Errorf("hash.Inspect() wrong. expected=%q, got=%q", expected, hash.Inspect())
+	}
+
+	val, ok := hash.Get(&Float{Value: 1.0})
+	if !ok {
+		t.Fatalf("hash.Get(1.0) not found")
+	}
+	if val.Inspect() != "true" {
+		t.Errorf("hash.Get(1.0) wrong. got=%s", val.Inspect())
+	}
+
+	if _, ok := hash.Get(&String{Value: "missing"}); ok {
+		t.Errorf("hash.Get(\"missing\") found a value")
+	}
+}
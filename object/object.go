@@ -0,0 +1,191 @@
+package object
+
+import (
+	"bytes"
+	"math/big"
+	"staq/ast"
+	"staq/decimal"
+	"strconv"
+	"strings"
+)
+
+type ObjectType string
+
+const (
+	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
+	DECIMAL_OBJ      = "DECIMAL"
+	STRING_OBJ       = "STRING"
+	BOOLEAN_OBJ      = "BOOLEAN"
+	NULL_OBJ         = "NULL"
+	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+	ERROR_OBJ        = "ERROR"
+	FUNCTION_OBJ     = "FUNCTION"
+	ARRAY_OBJ        = "ARRAY"
+	HASH_OBJ         = "HASH"
+)
+
+// Object is the interface every runtime value in StaQ implements.
+type Object interface {
+	Type() ObjectType
+	Inspect() string
+}
+
+// Integer is a 64-bit signed integer value.
+type Integer struct {
+	Value int64
+}
+
+func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
+func (i *Integer) Inspect() string  { return strconv.FormatInt(i.Value, 10) }
+
+// BigInteger is an integer that does not fit in an int64. Arithmetic on
+// Integers promotes its result to a BigInteger instead of overflowing, and
+// it is demoted again as soon as it fits, so both kinds are simply
+// INTEGER to StaQ programs. Use NewInteger to keep that invariant.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (bi *BigInteger) Type() ObjectType { return INTEGER_OBJ }
+func (bi *BigInteger) Inspect() string  { return bi.Value.String() }
+
+// NewInteger returns value as an *Integer if it fits in an int64 and as a
+// *BigInteger otherwise.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInteger{Value: value}
+}
+
+// Float is a 64-bit IEEE 754 floating point value.
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always prints a decimal point (or exponent) so floats can be told
+// apart from integers, i.e. 100.0 instead of 100.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// Decimal is an exact base-10 number, written with a d suffix as in 1.10d.
+// It keeps the digits after the decimal point that it was written with.
+type Decimal struct {
+	Value decimal.Decimal
+}
+
+func (d *Decimal) Type() ObjectType { return DECIMAL_OBJ }
+func (d *Decimal) Inspect() string  { return d.Value.String() + "d" }
+
+// String is an immutable string value. Inspect returns the raw contents,
+// without quotes.
+type String struct {
+	Value string
+}
+
+func (s *String) Type() ObjectType { return STRING_OBJ }
+func (s *String) Inspect() string  { return s.Value }
+
+// Boolean is either true or false. The evaluator only ever uses two
+// Boolean instances, so they can be compared by pointer.
+type Boolean struct {
+	Value bool
+}
+
+func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
+func (b *Boolean) Inspect() string  { return strconv.FormatBool(b.Value) }
+
+// Null represents the absence of a value, e.g. the result of an if
+// expression whose condition is false and has no else branch.
+type Null struct{}
+
+func (n *Null) Type() ObjectType { return NULL_OBJ }
+func (n *Null) Inspect() string  { return "null" }
+
+// ReturnValue wraps the value of a return statement so the evaluator can
+// unwind nested blocks until it reaches the enclosing function.
+type ReturnValue struct {
+	Value Object
+}
+
+func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
+func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
+
+// Break and Continue signal a break or continue statement. Like return
+// values they unwind nested blocks, but only up to the enclosing loop.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Error is a runtime error. Like return values, errors stop the evaluation
+// of the enclosing statements and are propagated to the top level.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Type() ObjectType { return ERROR_OBJ }
+func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+
+// Function is a closure: a function literal together with the environment
+// it was defined in.
+type Function struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
+func (f *Function) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range f.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(f.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+// Array is an ordered, zero-indexed list of values.
+type Array struct {
+	Elements []Object
+}
+
+func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
+func (ao *Array) Inspect() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range ao.Elements {
+		elements = append(elements, e.Inspect())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
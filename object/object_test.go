@@ -0,0 +1,114 @@
+package object
+
+import (
+	"math/big"
+	"staq/ast"
+	"staq/decimal"
+	"staq/token"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	body := &ast.BlockStatement{
+		Token: token.Token{Type: token.LBRACE, Literal: "{"},
+		Statements: []ast.Statement{
+			&ast.ExpressionStatement{
+				Token: token.Token{Type: token.IDENT, Literal: "x"},
+				Expression: &ast.Identifier{
+					Token: token.Token{Type: token.IDENT, Literal: "x"},
+					Value: "x",
+				},
+			},
+		},
+	}
+	params := []*ast.Identifier{
+		{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"},
+		{Token: token.Token{Type: token.IDENT, Literal: "y"}, Value: "y"},
+	}
+
+	tests := []struct {
+		obj             Object
+		expectedType    ObjectType
+		expectedInspect string
+	}{
+		{&Integer{Value: 42}, INTEGER_OBJ, "42"},
+		{&Integer{Value: -7}, INTEGER_OBJ, "-7"},
+		{&BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}, INTEGER_OBJ, "18446744073709551616"},
+		{&Float{Value: 3.5}, FLOAT_OBJ, "3.5"},
+		{&Float{Value: 100}, FLOAT_OBJ, "100.0"},
+		{&Float{Value: 1e21}, FLOAT_OBJ, "1e+21"},
+		{&Decimal{Value: mustDecimal(t, "1.10")}, DECIMAL_OBJ, "1.10d"},
+		{&String{Value: "StaQ"}, STRING_OBJ, "StaQ"},
+		{&Boolean{Value: true}, BOOLEAN_OBJ, "true"},
+		{&Boolean{Value: false}, BOOLEAN_OBJ, "false"},
+		{&Null{}, NULL_OBJ, "null"},
+		{&ReturnValue{Value: &Integer{Value: 1}}, RETURN_VALUE_OBJ, "1"},
+		{&Error{Message: "boom"}, ERROR_OBJ, "ERROR: boom"},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, ARRAY_OBJ, "[1, a]"},
+		{&Function{Parameters: params, Body: body, Env: NewEnvironment()}, FUNCTION_OBJ, "fn(x, y) {\nx\n}"},
+	}
+
+	for i, tt := range tests {
+		if tt.obj.Type() != tt.expectedType {
+			t.Errorf("tests[%d] - type wrong. expected=%q, got=%q",
+				i, tt.expectedType, tt.obj.Type())
+		}
+		if tt.obj.Inspect() != tt.expectedInspect {
+			t.Errorf("tests[%d] - inspect wrong. expected=%q, got=%q",
+				i, tt.expectedInspect, tt.obj.Inspect())
+		}
+	}
+}
+
+func mustDecimal(t *testing.T, s string) decimal.Decimal {
+	t.Helper()
+	d, err := decimal.Parse(s)
+	if err != nil {
+		t.Fatalf("decimal.Parse(%q) failed: %s", s, err)
+	}
+	return d
+}
+
+func TestNewInteger(t *testing.T) {
+	if _, ok := NewInteger(big.NewInt(-5)).(*Integer); !ok {
+		t.Errorf("NewInteger(-5) should be an *Integer")
+	}
+	if _, ok := NewInteger(new(big.Int).Lsh(big.NewInt(1), 63)).(*BigInteger); !ok {
+		t.Errorf("NewInteger(2**63) should be a *BigInteger")
+	}
+}
+
+func TestIsTruthy(t *testing.T) {
+	nonEmptyHash := NewHash()
+	nonEmptyHash.Set(&String{Value: "a"}, &Integer{Value: 1})
+
+	tests := []struct {
+		obj      Object
+		expected bool
+	}{
+		{&Boolean{Value: true}, true},
+		{&Boolean{Value: false}, false},
+		{&Null{}, false},
+		{&Integer{Value: 0}, false},
+		{&Integer{Value: -1}, true},
+		{&BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}, true},
+		{&Decimal{}, false},
+		{&Decimal{Value: mustDecimal(t, "0.01")}, true},
+		{&Float{Value: 0}, false},
+		{&Float{Value: 0.5}, true},
+		{&String{Value: ""}, false},
+		{&String{Value: "0"}, true},
+		{&Array{}, false},
+		{&Array{Elements: []Object{&Null{}}}, true},
+		{NewHash(), false},
+		{nonEmptyHash, true},
+		{&Function{}, true},
+	}
+
+	for i, tt := range tests {
+		if actual := IsTruthy(tt.obj); actual != tt.expected {
+			t.Errorf("tests[%d] - IsTruthy(%s) wrong. expected=%t, got=%t",
+				i, tt.obj.Inspect(), tt.expected, actual)
+		}
+	}
+}
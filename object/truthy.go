@@ -0,0 +1,38 @@
+package object
+
+// IsTruthy reports whether obj counts as true in a condition, e.g. in an
+// if or while, or as an operand of !, && and ||. Every backend must use it
+// so that they all agree. The falsy values are:
+//
+//	false
+//	null
+//	0, 0.0 and 0d
+//	"" (the empty string)
+//	[] (the empty array)
+//	{} (the empty hash)
+//
+// Everything else, including functions, is truthy.
+func IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Boolean:
+		return obj.Value
+	case *Null:
+		return false
+	case *Integer:
+		return obj.Value != 0
+	case *BigInteger:
+		return obj.Value.Sign() != 0
+	case *Float:
+		return obj.Value != 0
+	case *Decimal:
+		return obj.Value.Sign() != 0
+	case *String:
+		return obj.Value != ""
+	case *Array:
+		return len(obj.Elements) != 0
+	case *Hash:
+		return obj.Len() != 0
+	default:
+		return true
+	}
+}
@@ -0,0 +1,318 @@
+// Package optimizer simplifies the AST of a StaQ program before it is
+// evaluated or compiled. Every rewrite keeps the meaning of the program:
+//
+//   - Infix and prefix expressions whose operands are literals are folded
+//     into the literal of their value, computed by the evaluator itself.
+//     Expressions whose evaluation fails are left alone, so that the error
+//     is still raised when, and only if, the program runs them.
+//   - If expressions with a literal condition are replaced by the branch
+//     the condition selects.
+//   - Statements that follow a return, break or continue statement in the
+//     same block can never run and are removed, as are literals used as
+//     statements, which do nothing.
+package optimizer
+
+import (
+	"math/big"
+	"staq/ast"
+	"staq/evaluator"
+	"staq/object"
+	"staq/token"
+)
+
+// maxFoldedShift is the largest exponent of ** and shift of << that are
+// folded, and maxFoldedBits the largest number of bits of the integers
+// and decimals they may fold to. Larger ones could produce numbers that
+// take long to compute and to store, which is better left until the
+// program actually needs them.
+const (
+	maxFoldedShift = 64
+	maxFoldedBits  = 4096
+)
+
+// Optimize rewrites program in place.
+func Optimize(program *ast.Program) {
+	program.Statements = statements(program.Statements)
+}
+
+// statements optimizes a list of statements, the body of a program or of
+// a block.
+func statements(list []ast.Statement) []ast.Statement {
+	result := make([]ast.Statement, 0, len(list))
+
+	for i, s := range list {
+		s = statement(s)
+		last := i == len(list)-1
+
+		if es, ok := s.(*ast.ExpressionStatement); ok {
+			if block := inlinable(es, last); block != nil {
+				result = append(result, block.Statements...)
+				continue
+			}
+			if isLiteral(es.Expression) && !last {
+				continue
+			}
+		}
+
+		result = append(result, s)
+	}
+
+	for i, s := range result {
+		switch s.(type) {
+		case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement:
+			return result[:i+1]
+		}
+	}
+	return result
+}
+
+// inlinable returns the block of an if expression statement whose
+// condition is true and whose statements can take its place: ones that
+// declare nothing, since the block would no longer scope them, and that
+// leave the value of the enclosing block unchanged.
+func inlinable(es *ast.ExpressionStatement, last bool) *ast.BlockStatement {
+	ie, ok := es.Expression.(*ast.IfExpression)
+	if !ok || ie.Alternative != nil {
+		return nil
+	}
+	if condition, ok := ie.Condition.(*ast.Boolean); !ok || !condition.Value {
+		return nil
+	}
+
+	block := ie.Consequence
+	if last && len(block.Statements) == 0 {
+		return nil
+	}
+	for _, s := range block.Statements {
+		if _, ok := s.(*ast.LetStatement); ok {
+			return nil
+		}
+	}
+	return block
+}
+
+func statement(s ast.Statement) ast.Statement {
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		s.Expression = expression(s.Expression)
+
+	case *ast.LetStatement:
+		s.Value = expression(s.Value)
+
+	case *ast.ReturnStatement:
+		s.ReturnValue = expression(s.ReturnValue)
+
+	case *ast.BlockStatement:
+		s.Statements = statements(s.Statements)
+
+	case *ast.WhileStatement:
+		s.Condition = expression(s.Condition)
+		s.Body.Statements = statements(s.Body.Statements)
+
+	case *ast.ForStatement:
+		if s.Init != nil {
+			s.Init = statement(s.Init)
+		}
+		s.Condition = expression(s.Condition)
+		s.Post = expression(s.Post)
+		s.Body.Statements = statements(s.Body.Statements)
+
+	case *ast.ForInStatement:
+		s.Iterable = expression(s.Iterable)
+		s.Body.Statements = statements(s.Body.Statements)
+	}
+
+	return s
+}
+
+func expression(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		e.Right = expression(e.Right)
+		if isLiteral(e.Right) {
+			return fold(e)
+		}
+
+	case *ast.InfixExpression:
+		e.Left = expression(e.Left)
+		e.Right = expression(e.Right)
+		if isLiteral(e.Left) && isLiteral(e.Right) && !expensive(e) {
+			return fold(e)
+		}
+
+	case *ast.IfExpression:
+		e.Condition = expression(e.Condition)
+		e.Consequence.Statements = statements(e.Consequence.Statements)
+		if e.Alternative != nil {
+			e.Alternative.Statements = statements(e.Alternative.Statements)
+		}
+		if isLiteral(e.Condition) {
+			return selectBranch(e)
+		}
+
+	case *ast.FunctionLiteral:
+		e.Body.Statements = statements(e.Body.Statements)
+
+	case *ast.CallExpression:
+		e.Function = expression(e.Function)
+		for i, arg := range e.Arguments {
+			e.Arguments[i] = expression(arg)
+		}
+
+	case *ast.ArrayLiteral:
+		for i, el := range e.Elements {
+			e.Elements[i] = expression(el)
+		}
+
+	case *ast.HashLiteral:
+		for i, pair := range e.Pairs {
+			e.Pairs[i] = ast.HashPair{Key: expression(pair.Key), Value: expression(pair.Value)}
+		}
+
+	case *ast.IndexExpression:
+		e.Left = expression(e.Left)
+		e.Index = expression(e.Index)
+
+	case *ast.MemberExpression:
+		e.Object = expression(e.Object)
+
+	case *ast.AssignExpression:
+		// Only the parts of an index or member target are folded, the
+		// target itself stays assignable.
+		e.Target = expression(e.Target)
+		e.Value = expression(e.Value)
+
+	case *ast.PostfixExpression:
+		e.Left = expression(e.Left)
+	}
+
+	return e
+}
+
+// selectBranch replaces an if expression with a literal condition by the
+// branch it selects: null if there is none, the expression of a branch
+// that is just one, and otherwise an if expression that always takes the
+// branch, keeping the scope of its block.
+func selectBranch(ie *ast.IfExpression) ast.Expression {
+	branch := ie.Alternative
+	if object.IsTruthy(value(ie.Condition)) {
+		branch = ie.Consequence
+	}
+
+	if branch == nil {
+		return literal(evaluator.NULL, ie)
+	}
+	if len(branch.Statements) == 1 {
+		if es, ok := branch.Statements[0].(*ast.ExpressionStatement); ok {
+			return es.Expression
+		}
+	}
+
+	return &ast.IfExpression{
+		Token:       ie.Token,
+		Condition:   literal(evaluator.TRUE, ie.Condition),
+		Consequence: branch,
+	}
+}
+
+// fold replaces e, whose operands are literals, by the literal of its
+// value, unless evaluating it fails.
+func fold(e ast.Expression) ast.Expression {
+	if folded := literal(value(e), e); folded != nil {
+		return folded
+	}
+	return e
+}
+
+// expensive reports whether e raises to or shifts by an integer too large
+// to be folded, or would give an integer or decimal too large to be
+// folded, see maxFoldedShift. The size of the result is estimated from the
+// size of the left operand, so that nested folds such as
+// ((2 ** 64) ** 64) ** 64 stop growing.
+func expensive(e *ast.InfixExpression) bool {
+	if e.Operator != "**" && e.Operator != "<<" {
+		return false
+	}
+	right, ok := e.Right.(*ast.IntegerLiteral)
+	if !ok {
+		return false
+	}
+	if right.Big != nil || right.Value > maxFoldedShift || right.Value < -maxFoldedShift {
+		return true
+	}
+
+	n := right.Value
+	if n < 0 {
+		n = -n
+	}
+	if e.Operator == "<<" {
+		return bitLen(e.Left)+n > maxFoldedBits
+	}
+	return bitLen(e.Left)*n > maxFoldedBits
+}
+
+// bitLen returns the number of bits of the integer literal e, or of the
+// digits of the decimal literal e, and 0 for any other expression.
+func bitLen(e ast.Expression) int64 {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		if e.Big != nil {
+			return int64(e.Big.BitLen())
+		}
+		return int64(big.NewInt(e.Value).BitLen())
+	case *ast.DecimalLiteral:
+		return int64(e.Value.Unscaled().BitLen())
+	}
+	return 0
+}
+
+// value evaluates e, an expression without variables.
+func value(e ast.Expression) object.Object {
+	return evaluator.Eval(e, object.NewEnvironment())
+}
+
+// literal returns the literal for obj, positioned where e was, or nil if
+// obj cannot be written as a literal.
+func literal(obj object.Object, e ast.Expression) ast.Expression {
+	if obj == nil {
+		return nil
+	}
+	tok := token.Token{Literal: obj.Inspect(), Pos: e.Pos(), End: e.End()}
+
+	switch obj := obj.(type) {
+	case *object.Integer:
+		tok.Type = token.INT
+		return &ast.IntegerLiteral{Token: tok, Value: obj.Value}
+	case *object.BigInteger:
+		tok.Type = token.INT
+		return &ast.IntegerLiteral{Token: tok, Big: obj.Value}
+	case *object.Float:
+		tok.Type = token.FLOAT
+		return &ast.FloatLiteral{Token: tok, Value: obj.Value}
+	case *object.Decimal:
+		tok.Type = token.DECIMAL
+		return &ast.DecimalLiteral{Token: tok, Value: obj.Value}
+	case *object.String:
+		tok.Type = token.STRING
+		return &ast.StringLiteral{Token: tok, Value: obj.Value}
+	case *object.Boolean:
+		tok.Type = token.FALSE
+		if obj.Value {
+			tok.Type = token.TRUE
+		}
+		return &ast.Boolean{Token: tok, Value: obj.Value}
+	case *object.Null:
+		tok.Type = token.NULL
+		return &ast.NullLiteral{Token: tok}
+	}
+	return nil
+}
+
+func isLiteral(e ast.Expression) bool {
+	switch e.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.DecimalLiteral,
+		*ast.StringLiteral, *ast.Boolean, *ast.NullLiteral:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package optimizer
+
+import (
+	"staq/ast"
+	"staq/conformance"
+	"staq/diagnostic"
+	"staq/evaluator"
+	"staq/lexer"
+	"staq/object"
+	"staq/parser"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Constant folding.
+		{"let result = 10 * (20 / 2);", "let result = 100.0;"},
+		{"1 + 2 * 3", "7"},
+		{"-5 + a", "(-5 + a)"},
+		{"!true", "false"},
+		{"~0", "-1"},
+		{`"Hello" + " " + "World!"`, `"Hello World!"`},
+		{"1.5d + 1", "2.5d"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"1 < 2 && null ?? false", "false"},
+		{"2 ** 64", "18446744073709551616"},
+		{"2 ** 65", "(2 ** 65)"},
+		{"1 << 1000", "(1 << 1000)"},
+		{"(2 ** 64) ** 2", "340282366920938463463374607431768211456"},
+		{"((2 ** 64) ** 64) ** 64", "((18446744073709551616 ** 64) ** 64)"},
+		{"(1 << 64) << 64", "340282366920938463463374607431768211456"},
+		{"(10d ** 64) ** 64", "(10000000000000000000000000000000000000000000000000000000000000000d ** 64)"},
+		{"1 + a * 2", "(1 + (a * 2))"},
+		{"fn(x) { x + 2 * 3 }", "fn(x) (x + 6)"},
+		{"[1 + 1, {2 * 2: -1}]", "[2, {4: -1}]"},
+		{"a[1 + 1] = 2 + 2", "((a[2]) = 4)"},
+
+		// Errors are left to the program.
+		{"1 / 0", "(1 / 0)"},
+		{`"a" - "b"`, `("a" - "b")`},
+		{"-true", "(-true)"},
+
+		// Dead branches.
+		{"if (1 < 2) { a } else { b }", "a"},
+		{"if (false) { a }", "null"},
+		{"let x = if (null) { a } else { b; c };", "let x = if (true) { bc };"},
+		{"if (x) { 1 + 1 } else { 2 }", "if (x) { 2 } else { 2 }"},
+		{"if (true) { a; b }; c", "abc"},
+		{"if (true) { let a = 1; a }; c", "if (true) { let a = 1;a }c"},
+		{"if (false) { a }; c", "c"},
+		{"1; 2; c", "c"},
+
+		// Unreachable code.
+		{"fn() { return 1; a; b }", "fn() return 1;"},
+		{"while (x) { break; a }", "while (x) { break; }"},
+		{"for (i in a) { if (true) { continue; }; b }", "for (i in a) { continue; }"},
+		{"return 1; let a = 2;", "return 1;"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		Optimize(program)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// TestFormatRoundTrip checks that optimized programs print as source that
+// parses back to the same program, negative literals included.
+func TestFormatRoundTrip(t *testing.T) {
+	tests := []string{
+		"(-2) ** x",
+		"-2 ** x",
+		"(-1.5) * x",
+		"x - -2d",
+		"[-1, {-2: -3}][0]",
+		"if (-1) { x }",
+	}
+
+	for _, input := range tests {
+		program := parse(t, input)
+		Optimize(program)
+
+		formatted := ast.Format(program)
+		reparsed := parse(t, formatted)
+		Optimize(reparsed)
+		if got, want := reparsed.String(), program.String(); got != want {
+			t.Errorf("%q formatted as %q, which parses as %q. want=%q", input, formatted, got, want)
+		}
+	}
+}
+
+// TestConformance checks that optimized programs give the same results as
+// the programs themselves.
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(program *ast.Program) string {
+		Optimize(program)
+
+		result := evaluator.Eval(program, object.NewEnvironment())
+		if result == nil {
+			return "null"
+		}
+		return result.Inspect()
+	})
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if diagnostic.HasErrors(p.Diagnostics()) {
+		t.Fatalf("parser errors for %q: %v", input, p.Diagnostics())
+	}
+	return program
+}
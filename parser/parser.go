@@ -1,11 +1,17 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"staq/ast"
+	"staq/decimal"
+	"staq/diagnostic"
 	"staq/lexer"
 	"staq/token"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -23,77 +29,134 @@ const (
 	SHIFT
 	ADDITIVE
 	MULTIPLICATIVE
-	EXP
 	UNARY
+	EXP
 	PRIMARY
 )
 
-var precedences = map[token.TokenType]int{
-	token.ADDASSIGN: ASSIGNMENT,
-	token.SUBASSIGN: ASSIGNMENT,
-	token.MULASSIGN: ASSIGNMENT,
-	token.DIVASSIGN: ASSIGNMENT,
-	token.ASSIGN:    ASSIGNMENT,
-	token.NULLCOAL:  NULLCOAL,
-	token.OR:        OR,
-	token.AND:       AND,
-	token.BITOR:     BITOR,
-	token.BITXOR:    BITXOR,
-	token.BITAND:    BITAND,
-	token.EQ:        EQUALS,
-	token.NOT_EQ:    EQUALS,
-	token.GT:        RELATIONAL,
-	token.LT:        RELATIONAL,
-	token.GEQ:       RELATIONAL,
-	token.LEQ:       RELATIONAL,
-	token.SHL:       SHIFT,
-	token.SHR:       SHIFT,
-	token.PLUS:      ADDITIVE,
-	token.MINUS:     ADDITIVE,
-	token.ASTERISK:  MULTIPLICATIVE,
-	token.SLASH:     MULTIPLICATIVE,
-	token.MOD:       MULTIPLICATIVE,
-	token.INTDIV:    MULTIPLICATIVE,
-	token.EXP:       EXP,
-	token.LPAREN:    PRIMARY,
+// Associativity decides how a chain of operators with the same precedence
+// groups: a - b - c is (a - b) - c, but a ** b ** c is a ** (b ** c).
+type Associativity int
+
+const (
+	LeftAssoc Associativity = iota
+	RightAssoc
+)
+
+// operator is an entry of the precedences table.
+type operator struct {
+	precedence    int
+	associativity Associativity
 }
 
+// precedences lists how tightly every infix and postfix operator binds.
+// Prefix operators bind tighter than all binary operators except **, so
+// -2 ** 2 is -(2 ** 2) while 2 ** -1 still works.
+var precedences = map[token.TokenType]operator{
+	token.ADDASSIGN: {ASSIGNMENT, RightAssoc},
+	token.SUBASSIGN: {ASSIGNMENT, RightAssoc},
+	token.MULASSIGN: {ASSIGNMENT, RightAssoc},
+	token.DIVASSIGN: {ASSIGNMENT, RightAssoc},
+	token.ASSIGN:    {ASSIGNMENT, RightAssoc},
+	token.NULLCOAL:  {NULLCOAL, RightAssoc},
+	token.OR:        {OR, LeftAssoc},
+	token.AND:       {AND, LeftAssoc},
+	token.BITOR:     {BITOR, LeftAssoc},
+	token.BITXOR:    {BITXOR, LeftAssoc},
+	token.BITAND:    {BITAND, LeftAssoc},
+	token.EQ:        {EQUALS, LeftAssoc},
+	token.NOT_EQ:    {EQUALS, LeftAssoc},
+	token.GT:        {RELATIONAL, LeftAssoc},
+	token.LT:        {RELATIONAL, LeftAssoc},
+	token.GEQ:       {RELATIONAL, LeftAssoc},
+	token.LEQ:       {RELATIONAL, LeftAssoc},
+	token.SHL:       {SHIFT, LeftAssoc},
+	token.SHR:       {SHIFT, LeftAssoc},
+	token.PLUS:      {ADDITIVE, LeftAssoc},
+	token.MINUS:     {ADDITIVE, LeftAssoc},
+	token.ASTERISK:  {MULTIPLICATIVE, LeftAssoc},
+	token.SLASH:     {MULTIPLICATIVE, LeftAssoc},
+	token.MOD:       {MULTIPLICATIVE, LeftAssoc},
+	token.INTDIV:    {MULTIPLICATIVE, LeftAssoc},
+	token.EXP:       {EXP, RightAssoc},
+	token.LPAREN:    {PRIMARY, LeftAssoc},
+	token.LBRACKET:  {PRIMARY, LeftAssoc},
+	token.DOT:       {PRIMARY, LeftAssoc},
+	token.OPTCHAIN:  {PRIMARY, LeftAssoc},
+	token.INC:       {PRIMARY, LeftAssoc},
+	token.DEC:       {PRIMARY, LeftAssoc},
+}
+
+// Diagnostic codes reported by the parser.
+const (
+	ErrUnexpectedToken     diagnostic.Code = "E0001"
+	ErrExpectedExpression  diagnostic.Code = "E0002"
+	ErrInvalidInteger      diagnostic.Code = "E0003"
+	ErrInvalidFloat        diagnostic.Code = "E0004"
+	ErrUnterminatedString  diagnostic.Code = "E0005"
+	ErrIllegalToken        diagnostic.Code = "E0006"
+	ErrUnclosedBlock       diagnostic.Code = "E0007"
+	ErrUnterminatedComment diagnostic.Code = "E0008"
+	ErrMalformedNumber     diagnostic.Code = "E0009"
+	ErrInvalidEscape       diagnostic.Code = "E0010"
+	ErrBranchOutsideLoop   diagnostic.Code = "E0011"
+	ErrInvalidAssignTarget diagnostic.Code = "E0012"
+	ErrInvalidDecimal      diagnostic.Code = "E0013"
+	ErrDuplicateParameter  diagnostic.Code = "E0014"
+)
+
 type (
 	prefixParseFn func() ast.Expression
 	infixParseFn  func(ast.Expression) ast.Expression
 )
 
 type Parser struct {
-	errors         []string
+	diagnostics    []diagnostic.Diagnostic
 	l              *lexer.Lexer
 	curToken       token.Token
 	peekToken      token.Token
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// panicking is set when an error is reported and cleared once the
+	// parser has resynchronized at a statement boundary. Errors reported
+	// in between are most likely caused by the first one and are dropped.
+	panicking  bool
+	blockDepth int // number of enclosing block statements
+	loopDepth  int // number of enclosing loops in the current function
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []diagnostic.Diagnostic{},
 	}
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
 	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
+	p.registerPrefix(token.DECIMAL, p.parseDecimalLiteral)
+	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.BITNOT, p.parsePrefixExpression)
+	p.registerPrefix(token.INC, p.parsePrefixIncrement)
+	p.registerPrefix(token.DEC, p.parsePrefixIncrement)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NULL, p.parseNullLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
-	p.registerInfix(token.ADDASSIGN, p.parseInfixExpression)
-	p.registerInfix(token.SUBASSIGN, p.parseInfixExpression)
-	p.registerInfix(token.MULASSIGN, p.parseInfixExpression)
-	p.registerInfix(token.DIVASSIGN, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ADDASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SUBASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MULASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.DIVASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.NULLCOAL, p.parseInfixExpression)
 	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.AND, p.parseInfixExpression)
@@ -116,47 +179,174 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.INTDIV, p.parseInfixExpression)
 	p.registerInfix(token.EXP, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
+	p.registerInfix(token.OPTCHAIN, p.parseOptionalChain)
+	p.registerInfix(token.INC, p.parsePostfixExpression)
+	p.registerInfix(token.DEC, p.parsePostfixExpression)
 	p.nextToken()
 	p.nextToken()
 	return p
 }
 
+// Errors returns the parser errors as plain strings. Every message starts
+// with the position it refers to, e.g. "3:14: expected next token to be ),
+// got ; instead". Warnings are left out.
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := make([]string, 0, len(p.diagnostics))
+	for _, d := range p.diagnostics {
+		if d.Severity == diagnostic.Error {
+			errors = append(errors, d.String())
+		}
+	}
+	return errors
+}
+
+// Diagnostics returns everything the parser reported, in the order it was
+// found.
+func (p *Parser) Diagnostics() []diagnostic.Diagnostic {
+	return p.diagnostics
 }
 
-func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+// errorf records an error diagnostic covering tok and returns it so the
+// caller can attach hints or related spans. It also puts the parser in
+// panic mode; see synchronize.
+func (p *Parser) errorf(code diagnostic.Code, tok token.Token, format string, a ...interface{}) *diagnostic.Diagnostic {
+	if p.panicking {
+		return &diagnostic.Diagnostic{}
+	}
+	p.panicking = true
+	p.diagnostics = append(p.diagnostics, diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Span:     diagnostic.SpanOf(tok),
+	})
+	return &p.diagnostics[len(p.diagnostics)-1]
+}
+
+func (p *Parser) peekError(t token.TokenType) *diagnostic.Diagnostic {
+	return p.errorf(ErrUnexpectedToken, p.peekToken,
+		"expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
 
+// expectClosing works like expectPeek for the closing delimiter of a
+// construct opened by the open token. If the delimiter is missing the
+// error points back at the opening one.
+func (p *Parser) expectClosing(close token.TokenType, open token.Token) bool {
+	if p.peekTokenIs(close) {
+		p.nextToken()
+		return true
+	}
+	d := p.peekError(close)
+	d.Related = append(d.Related, diagnostic.Related{
+		Span:    diagnostic.SpanOf(open),
+		Message: fmt.Sprintf("unclosed %s opened here", open.Literal),
+	})
+	return false
+}
+
+// nextToken advances the parser, skipping comments in case the lexer was
+// asked to keep them.
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	for p.peekToken.Type == token.COMMENT {
+		p.peekToken = p.l.NextToken()
+	}
 }
 
+// ParseProgram parses the whole input. Statements that contain errors are
+// left out of the returned program; parsing resumes at the next statement
+// so that every independent error is reported in a single pass.
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
-	program.Statements = []ast.Statement{}
+	program.Statements = p.parseStatements(token.EOF)
+	return program
+}
+
+// parseStatements parses statements until the end token or EOF. It leaves
+// curToken on that token.
+func (p *Parser) parseStatements(end token.TokenType) []ast.Statement {
+	statements := []ast.Statement{}
 
-	for p.curToken.Type != token.EOF {
+	for !p.curTokenIs(end) && !p.curTokenIs(token.EOF) {
+		start := p.curToken
 		stmt := p.parseStatement()
-		program.Statements = append(program.Statements, stmt)
+		if p.panicking {
+			p.synchronize(start)
+			continue
+		}
+		if stmt != nil {
+			statements = append(statements, stmt)
+		}
+		p.nextToken()
+	}
+
+	return statements
+}
+
+// synchronize skips the tokens of a statement that failed to parse and
+// leaves the parser on the first token of the next one. Statements end at
+// a semicolon or right before the next let or return, and a closing brace
+// ends the enclosing block. Nested braces are skipped as a whole. start is
+// the first token of the failed statement.
+func (p *Parser) synchronize(start token.Token) {
+	p.panicking = false
+	depth := 0
+
+	for !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.SEMICOLON:
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		case token.LET, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE:
+			if depth == 0 && p.curToken.Pos != start.Pos {
+				return
+			}
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			} else if p.blockDepth > 0 {
+				return
+			}
+		}
 		p.nextToken()
 	}
-	return program
 }
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
 	case token.RETURN:
-		return p.parseReturnStatement()
+		if stmt := p.parseReturnStatement(); stmt != nil {
+			return stmt
+		}
+	case token.WHILE:
+		if stmt := p.parseWhileStatement(); stmt != nil {
+			return stmt
+		}
+	case token.FOR:
+		if stmt := p.parseForStatement(); stmt != nil {
+			return stmt
+		}
+	case token.BREAK, token.CONTINUE:
+		if stmt := p.parseBranchStatement(); stmt != nil {
+			return stmt
+		}
 	default:
-		return p.parseExpressionStatement()
+		if stmt := p.parseExpressionStatement(); stmt != nil {
+			return stmt
+		}
 	}
+	return nil
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
@@ -179,8 +369,11 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -192,20 +385,188 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 		Token: p.curToken,
 	}
 
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) {
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
+	}
+
 	p.nextToken()
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
+	if stmt.ReturnValue == nil {
+		return nil
+	}
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+// parseForStatement parses both kinds of for loop, a C-style
+// for (init; condition; post) and a for (x in iterable).
+func (p *Parser) parseForStatement() ast.Statement {
+	tok := p.curToken
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+
+	if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.IN) {
+		if stmt := p.parseForInStatement(tok); stmt != nil {
+			return stmt
+		}
+		return nil
+	}
+
+	stmt := &ast.ForStatement{Token: tok}
+
+	if !p.curTokenIs(token.SEMICOLON) {
+		if p.curTokenIs(token.LET) {
+			if init := p.parseLetStatement(); init != nil {
+				stmt.Init = init
+			}
+		} else if init := p.parseExpressionStatement(); init != nil {
+			stmt.Init = init
+		}
+		if stmt.Init == nil {
+			return nil
+		}
+		if !p.curTokenIs(token.SEMICOLON) && !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	if !p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+		stmt.Condition = p.parseExpression(LOWEST)
+		if stmt.Condition == nil {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+
+	if !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		stmt.Post = p.parseExpression(LOWEST)
+		if stmt.Post == nil {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForInStatement(tok token.Token) *ast.ForInStatement {
+	stmt := &ast.ForInStatement{
+		Token:    tok,
+		Variable: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+	}
+
+	p.nextToken()
+	p.nextToken()
+
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if stmt.Iterable == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+// parseLoopBody parses the block after a loop header, in which break and
+// continue are allowed, and the optional semicolon after it.
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	p.loopDepth++
+	body := p.parseBlockStatement()
+	p.loopDepth--
+
+	if body != nil && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return body
+}
+
+// parseBranchStatement parses a break or continue statement, which must be
+// inside a loop.
+func (p *Parser) parseBranchStatement() ast.Statement {
+	tok := p.curToken
+
+	if p.loopDepth == 0 {
+		d := p.errorf(ErrBranchOutsideLoop, tok, "%s outside of a loop", tok.Literal)
+		d.Hints = append(d.Hints, "break and continue only work in while and for loops of the same function")
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -215,8 +576,8 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	d := p.errorf(ErrExpectedExpression, p.curToken, "no prefix parse function for %s found", t)
+	d.Hints = append(d.Hints, "expected an expression here")
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
@@ -227,7 +588,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	}
 	leftExp := prefix()
 
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+	for leftExp != nil && !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp
@@ -238,6 +599,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		leftExp = infix(leftExp)
 	}
 
+	if p.panicking {
+		return nil
+	}
 	return leftExp
 }
 
@@ -248,17 +612,22 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	}
 }
 
+// parseIntegerLiteral keeps integers that don't fit in an int64 as big
+// integers instead of rejecting them.
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as an integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+	value, ok := new(big.Int).SetString(p.curToken.Literal, 0)
+	if !ok {
+		p.errorf(ErrInvalidInteger, p.curToken, "could not parse %q as an integer", p.curToken.Literal)
 		return nil
 	}
 
-	lit.Value = value
+	if value.IsInt64() {
+		lit.Value = value.Int64()
+	} else {
+		lit.Big = value
+	}
 	return lit
 }
 
@@ -266,8 +635,7 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	lit := &ast.FloatLiteral{Token: p.curToken}
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as a float", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(ErrInvalidFloat, p.curToken, "could not parse %q as a float", p.curToken.Literal)
 		return nil
 	}
 
@@ -275,10 +643,59 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseDecimalLiteral() ast.Expression {
+	lit := &ast.DecimalLiteral{Token: p.curToken}
+	value, err := decimal.Parse(strings.TrimSuffix(p.curToken.Literal, "d"))
+	if err != nil {
+		d := p.errorf(ErrInvalidDecimal, p.curToken, "could not parse %q as a decimal", p.curToken.Literal)
+		if errors.Is(err, decimal.ErrOutOfRange) {
+			d.Hints = append(d.Hints, fmt.Sprintf("decimals are limited to %d digits after the decimal point and %d before it",
+				decimal.MaxScale, decimal.MaxDigits))
+		}
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseStringLiteral() ast.Expression {
+	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+}
+
+// parseIllegal reports tokens the lexer could not make sense of. The lexer
+// keeps the offending source text as the literal.
+func (p *Parser) parseIllegal() ast.Expression {
+	switch {
+	case strings.HasPrefix(p.curToken.Literal, "/*"):
+		d := p.errorf(ErrUnterminatedComment, p.curToken, "unterminated block comment")
+		d.Hints = append(d.Hints, "block comments nest, every /* needs its own */")
+	case strings.HasPrefix(p.curToken.Literal, "\""):
+		err := lexer.CheckString(p.curToken.Literal)
+		if errors.Is(err, lexer.ErrUnterminatedString) {
+			d := p.errorf(ErrUnterminatedString, p.curToken, "unterminated string literal %s", p.curToken.Literal)
+			d.Hints = append(d.Hints, `add a closing " to end the string`)
+			break
+		}
+		p.errorf(ErrInvalidEscape, p.curToken, "%s in string literal %s", err, p.curToken.Literal)
+	case strings.IndexAny(p.curToken.Literal, "0123456789") == 0:
+		p.errorf(ErrMalformedNumber, p.curToken, "%s", lexer.CheckNumber(p.curToken.Literal))
+	case !utf8.ValidString(p.curToken.Literal):
+		p.errorf(ErrIllegalToken, p.curToken, "invalid UTF-8 encoding %q", p.curToken.Literal)
+	default:
+		p.errorf(ErrIllegalToken, p.curToken, "illegal token %q", p.curToken.Literal)
+	}
+	return nil
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
@@ -288,6 +705,9 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	p.nextToken()
 
 	expression.Right = p.parseExpression(UNARY)
+	if expression.Right == nil {
+		return nil
+	}
 
 	return expression
 }
@@ -299,17 +719,24 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 	}
 
-	precedence := p.curPrecedence()
+	precedence := p.rightPrecedence()
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
+	if expression.Right == nil {
+		return nil
+	}
 	return expression
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	lparen := p.curToken
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
+	if exp == nil {
+		return nil
+	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectClosing(token.RPAREN, lparen) {
 		return nil
 	}
 
@@ -325,6 +752,9 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
+	if expression.Condition == nil {
+		return nil
+	}
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
@@ -335,6 +765,9 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	}
 
 	expression.Consequence = p.parseBlockStatement()
+	if expression.Consequence == nil {
+		return nil
+	}
 
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
@@ -344,24 +777,34 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		}
 
 		expression.Alternative = p.parseBlockStatement()
+		if expression.Alternative == nil {
+			return nil
+		}
 	}
 
 	return expression
 }
 
+// parseBlockStatement recovers from errors inside the block on its own, so
+// it only fails if the closing brace is missing.
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
-	block.Statements = []ast.Statement{}
 
 	p.nextToken()
 
-	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
-		}
-		p.nextToken()
+	p.blockDepth++
+	block.Statements = p.parseStatements(token.RBRACE)
+	p.blockDepth--
+
+	if !p.curTokenIs(token.RBRACE) {
+		d := p.errorf(ErrUnclosedBlock, p.curToken, "expected } to close the block, got %s instead", p.curToken.Type)
+		d.Related = append(d.Related, diagnostic.Related{
+			Span:    diagnostic.SpanOf(block.Token),
+			Message: "unclosed { opened here",
+		})
+		return nil
 	}
+	block.Rbrace = p.curToken
 	return block
 }
 
@@ -371,10 +814,22 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 	lit.Parameters = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return nil
+	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
+
+	// break and continue cannot reach loops outside the function.
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
+
+	if lit.Body == nil {
+		return nil
+	}
 	return lit
 }
 
@@ -384,16 +839,31 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		p.nextToken()
 		return identifiers
 	}
-	p.nextToken()
+	lparen := p.curToken
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
 	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		for _, other := range identifiers {
+			if other.Value == ident.Value {
+				d := p.errorf(ErrDuplicateParameter, ident.Token, "duplicate parameter %s", ident.Value)
+				d.Related = append(d.Related, diagnostic.Related{
+					Span:    diagnostic.SpanOf(other.Token),
+					Message: "first declared here",
+				})
+				return nil
+			}
+		}
 		identifiers = append(identifiers, ident)
 	}
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectClosing(token.RPAREN, lparen) {
 		return nil
 	}
 	return identifiers
@@ -401,27 +871,230 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
-	exp.Arguments = p.parseCallArguments()
+	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	if exp.Arguments == nil {
+		return nil
+	}
+	exp.Rparen = p.curToken
 	return exp
 }
 
-func (p *Parser) parseCallArguments() []ast.Expression {
-	args := []ast.Expression{}
-	if p.peekTokenIs(token.RPAREN) {
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.curToken}
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if array.Elements == nil {
+		return nil
+	}
+	array.Rbrack = p.curToken
+	return array
+}
+
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.curToken}
+	hash.Pairs = []ast.HashPair{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+		if key == nil {
+			return nil
+		}
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
+
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.peekTokenIs(token.RBRACE) {
+			p.expectClosing(token.RBRACE, hash.Token)
+			return nil
+		}
+	}
+
+	if !p.expectClosing(token.RBRACE, hash.Token) {
+		return nil
+	}
+	hash.Rbrace = p.curToken
+
+	return hash
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+
+	p.nextToken()
+	exp.Index = p.parseExpression(LOWEST)
+	if exp.Index == nil {
+		return nil
+	}
+
+	if !p.expectClosing(token.RBRACKET, exp.Token) {
+		return nil
+	}
+	exp.Rbrack = p.curToken
+
+	return exp
+}
+
+// parseOptionalChain parses a?.b and a?.[i], which evaluate to null
+// instead of failing when a is null.
+func (p *Parser) parseOptionalChain(left ast.Expression) ast.Expression {
+	if p.peekTokenIs(token.LBRACKET) {
 		p.nextToken()
-		return args
+		exp, ok := p.parseIndexExpression(left).(*ast.IndexExpression)
+		if !ok {
+			return nil
+		}
+		exp.Optional = true
+		return exp
+	}
+
+	exp, ok := p.parseMemberExpression(left).(*ast.MemberExpression)
+	if !ok {
+		return nil
 	}
+	exp.Optional = true
+	return exp
+}
+
+// parsePrefixIncrement parses ++x and --x.
+func (p *Parser) parsePrefixIncrement() ast.Expression {
+	expression := &ast.PrefixExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+	}
+
 	p.nextToken()
-	args = append(args, p.parseExpression(LOWEST))
+
+	// Unlike the other prefix operators, ++ and -- do not take a **
+	// expression as their operand, as it could never be assigned to.
+	expression.Right = p.parseExpression(EXP)
+	if expression.Right == nil {
+		return nil
+	}
+
+	if !p.checkAssignTarget(expression.Right, incrementAction(expression.Operator)) {
+		return nil
+	}
+	return expression
+}
+
+// parsePostfixExpression parses x++ and x--.
+func (p *Parser) parsePostfixExpression(left ast.Expression) ast.Expression {
+	expression := &ast.PostfixExpression{
+		Token:    p.curToken,
+		Left:     left,
+		Operator: p.curToken.Literal,
+	}
+
+	if !p.checkAssignTarget(left, incrementAction(expression.Operator)) {
+		return nil
+	}
+	return expression
+}
+
+func incrementAction(operator string) string {
+	if operator == "++" {
+		return "increment"
+	}
+	return "decrement"
+}
+
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{Token: p.curToken, Object: object}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	return exp
+}
+
+// checkAssignTarget reports an error unless target is a variable, an
+// index expression or a member, the only things that can be assigned to.
+// action describes what was attempted, e.g. "assign to".
+func (p *Parser) checkAssignTarget(target ast.Expression, action string) bool {
+	switch target := target.(type) {
+	case *ast.Identifier:
+		return true
+	case *ast.IndexExpression:
+		if !target.Optional {
+			return true
+		}
+	case *ast.MemberExpression:
+		if !target.Optional {
+			return true
+		}
+	}
+
+	d := p.errorf(ErrInvalidAssignTarget, p.curToken, "cannot %s %s", action, target.String())
+	d.Span = diagnostic.Span{Start: target.Pos(), End: target.End()}
+	d.Hints = append(d.Hints, "only variables, index expressions and members can be assigned to, optional chains cannot")
+	return false
+}
+
+// parseAssignExpression parses = and the compound assignment operators.
+// Assignments are right-associative, so a = b = c assigns c to b first,
+// and only variables, index expressions and members can be assigned to.
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+
+	if !p.checkAssignTarget(target, "assign to") {
+		return nil
+	}
+
+	precedence := p.rightPrecedence()
+	p.nextToken()
+	expression.Value = p.parseExpression(precedence)
+	if expression.Value == nil {
+		return nil
+	}
+	return expression
+}
+
+// parseExpressionList parses a comma separated list of expressions up to
+// and including the end token. It is shared by call arguments and array
+// literals. It returns nil if any of the expressions is invalid.
+func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	open := p.curToken
+	list := []ast.Expression{}
+	if p.peekTokenIs(end) {
+		p.nextToken()
+		return list
+	}
+	p.nextToken()
+	exp := p.parseExpression(LOWEST)
+	if exp == nil {
+		return nil
+	}
+	list = append(list, exp)
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		exp := p.parseExpression(LOWEST)
+		if exp == nil {
+			return nil
+		}
+		list = append(list, exp)
 	}
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectClosing(end, open) {
 		return nil
 	}
-	return args
+	return list
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -443,19 +1116,30 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
-		return p
+	if op, ok := precedences[p.peekToken.Type]; ok {
+		return op.precedence
 	}
 	return LOWEST
 }
 
 func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
-		return p
+	if op, ok := precedences[p.curToken.Type]; ok {
+		return op.precedence
 	}
 	return LOWEST
 }
 
+// rightPrecedence returns the precedence to parse the right operand of the
+// current operator with. Lowering it by one for right-associative operators
+// lets the operand swallow another operator of the same precedence.
+func (p *Parser) rightPrecedence() int {
+	op := precedences[p.curToken.Type]
+	if op.associativity == RightAssoc {
+		return op.precedence - 1
+	}
+	return op.precedence
+}
+
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"staq/ast"
+	"staq/diagnostic"
 	"staq/lexer"
 	"testing"
 )
@@ -206,6 +207,75 @@ func TestFloatLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestNumberLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"0xFF", 255},
+		{"0o77", 63},
+		{"0b1010", 10},
+		{"0x_dead_beef", 0xdeadbeef},
+		{"1_000_000", 1000000},
+		{"1_000.5", 1000.5},
+		{"1.5e-3", 0.0015},
+		{"2E3", 2000.0},
+		// Integers that don't fit in an int64 and decimals are checked
+		// by their string form.
+		{"99999999999999999999", "99999999999999999999"},
+		{"0xFFFF_FFFF_FFFF_FFFF", "18446744073709551615"},
+		{"1.10d", "1.10"},
+		{"1.5e-3d", "0.0015"},
+		{"2d", "2"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		switch expected := tt.expected.(type) {
+		case int:
+			literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+			if !ok {
+				t.Fatalf("%q - exp not *ast.IntegerLiteral. got=%T", tt.input, stmt.Expression)
+			}
+			if literal.Value != int64(expected) {
+				t.Errorf("%q - literal.Value not %d. got=%d", tt.input, expected, literal.Value)
+			}
+		case float64:
+			literal, ok := stmt.Expression.(*ast.FloatLiteral)
+			if !ok {
+				t.Fatalf("%q - exp not *ast.FloatLiteral. got=%T", tt.input, stmt.Expression)
+			}
+			if literal.Value != expected {
+				t.Errorf("%q - literal.Value not %g. got=%g", tt.input, expected, literal.Value)
+			}
+		case string:
+			var got string
+			switch literal := stmt.Expression.(type) {
+			case *ast.IntegerLiteral:
+				if literal.Big == nil {
+					t.Fatalf("%q - literal.Big not set", tt.input)
+				}
+				got = literal.Big.String()
+			case *ast.DecimalLiteral:
+				got = literal.Value.String()
+			default:
+				t.Fatalf("%q - exp not a big integer or decimal literal. got=%T", tt.input, stmt.Expression)
+			}
+			if got != expected {
+				t.Errorf("%q - literal value not %s. got=%s", tt.input, expected, got)
+			}
+		}
+		if stmt.Expression.TokenLiteral() != tt.input {
+			t.Errorf("%q - TokenLiteral wrong. got=%q", tt.input, stmt.Expression.TokenLiteral())
+		}
+	}
+}
+
 func TestPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -512,6 +582,46 @@ func TestOperatorPrecedence(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"a * [1, 2, 3, 4][b * c] * d",
+			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
+		},
+		{
+			"add(a * b[2], b[1], 2 * [1, 2][1])",
+			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
+		},
+		{
+			"a[0](x)[1]",
+			"((a[0])(x)[1])",
+		},
+		{
+			"-a[0]",
+			"(-(a[0]))",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2 ** 2))",
+		},
+		{
+			"2 ** -1",
+			"(2 ** (-1))",
+		},
+		{
+			"-a ** -b ** c",
+			"(-(a ** (-(b ** c))))",
+		},
+		{
+			"a ?? b ?? c",
+			"(a ?? (b ?? c))",
+		},
+		{
+			"a * b ** c * d",
+			"((a * (b ** c)) * d)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -760,3 +870,1098 @@ func TestCallExpression(t *testing.T) {
 	testInfixExpression(t, exp.Arguments[1], 2, "*", 3)
 	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
 }
+
+func TestParsingArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp not ast.ArrayLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(array.Elements) != 3 {
+		t.Fatalf("len(array.Elements) not 3. got=%d", len(array.Elements))
+	}
+
+	testIntegerLiteral(t, array.Elements[0], 1)
+	testInfixExpression(t, array.Elements[1], 2, "*", 2)
+	testInfixExpression(t, array.Elements[2], 3, "+", 3)
+}
+
+func TestParsingEmptyArrayLiterals(t *testing.T) {
+	input := "[]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp not ast.ArrayLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(array.Elements) != 0 {
+		t.Errorf("len(array.Elements) not 0. got=%d", len(array.Elements))
+	}
+}
+
+func TestParsingIndexExpressions(t *testing.T) {
+	input := "myArray[1 + 1]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, indexExp.Left, "myArray") {
+		return
+	}
+
+	if !testInfixExpression(t, indexExp.Index, 1, "+", 1) {
+		return
+	}
+}
+
+func TestParsingHashLiterals(t *testing.T) {
+	input := `{1: 2, true: 3, x: 4.25}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+
+	expected := []struct {
+		key   interface{}
+		value interface{}
+	}{
+		{1, 2},
+		{true, 3},
+		{"x", 4.25},
+	}
+
+	for i, pair := range hash.Pairs {
+		testLiteralExpression(t, pair.Key, expected[i].key)
+		testLiteralExpression(t, pair.Value, expected[i].value)
+	}
+
+	if hash.String() != "{1: 2, true: 3, x: 4.25}" {
+		t.Errorf("hash.String() wrong. got=%q", hash.String())
+	}
+}
+
+func TestParsingEmptyHashLiteral(t *testing.T) {
+	input := "{}"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 0 {
+		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+}
+
+func TestParsingHashLiteralsWithExpressions(t *testing.T) {
+	input := `{1: 0 + 1, 2: 10 - 8, 3: 15 / 5}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+
+	tests := []func(ast.Expression){
+		func(e ast.Expression) { testInfixExpression(t, e, 0, "+", 1) },
+		func(e ast.Expression) { testInfixExpression(t, e, 10, "-", 8) },
+		func(e ast.Expression) { testInfixExpression(t, e, 15, "/", 5) },
+	}
+
+	for i, pair := range hash.Pairs {
+		testIntegerLiteral(t, pair.Key, int64(i+1))
+		tests[i](pair.Value)
+	}
+}
+
+func TestParsingInvalidHashLiteral(t *testing.T) {
+	inputs := []string{
+		"{1 2}",
+		"{1: 2 3: 4}",
+	}
+
+	for _, input := range inputs {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q, got none", input)
+		}
+	}
+}
+
+func TestStringLiteralExpression(t *testing.T) {
+	input := `"hello world";`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.StringLiteral. got=%T", stmt.Expression)
+	}
+
+	if literal.Value != "hello world" {
+		t.Errorf("literal.Value not %q. got=%q", "hello world", literal.Value)
+	}
+}
+
+func TestStringLiteralRoundTrip(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let name = "StaQ";`, `let name = "StaQ";`},
+		{`"a\"b\"c"`, `"a\"b\"c"`},
+		{`"tab\tnew\nline\r"`, `"tab\tnew\nline\r"`},
+		{`"back\\slash"`, `"back\\slash"`},
+		{`"\b\f"`, `"\b\f"`},
+		{`"a" + "b"`, `("a" + "b")`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q",
+				tt.expected, program.String())
+		}
+
+		// Parsing the output again must yield the same program.
+		l = lexer.New(program.String())
+		p = New(l)
+		reparsed := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if reparsed.String() != program.String() {
+			t.Errorf("round trip changed the program. expected=%q, got=%q",
+				program.String(), reparsed.String())
+		}
+	}
+}
+
+func TestUnterminatedStringLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"hello`, `1:1: unterminated string literal "hello`},
+		{`"hello\`, `1:1: unterminated string literal "hello\`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("expected 1 parser error for %q, got=%d (%v)",
+				tt.input, len(errors), errors)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b;
+};
+add(1, [2, 3][0]);
+{"k": -x}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	letStmt := program.Statements[0].(*ast.LetStatement)
+	function := letStmt.Value.(*ast.FunctionLiteral)
+	body := function.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1].(*ast.IndexExpression)
+	hash := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.HashLiteral)
+
+	tests := []struct {
+		node          ast.Node
+		expectedStart string
+		expectedEnd   string
+	}{
+		{program, "1:1", "5:10"},
+		{letStmt, "1:1", "3:2"},
+		{letStmt.Name, "1:5", "1:8"},
+		{function, "1:11", "3:2"},
+		{function.Body, "1:20", "3:2"},
+		{body, "2:3", "2:8"},
+		{body.Expression.(*ast.InfixExpression).Right, "2:7", "2:8"},
+		{call, "4:1", "4:18"},
+		{call.Arguments[0], "4:5", "4:6"},
+		{index, "4:8", "4:17"},
+		{index.Left, "4:8", "4:14"},
+		{hash, "5:1", "5:10"},
+		{hash.Pairs[0].Key, "5:2", "5:5"},
+		{hash.Pairs[0].Value, "5:7", "5:9"},
+	}
+
+	for i, tt := range tests {
+		if tt.node.Pos().String() != tt.expectedStart {
+			t.Errorf("tests[%d] (%s) - Pos() wrong. expected=%s, got=%s",
+				i, tt.node, tt.expectedStart, tt.node.Pos())
+		}
+		if tt.node.End().String() != tt.expectedEnd {
+			t.Errorf("tests[%d] (%s) - End() wrong. expected=%s, got=%s",
+				i, tt.node, tt.expectedEnd, tt.node.End())
+		}
+	}
+
+	if program.End().Offset != len(input) {
+		t.Errorf("program.End().Offset wrong. expected=%d, got=%d",
+			len(input), program.End().Offset)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"add(1, 2;", "1:9: expected next token to be ), got ; instead"},
+		{"let x = 1;\nlet = 2;", "2:5: expected next token to be IDENT, got = instead"},
+		{"1 +\n  ;", "2:3: no prefix parse function for ; found"},
+		{"let x = 1;\n\n   1e9999999d;", "3:4: could not parse \"1e9999999d\" as a decimal"},
+		{"let año = 1 +;", "1:14: no prefix parse function for ; found"},
+		{"let mask = 0b102;", "1:12: invalid digit '2' in binary literal \"0b102\""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewFile("", tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+
+	l := lexer.NewFile("script.sq", "let x 1;")
+	p := New(l)
+	p.ParseProgram()
+	expected := "script.sq:1:7: expected next token to be =, got INT instead"
+	if len(p.Errors()) == 0 || p.Errors()[0] != expected {
+		t.Errorf("wrong errors. expected first=%q, got=%q", expected, p.Errors())
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedCode    diagnostic.Code
+		expectedSpan    string
+		expectedRelated []string
+	}{
+		{"add(1, 2;", ErrUnexpectedToken, "1:9-1:10", []string{"1:4 unclosed ( opened here"}},
+		{"[1, 2", ErrUnexpectedToken, "1:6-1:6", []string{"1:1 unclosed [ opened here"}},
+		{"(1 + 2", ErrUnexpectedToken, "1:7-1:7", []string{"1:1 unclosed ( opened here"}},
+		{"a[1;", ErrUnexpectedToken, "1:4-1:5", []string{"1:2 unclosed [ opened here"}},
+		{"{1: 2", ErrUnexpectedToken, "1:6-1:6", []string{"1:1 unclosed { opened here"}},
+		{"let 5 = 1;", ErrUnexpectedToken, "1:5-1:6", nil},
+		{"*5", ErrExpectedExpression, "1:1-1:2", nil},
+		{"1e999", ErrInvalidFloat, "1:1-1:6", nil},
+		{"1e9999999d", ErrInvalidDecimal, "1:1-1:11", nil},
+		{`"abc`, ErrUnterminatedString, "1:1-1:5", nil},
+		{"@", ErrIllegalToken, "1:1-1:2", nil},
+		{"1 /* x", ErrUnterminatedComment, "1:3-1:7", nil},
+		{"0x;", ErrMalformedNumber, "1:1-1:3", nil},
+		{"let b = 0b102;", ErrMalformedNumber, "1:9-1:14", nil},
+		{`"\u{110000}"`, ErrInvalidEscape, "1:1-1:13", nil},
+		{"\"año\" \xff", ErrIllegalToken, "1:7-1:8", nil},
+		{"fn(a, b, a) { a }", ErrDuplicateParameter, "1:10-1:11", []string{"1:4 first declared here"}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) == 0 {
+			t.Errorf("expected diagnostics for %q, got none", tt.input)
+			continue
+		}
+		d := diagnostics[0]
+
+		if d.Severity != diagnostic.Error {
+			t.Errorf("%q - severity wrong. got=%s", tt.input, d.Severity)
+		}
+		if d.Code != tt.expectedCode {
+			t.Errorf("%q - code wrong. expected=%s, got=%s", tt.input, tt.expectedCode, d.Code)
+		}
+		span := d.Span.Start.String() + "-" + d.Span.End.String()
+		if span != tt.expectedSpan {
+			t.Errorf("%q - span wrong. expected=%s, got=%s", tt.input, tt.expectedSpan, span)
+		}
+		if len(d.Related) != len(tt.expectedRelated) {
+			t.Errorf("%q - related wrong. expected=%v, got=%+v", tt.input, tt.expectedRelated, d.Related)
+			continue
+		}
+		for i, r := range d.Related {
+			related := r.Span.Start.String() + " " + r.Message
+			if related != tt.expectedRelated[i] {
+				t.Errorf("%q - related[%d] wrong. expected=%q, got=%q",
+					tt.input, i, tt.expectedRelated[i], related)
+			}
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	input := `let f = fn(x) { let y = x * 2; if (y > 2) { return y; } else { y + 1 } };
+for (let i = 0; i < 2; i++) { while (!done) { } }
+for (k in {"a": fn() { 1 }}) { k; }
+f(1)`
+	expected := `let f = fn(x) {
+    let y = (x * 2);
+    if (y > 2) {
+        return y;
+    } else {
+        (y + 1);
+    };
+};
+for (let i = 0; (i < 2); (i++)) {
+    while (!done) { }
+}
+for (k in {"a": fn() {
+    1;
+}}) {
+    k;
+}
+f(1);
+`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	formatted := ast.Format(program)
+	if formatted != expected {
+		t.Fatalf("formatted program wrong.\nwant=\n%s\ngot=\n%s", expected, formatted)
+	}
+
+	p = New(lexer.New(formatted))
+	reparsed := p.ParseProgram()
+	checkParserErrors(t, p)
+	if reparsed.String() != program.String() {
+		t.Errorf("formatted program parses differently.\nwant=%q\ngot=%q", program.String(), reparsed.String())
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements string
+	}{
+		{
+			"let = 5; let x 5; let y = 10;",
+			[]string{
+				"1:5: expected next token to be IDENT, got = instead",
+				"1:16: expected next token to be =, got INT instead",
+			},
+			"let y = 10;",
+		},
+		{
+			"let a = ; let b = 2; return ); let c = 3;",
+			[]string{
+				"1:9: no prefix parse function for ; found",
+				"1:29: no prefix parse function for ) found",
+			},
+			"let b = 2;let c = 3;",
+		},
+		{
+			"let x = 1 +\nlet y = 2;",
+			[]string{"2:1: no prefix parse function for LET found"},
+			"let y = 2;",
+		},
+		{
+			"}; let x = 1;",
+			[]string{"1:1: no prefix parse function for } found"},
+			"let x = 1;",
+		},
+		{
+			"let f = fn() { let = 1; let y = 2; y }; f();",
+			[]string{"1:20: expected next token to be IDENT, got = instead"},
+			"let f = fn() let y = 2;y;f()",
+		},
+		{
+			"if (x) { 1 + } else { * }; 3",
+			[]string{
+				"1:14: no prefix parse function for } found",
+				"1:23: no prefix parse function for * found",
+			},
+			"if (x) {  } else {  }3",
+		},
+		{
+			"let f = fn(a, 1) { a }; let g = fn(a) { a };",
+			[]string{"1:15: expected next token to be IDENT, got INT instead"},
+			"let g = fn(a) a;",
+		},
+		{
+			"fn() { 1",
+			[]string{"1:9: expected } to close the block, got EOF instead"},
+			"",
+		},
+		{
+			"let x =",
+			[]string{"1:8: no prefix parse function for EOF found"},
+			"",
+		},
+		{
+			"let x = (1 + 2; let y = [1, 2; let z = {1: 2;",
+			[]string{
+				"1:15: expected next token to be ), got ; instead",
+				"1:30: expected next token to be ], got ; instead",
+				"1:45: expected next token to be }, got ; instead",
+			},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("%q - wrong number of errors. expected=%q, got=%q",
+				tt.input, tt.expectedErrors, errors)
+			continue
+		}
+		for i, msg := range errors {
+			if msg != tt.expectedErrors[i] {
+				t.Errorf("%q - errors[%d] wrong. expected=%q, got=%q",
+					tt.input, i, tt.expectedErrors[i], msg)
+			}
+		}
+
+		for i, stmt := range program.Statements {
+			if stmt == nil {
+				t.Fatalf("%q - program.Statements[%d] is nil", tt.input, i)
+			}
+		}
+
+		if program.String() != tt.expectedStatements {
+			t.Errorf("%q - program wrong. expected=%q, got=%q",
+				tt.input, tt.expectedStatements, program.String())
+		}
+	}
+}
+
+func TestSemicolonsAreOptional(t *testing.T) {
+	input := `let x = 5
+return x
+x`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if program.String() != "let x = 5;return x;x" {
+		t.Errorf("program wrong. got=%q", program.String())
+	}
+}
+
+func TestEmptyReturn(t *testing.T) {
+	input := `return; fn() { return }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+			len(program.Statements))
+	}
+
+	returnStmt := program.Statements[0].(*ast.ReturnStatement)
+	if returnStmt.ReturnValue != nil {
+		t.Errorf("returnStmt.ReturnValue not nil. got=%s", returnStmt.ReturnValue)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `let age = 1;
+let max = 255; // Numbers can also be written in hexadecimal notation
+let half = age ~/ 2; // integer division
+/* let ignored = 1; */
+half`
+
+	for _, mode := range []lexer.Mode{0, lexer.ScanComments} {
+		l := lexer.NewWithMode("", input, mode)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		expected := "let age = 1;let max = 255;let half = (age ~/ 2);half"
+		if program.String() != expected {
+			t.Errorf("program wrong. expected=%q, got=%q", expected, program.String())
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+			len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d", len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("body.Statements[1] is not ast.BreakStatement. got=%T",
+			stmt.Body.Statements[1])
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for (let i = 0; i < 10; f(i)) { i }", "for (let i = 0; (i < 10); f(i)) { i }"},
+		{"for (i; i < 10; ) { continue }", "for (i; (i < 10); ) { continue; }"},
+		{"for (;;) { break; }", "for (; ; ) { break; }"},
+		{"for (x in [1, 2]) { x }", "for (x in [1, 2]) { x }"},
+		{"for (c in \"abc\") { while (true) { break } }", "for (c in \"abc\") { while (true) { break; } }"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q - program.Statements does not contain 1 statement. got=%d",
+				tt.input, len(program.Statements))
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("%q - expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestSemicolonAfterLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 0; while (x < 3) { x++; }; x", "let x = 0;while ((x < 3)) { (x++) }x"},
+		{"for (let i = 0; i < 3; i++) { i };", "for (let i = 0; (i < 3); (i++)) { i }"},
+		{"for (x in xs) { x }; 1", "for (x in xs) { x }1"},
+		{"while (true) { while (false) { }; break; }", "while (true) { while (false) {  }break; }"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("%q - expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestForInStatement(t *testing.T) {
+	input := `for (item in items) { item }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ForInStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForInStatement. got=%T",
+			program.Statements[0])
+	}
+	if !testIdentifier(t, stmt.Variable, "item") {
+		return
+	}
+	if !testIdentifier(t, stmt.Iterable, "items") {
+		return
+	}
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside of a loop"},
+		{"if (true) { continue }", "1:13: continue outside of a loop"},
+		{"while (true) { fn() { break } }", "1:23: break outside of a loop"},
+		{"while true { 1 }", "1:7: expected next token to be (, got TRUE instead"},
+		{"for (let i = 0 i < 10; i) {}", "1:16: expected next token to be ;, got IDENT instead"},
+		{"for (x in) {}", "1:10: no prefix parse function for ) found"},
+		{"for (;;) 1", "1:10: expected next token to be {, got INT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "(x = 5)"},
+		{"x = y = z", "(x = (y = z))"},
+		{"x += 1 + 2", "(x += (1 + 2))"},
+		{"x -= y *= 2", "(x -= (y *= 2))"},
+		{"a[i] /= 2", "((a[i]) /= 2)"},
+		{"config.name = \"staq\"", "((config.name) = \"staq\")"},
+		{"a.b.c = a.b[0]", "(((a.b).c) = ((a.b)[0]))"},
+		{"(x) = 1", "(x = 1)"},
+		{"x = fn(y) { y = 1 }", "(x = fn(y) (y = 1))"},
+		{"x = y == z", "(x = (y == z))"},
+		{"let x = y = 1;", "let x = (y = 1);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("%q - expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestMemberExpression(t *testing.T) {
+	input := "config.name"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.MemberExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Object, "config") {
+		return
+	}
+	if !testIdentifier(t, exp.Property, "name") {
+		return
+	}
+}
+
+func TestInvalidAssignTargets(t *testing.T) {
+	tests := []struct {
+		input        string
+		expected     string
+		expectedSpan string
+	}{
+		{"1 += 2", "1:1: cannot assign to 1", "1:1-1:2"},
+		{"a + b = c", "1:1: cannot assign to (a + b)", "1:1-1:6"},
+		{"x == y = 1", "1:1: cannot assign to (x == y)", "1:1-1:7"},
+		{"f() = 1", "1:1: cannot assign to f()", "1:1-1:4"},
+		{"let x = -y = 1", "1:9: cannot assign to (-y)", "1:9-1:11"},
+		{"config. = 1", "1:9: expected next token to be IDENT, got = instead", "1:9-1:10"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+		span := p.Diagnostics()[0].Span
+		if actual := span.Start.String() + "-" + span.End.String(); actual != tt.expectedSpan {
+			t.Errorf("wrong span for %q. expected=%s, got=%s", tt.input, tt.expectedSpan, actual)
+		}
+	}
+}
+
+func TestIncrementExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"i++", "(i++)"},
+		{"i--", "(i--)"},
+		{"++i", "(++i)"},
+		{"--i", "(--i)"},
+		{"a[0]++", "((a[0])++)"},
+		{"++config.count", "(++(config.count))"},
+		{"-i++", "(-(i++))"},
+		{"i++ + ++j", "((i++) + (++j))"},
+		{"x = i++", "(x = (i++))"},
+		{"for (let i = 0; i < 10; i++) { continue }", "for (let i = 0; (i < 10); (i++)) { continue; }"},
+		{"for (; n > 0; --n) { n }", "for (; (n > 0); (--n)) { n }"},
+		{"while (i-- > 0) { ++count }", "while (((i--) > 0)) { (++count) }"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("%q - expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestPostfixExpression(t *testing.T) {
+	input := "count++;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.PostfixExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.PostfixExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Left, "count") {
+		return
+	}
+	if exp.Operator != "++" {
+		t.Errorf("exp.Operator is not '++'. got=%q", exp.Operator)
+	}
+	if exp.End().Column != 8 {
+		t.Errorf("exp.End() wrong. got=%s", exp.End())
+	}
+}
+
+func TestInvalidIncrementTargets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5++", "1:1: cannot increment 5"},
+		{"--5", "1:3: cannot decrement 5"},
+		{"f()++", "1:1: cannot increment f()"},
+		{"(a + b)--", "1:2: cannot decrement (a + b)"},
+		{"i++++", "1:1: cannot increment (i++)"},
+		{"++-i", "1:3: cannot increment (-i)"},
+		{"for (let i = 0; i < 3; 3++) { }", "1:24: cannot increment 3"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+// binaryOperators is the precedence and associativity of every binary
+// operator in token/token.go, from the loosest to the tightest. It is
+// written out here independently of the parser's table on purpose.
+var binaryOperators = []struct {
+	operator string
+	level    int
+	right    bool
+}{
+	{"??", 1, true},
+	{"||", 2, false},
+	{"&&", 3, false},
+	{"|", 4, false},
+	{"^", 5, false},
+	{"&", 6, false},
+	{"==", 7, false},
+	{"!=", 7, false},
+	{"<", 8, false},
+	{">", 8, false},
+	{"<=", 8, false},
+	{">=", 8, false},
+	{"<<", 9, false},
+	{">>", 9, false},
+	{"+", 10, false},
+	{"-", 10, false},
+	{"*", 11, false},
+	{"/", 11, false},
+	{"~/", 11, false},
+	{"%", 11, false},
+	{"**", 12, true},
+}
+
+var assignOperators = []string{"=", "+=", "-=", "*=", "/="}
+
+func TestPrecedenceMatrix(t *testing.T) {
+	check := func(input, expected string) {
+		t.Helper()
+		l := lexer.New(input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != expected {
+			t.Errorf("%q - expected=%q, got=%q", input, expected, actual)
+		}
+	}
+
+	for _, op1 := range binaryOperators {
+		for _, op2 := range binaryOperators {
+			input := fmt.Sprintf("a %s b %s c", op1.operator, op2.operator)
+			expected := fmt.Sprintf("((a %s b) %s c)", op1.operator, op2.operator)
+			if op2.level > op1.level || op2.level == op1.level && op1.right {
+				expected = fmt.Sprintf("(a %s (b %s c))", op1.operator, op2.operator)
+			}
+			check(input, expected)
+		}
+	}
+
+	for _, op := range binaryOperators {
+		for _, prefix := range []string{"-", "!", "~"} {
+			input := fmt.Sprintf("%sa %s b", prefix, op.operator)
+			expected := fmt.Sprintf("((%sa) %s b)", prefix, op.operator)
+			if op.operator == "**" {
+				expected = fmt.Sprintf("(%s(a ** b))", prefix)
+			}
+			check(input, expected)
+			check(fmt.Sprintf("a %s %sb", op.operator, prefix),
+				fmt.Sprintf("(a %s (%sb))", op.operator, prefix))
+		}
+		for _, postfix := range []string{"++", "--"} {
+			check(fmt.Sprintf("a%s %s b", postfix, op.operator),
+				fmt.Sprintf("((a%s) %s b)", postfix, op.operator))
+			check(fmt.Sprintf("a %s b%s", op.operator, postfix),
+				fmt.Sprintf("(a %s (b%s))", op.operator, postfix))
+		}
+		for _, assign := range assignOperators {
+			check(fmt.Sprintf("a %s b %s c", assign, op.operator),
+				fmt.Sprintf("(a %s (b %s c))", assign, op.operator))
+		}
+	}
+
+	for _, op1 := range assignOperators {
+		for _, op2 := range assignOperators {
+			check(fmt.Sprintf("a %s b %s c", op1, op2),
+				fmt.Sprintf("(a %s (b %s c))", op1, op2))
+		}
+	}
+}
+
+func TestAssignmentBindsLoosest(t *testing.T) {
+	for _, op := range binaryOperators {
+		for _, assign := range assignOperators {
+			input := fmt.Sprintf("a %s b %s c", op.operator, assign)
+			expected := fmt.Sprintf("1:1: cannot assign to (a %s b)", op.operator)
+
+			l := lexer.New(input)
+			p := New(l)
+			p.ParseProgram()
+
+			errors := p.Errors()
+			if len(errors) == 0 || errors[0] != expected {
+				t.Errorf("%q - expected error %q, got=%q", input, expected, errors)
+			}
+		}
+	}
+}
+
+func TestNullLiteral(t *testing.T) {
+	l := lexer.New("null;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	if _, ok := stmt.Expression.(*ast.NullLiteral); !ok {
+		t.Fatalf("exp not *ast.NullLiteral. got=%T", stmt.Expression)
+	}
+	if stmt.Expression.String() != "null" {
+		t.Errorf("exp.String() wrong. got=%q", stmt.Expression.String())
+	}
+}
+
+func TestOptionalChaining(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a?.b", "(a?.b)"},
+		{"a?.[0]", "(a?.[0])"},
+		{"a?.b.c", "((a?.b).c)"},
+		{"a?.b?.[i + 1]?.c", "(((a?.b)?.[(i + 1)])?.c)"},
+		{"a?.f(x)", "(a?.f)(x)"},
+		{"a?.b ?? c", "((a?.b) ?? c)"},
+		{"-a?.b", "(-(a?.b))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("%q - expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+
+	l := lexer.New("a?.[0]")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	index, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	if !ok || !index.Optional {
+		t.Fatalf("expected an optional *ast.IndexExpression. got=%#v", index)
+	}
+}
+
+func TestOptionalChainingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a?.b = 1", "1:1: cannot assign to (a?.b)"},
+		{"a?.[0] += 1", "1:1: cannot assign to (a?.[0])"},
+		{"a?.b++", "1:1: cannot increment (a?.b)"},
+		{"a?.5", "1:4: expected next token to be IDENT, got INT instead"},
+		{"a?.[0", "1:6: expected next token to be ], got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
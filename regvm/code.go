@@ -0,0 +1,198 @@
+package regvm
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type opcode byte
+
+// Registers are numbered from the base of the current frame: a function's
+// locals come first, followed by the registers that hold intermediate
+// results. Operands named rk are either a register, when they are not
+// negative, or the constant -rk-1.
+const (
+	// opMove copies rk b into register a.
+	opMove opcode = iota
+	// opPop records rk a as the value of the last expression statement
+	// of the main program.
+	opPop
+
+	// Infix operators store rk b op rk c in register a.
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opFloorDiv
+	opMod
+	opPow
+	opBitAnd
+	opBitOr
+	opBitXor
+	opShl
+	opShr
+	opEqual
+	opNotEqual
+	opLessThan
+	opLessEqual
+	opGreaterThan
+	opGreaterEqual
+
+	// Prefix operators store op rk b in register a. For opIncrement and
+	// opDecrement, c is 1 for the postfix forms.
+	opMinus
+	opBang
+	opBitNot
+	opIncrement
+	opDecrement
+
+	// opJump jumps to instruction a. The conditional jumps test rk b:
+	// opJumpIf and opJumpIfNot for truthiness, opJumpNull and
+	// opJumpNotNull for null.
+	opJump
+	opJumpIf
+	opJumpIfNot
+	opJumpNull
+	opJumpNotNull
+
+	// opGetGlobal loads global b into register a. opSetGlobal and
+	// opAssignGlobal store rk b in global a, see code.OpAssignGlobal.
+	opGetGlobal
+	opSetGlobal
+	opAssignGlobal
+
+	// Captured locals hold a cell. opGetCell loads the value of the cell
+	// in register b into register a, opSetCell stores rk b in the cell
+	// in register a and opNewCell puts a new cell holding rk b into
+	// register a.
+	opGetCell
+	opSetCell
+	opNewCell
+
+	// opGetFree loads free variable b of the current closure into
+	// register a and opSetFree stores rk b in free variable a. opFreeCell
+	// loads the cell of free variable b itself, to be captured again.
+	opGetFree
+	opSetFree
+	opFreeCell
+
+	// opArray and opHash build an array, or a hash of key/value pairs,
+	// from the b registers starting at register a, and store it in
+	// register a.
+	opArray
+	opHash
+
+	// opIndex stores rk b[rk c] in register a and opMember stores rk
+	// b.c, where c is the constant holding the name. opSetIndex evaluates
+	// a[rk b] = rk c and opSetMember a.b = rk c, storing the value in
+	// register a.
+	opIndex
+	opSetIndex
+	opMember
+	opSetMember
+
+	// opCall calls the closure in register a with the b arguments in the
+	// registers after it, which become the first locals of the callee,
+	// and stores the result in register a. opReturn returns rk a.
+	opCall
+	opReturn
+
+	// opClosure stores a closure of function constant b, over the c cells
+	// in the registers starting at a, in register a.
+	opClosure
+
+	// opIter stores an iterator over rk b in register a. opIterNext loads
+	// the next element of the iterator in register b into register a, or
+	// jumps to instruction c when there are none left.
+	opIter
+	opIterNext
+)
+
+var opcodeNames = [...]string{
+	opMove:         "MOVE",
+	opPop:          "POP",
+	opAdd:          "ADD",
+	opSub:          "SUB",
+	opMul:          "MUL",
+	opDiv:          "DIV",
+	opFloorDiv:     "FLOORDIV",
+	opMod:          "MOD",
+	opPow:          "POW",
+	opBitAnd:       "BITAND",
+	opBitOr:        "BITOR",
+	opBitXor:       "BITXOR",
+	opShl:          "SHL",
+	opShr:          "SHR",
+	opEqual:        "EQ",
+	opNotEqual:     "NE",
+	opLessThan:     "LT",
+	opLessEqual:    "LE",
+	opGreaterThan:  "GT",
+	opGreaterEqual: "GE",
+	opMinus:        "MINUS",
+	opBang:         "BANG",
+	opBitNot:       "BITNOT",
+	opIncrement:    "INC",
+	opDecrement:    "DEC",
+	opJump:         "JMP",
+	opJumpIf:       "JMPIF",
+	opJumpIfNot:    "JMPIFNOT",
+	opJumpNull:     "JMPNULL",
+	opJumpNotNull:  "JMPNOTNULL",
+	opGetGlobal:    "GETGLOBAL",
+	opSetGlobal:    "SETGLOBAL",
+	opAssignGlobal: "ASSIGNGLOBAL",
+	opGetCell:      "GETCELL",
+	opSetCell:      "SETCELL",
+	opNewCell:      "NEWCELL",
+	opGetFree:      "GETFREE",
+	opSetFree:      "SETFREE",
+	opFreeCell:     "FREECELL",
+	opArray:        "ARRAY",
+	opHash:         "HASH",
+	opIndex:        "INDEX",
+	opSetIndex:     "SETINDEX",
+	opMember:       "MEMBER",
+	opSetMember:    "SETMEMBER",
+	opCall:         "CALL",
+	opReturn:       "RETURN",
+	opClosure:      "CLOSURE",
+	opIter:         "ITER",
+	opIterNext:     "ITERNEXT",
+}
+
+// instruction is a register instruction. The meaning of the operands
+// depends on the opcode.
+type instruction struct {
+	op      opcode
+	a, b, c int
+}
+
+type instructions []instruction
+
+// String disassembles ins, one instruction per line, prefixed with its
+// index. Constant operands print as true, false, null, or K followed by
+// the index of the constant in the bytecode.
+func (ins instructions) String() string {
+	var out bytes.Buffer
+
+	for i, in := range ins {
+		fmt.Fprintf(&out, "%04d %s %d %s %s\n", i, opcodeNames[in.op], in.a, fmtRK(in.b), fmtRK(in.c))
+	}
+
+	return out.String()
+}
+
+func fmtRK(rk int) string {
+	switch {
+	case rk == constantRK(trueConstant):
+		return "true"
+	case rk == constantRK(falseConstant):
+		return "false"
+	case rk == constantRK(nullConstant):
+		return "null"
+	case rk < 0:
+		return fmt.Sprintf("K%d", -rk-1-firstConstant)
+	}
+	return fmt.Sprintf("%d", rk)
+}
@@ -0,0 +1,15 @@
+package regvm
+
+import "staq/object"
+
+const ITERATOR_OBJ = "ITERATOR"
+
+// iterator is the state of a for-in loop, which opIter keeps in a register
+// while the loop runs. Programs never get hold of one.
+type iterator struct {
+	elements []object.Object
+	next     int
+}
+
+func (i *iterator) Type() object.ObjectType { return ITERATOR_OBJ }
+func (i *iterator) Inspect() string         { return "<iterator>" }
@@ -0,0 +1,201 @@
+package regvm
+
+import (
+	"errors"
+	"math"
+	"staq/evaluator"
+	"staq/object"
+)
+
+var infixOperators = [...]string{
+	opAdd:          "+",
+	opSub:          "-",
+	opMul:          "*",
+	opDiv:          "/",
+	opFloorDiv:     "~/",
+	opMod:          "%",
+	opPow:          "**",
+	opBitAnd:       "&",
+	opBitOr:        "|",
+	opBitXor:       "^",
+	opShl:          "<<",
+	opShr:          ">>",
+	opEqual:        "==",
+	opNotEqual:     "!=",
+	opLessThan:     "<",
+	opLessEqual:    "<=",
+	opGreaterThan:  ">",
+	opGreaterEqual: ">=",
+}
+
+var prefixOperators = [...]string{
+	opMinus:  "-",
+	opBang:   "!",
+	opBitNot: "~",
+}
+
+// binary applies an infix operator. Integers and floats are computed
+// directly, unless the result needs more care, such as an integer that
+// overflows or a division by zero; everything else is left to the
+// evaluator, on boxed values.
+func binary(op opcode, l, r value) (value, error) {
+	if l.kind == kindInt && r.kind == kindInt {
+		if result, ok := binaryInt(op, l.i, r.i); ok {
+			return result, nil
+		}
+	} else if l.kind != kindObject && r.kind != kindObject {
+		if result, ok := binaryFloat(op, toFloat(l), toFloat(r)); ok {
+			return result, nil
+		}
+	}
+
+	return fromObject(evaluator.Infix(infixOperators[op], l.box(), r.box()))
+}
+
+func binaryInt(op opcode, l, r int64) (value, bool) {
+	switch op {
+	case opAdd:
+		if sum := l + r; (sum > l) == (r > 0) {
+			return intValue(sum), true
+		}
+	case opSub:
+		if diff := l - r; (diff < l) == (r > 0) {
+			return intValue(diff), true
+		}
+	case opMul:
+		if product := l * r; l == 0 || (product/l == r && !(l == -1 && r == math.MinInt64)) {
+			return intValue(product), true
+		}
+	case opDiv:
+		if r != 0 {
+			return floatValue(float64(l) / float64(r)), true
+		}
+	case opFloorDiv:
+		if r != 0 && !(l == math.MinInt64 && r == -1) {
+			q := l / r
+			if l%r != 0 && (l < 0) != (r < 0) {
+				q--
+			}
+			return intValue(q), true
+		}
+	case opMod:
+		if r != 0 {
+			m := l % r
+			if m != 0 && (m < 0) != (r < 0) {
+				m += r
+			}
+			return intValue(m), true
+		}
+	case opBitAnd:
+		return intValue(l & r), true
+	case opBitOr:
+		return intValue(l | r), true
+	case opBitXor:
+		return intValue(l ^ r), true
+	case opEqual:
+		return boolValue(l == r), true
+	case opNotEqual:
+		return boolValue(l != r), true
+	case opLessThan:
+		return boolValue(l < r), true
+	case opLessEqual:
+		return boolValue(l <= r), true
+	case opGreaterThan:
+		return boolValue(l > r), true
+	case opGreaterEqual:
+		return boolValue(l >= r), true
+	}
+	return value{}, false
+}
+
+func binaryFloat(op opcode, l, r float64) (value, bool) {
+	switch op {
+	case opAdd:
+		return floatValue(l + r), true
+	case opSub:
+		return floatValue(l - r), true
+	case opMul:
+		return floatValue(l * r), true
+	case opDiv:
+		if r != 0 {
+			return floatValue(l / r), true
+		}
+	case opFloorDiv:
+		if r != 0 {
+			return floatValue(math.Floor(l / r)), true
+		}
+	case opMod:
+		if r != 0 {
+			return floatValue(l - r*math.Floor(l/r)), true
+		}
+	case opPow:
+		return floatValue(math.Pow(l, r)), true
+	case opEqual:
+		return boolValue(l == r), true
+	case opNotEqual:
+		return boolValue(l != r), true
+	case opLessThan:
+		return boolValue(l < r), true
+	case opLessEqual:
+		return boolValue(l <= r), true
+	case opGreaterThan:
+		return boolValue(l > r), true
+	case opGreaterEqual:
+		return boolValue(l >= r), true
+	}
+	return value{}, false
+}
+
+// unary applies a prefix operator, or ++ and -- when op is opIncrement or
+// opDecrement.
+func unary(op opcode, v value, postfix bool) (value, error) {
+	switch {
+	case op == opBang:
+		return boolValue(!v.truthy()), nil
+	case v.kind == kindInt:
+		switch {
+		case op == opMinus && v.i != math.MinInt64:
+			return intValue(-v.i), nil
+		case op == opBitNot:
+			return intValue(^v.i), nil
+		case op == opIncrement && v.i != math.MaxInt64:
+			return intValue(v.i + 1), nil
+		case op == opDecrement && v.i != math.MinInt64:
+			return intValue(v.i - 1), nil
+		}
+	case v.kind == kindFloat:
+		switch op {
+		case opMinus:
+			return floatValue(-v.f), nil
+		case opIncrement:
+			return floatValue(v.f + 1), nil
+		case opDecrement:
+			return floatValue(v.f - 1), nil
+		}
+	}
+
+	switch op {
+	case opIncrement:
+		return fromObject(evaluator.Increment("++", postfix, v.box()))
+	case opDecrement:
+		return fromObject(evaluator.Increment("--", postfix, v.box()))
+	default:
+		return fromObject(evaluator.Prefix(prefixOperators[op], v.box()))
+	}
+}
+
+func toFloat(v value) float64 {
+	if v.kind == kindInt {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// fromObject unboxes the result of one of the evaluator's operators,
+// turning errors into Go errors.
+func fromObject(obj object.Object) (value, error) {
+	if err, ok := obj.(*object.Error); ok {
+		return value{}, errors.New(err.Message)
+	}
+	return unbox(obj), nil
+}
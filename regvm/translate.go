@@ -0,0 +1,460 @@
+package regvm
+
+import (
+	"fmt"
+	"staq/code"
+)
+
+// function is the register code of a compiled function, or of the main
+// program.
+type function struct {
+	instructions instructions
+	// offsets holds the offset in the stack code of the instruction each
+	// instruction was translated from, and lines the source lines of
+	// those.
+	offsets   []int
+	lines     code.LineTable
+	numLocals int
+	// numRegisters is the size of the function's frame: its locals and
+	// the registers for intermediate results.
+	numRegisters int
+}
+
+// slotKind says where the value of a slot of the operand stack is.
+type slotKind byte
+
+const (
+	// inSlot values are in the slot's own register.
+	inSlot slotKind = iota
+	// inRegister values are in another register, a local or a lower slot.
+	inRegister
+	// inConstant values are a constant.
+	inConstant
+)
+
+type slot struct {
+	kind slotKind
+	rk   int
+}
+
+type fixup struct {
+	at     int // index of the jump instruction
+	target int // offset in the stack code
+}
+
+// translator turns the stack code of the compiler package into register
+// code. The compiler leaves every value on the operand stack, and the
+// depth of the stack is the same whenever an instruction is reached, so
+// each slot of the stack can get a register of its own. Values are only
+// copied into their slot when necessary: reading a local or a constant
+// just remembers where the value is, so that `x + 1` becomes a single
+// instruction reading the local and the constant directly.
+type translator struct {
+	ins       code.Instructions
+	main      bool
+	numLocals int
+
+	out instructions
+	// offset is the offset of the instruction being translated, recorded
+	// in offsets for every instruction of out.
+	offset  int
+	offsets []int
+
+	slots []slot
+	// maxSlots is the deepest the operand stack gets.
+	maxSlots int
+
+	// depths holds the depth of the stack at the targets of the jumps
+	// translated so far, and labels where their register code starts.
+	depths  map[int]int
+	labels  map[int]int
+	fixups  []fixup
+	targets map[int]bool
+}
+
+// The constants of a machine start with true, false and null, followed by
+// the constants of the bytecode, so that the translation of a function
+// stays valid when more constants are added, as the REPL does.
+const (
+	trueConstant = iota
+	falseConstant
+	nullConstant
+	// firstConstant is the index of the first constant of the bytecode.
+	firstConstant
+)
+
+var literalConstants = map[code.Opcode]int{
+	code.OpTrue:  trueConstant,
+	code.OpFalse: falseConstant,
+	code.OpNull:  nullConstant,
+}
+
+// constantRK returns the operand reading the constant at index i of the
+// machine.
+func constantRK(i int) int {
+	return -i - 1
+}
+
+// translate returns the register code for ins, the instructions of a
+// function with numLocals locals and with the line table lines.
+func translate(ins code.Instructions, lines code.LineTable, numLocals int, main bool) (*function, error) {
+	t := &translator{
+		ins:       ins,
+		main:      main,
+		numLocals: numLocals,
+		depths:    make(map[int]int),
+		labels:    make(map[int]int),
+		targets:   jumpTargets(ins),
+	}
+
+	if err := t.translate(); err != nil {
+		return nil, err
+	}
+
+	for _, f := range t.fixups {
+		label, ok := t.labels[f.target]
+		if !ok {
+			return nil, fmt.Errorf("jump to %d is not an instruction", f.target)
+		}
+		if t.out[f.at].op == opIterNext {
+			t.out[f.at].c = label
+		} else {
+			t.out[f.at].a = label
+		}
+	}
+
+	return &function{
+		instructions: t.out,
+		offsets:      t.offsets,
+		lines:        lines,
+		numLocals:    numLocals,
+		numRegisters: numLocals + t.maxSlots,
+	}, nil
+}
+
+func jumpTargets(ins code.Instructions) map[int]bool {
+	targets := make(map[int]bool)
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			break
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		switch code.Opcode(ins[i]) {
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy,
+			code.OpJumpNull, code.OpJumpNotNull, code.OpIterNext:
+			targets[operands[0]] = true
+		}
+		i += 1 + read
+	}
+
+	return targets
+}
+
+func (t *translator) translate() error {
+	for ip := 0; ip <= len(t.ins); {
+		t.offset = ip
+		if t.targets[ip] {
+			// Code after an unconditional jump or a return is translated
+			// too, even if nothing jumps to it, since the depth of the
+			// stack is only known by following the code in order.
+			t.materializeAll()
+			if depth, ok := t.depths[ip]; ok {
+				t.reset(depth)
+			}
+			t.labels[ip] = len(t.out)
+		}
+		if ip == len(t.ins) {
+			break
+		}
+
+		op := code.Opcode(t.ins[ip])
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			return err
+		}
+		operands, read := code.ReadOperands(def, t.ins[ip+1:])
+		ip += 1 + read
+
+		if err := t.translateInstruction(op, operands); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *translator) translateInstruction(op code.Opcode, operands []int) error {
+	switch op {
+	case code.OpConstant:
+		t.push(slot{kind: inConstant, rk: constantRK(firstConstant + operands[0])})
+
+	case code.OpTrue, code.OpFalse, code.OpNull:
+		t.push(slot{kind: inConstant, rk: constantRK(literalConstants[op])})
+
+	case code.OpPop:
+		value := t.popRK()
+		if t.main {
+			t.emit(opPop, value, 0, 0)
+		}
+
+	case code.OpDup:
+		t.push(t.alias(len(t.slots) - 1))
+
+	case code.OpDup2:
+		n := len(t.slots)
+		t.push(t.alias(n - 2))
+		t.push(t.alias(n - 1))
+
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpFloorDiv,
+		code.OpMod, code.OpPow, code.OpBitAnd, code.OpBitOr, code.OpBitXor,
+		code.OpShl, code.OpShr, code.OpEqual, code.OpNotEqual,
+		code.OpLessThan, code.OpLessEqual, code.OpGreaterThan,
+		code.OpGreaterEqual:
+		right := t.popRK()
+		left := t.popRK()
+		t.emit(opAdd+opcode(op-code.OpAdd), t.pushSlot(), left, right)
+
+	case code.OpMinus, code.OpBang, code.OpBitNot:
+		operand := t.popRK()
+		t.emit(opMinus+opcode(op-code.OpMinus), t.pushSlot(), operand, 0)
+
+	case code.OpIncrement, code.OpDecrement:
+		operand := t.popRK()
+		t.emit(opIncrement+opcode(op-code.OpIncrement), t.pushSlot(), operand, operands[0])
+
+	case code.OpJump:
+		t.materializeAll()
+		t.jump(opJump, 0, operands[0])
+
+	case code.OpJumpNotTruthy, code.OpJumpTruthy:
+		condition := t.popRK()
+		t.materializeAll()
+		jump := opJumpIfNot
+		if op == code.OpJumpTruthy {
+			jump = opJumpIf
+		}
+		t.jump(jump, condition, operands[0])
+
+	case code.OpJumpNull, code.OpJumpNotNull:
+		t.materializeAll()
+		top := t.register(len(t.slots) - 1)
+		if op == code.OpJumpNull {
+			t.jump(opJumpNull, top, operands[0])
+		} else {
+			t.jump(opJumpNotNull, top, operands[0])
+			t.pop()
+		}
+
+	case code.OpGetGlobal:
+		t.emit(opGetGlobal, t.pushSlot(), operands[0], 0)
+
+	case code.OpSetGlobal, code.OpAssignGlobal:
+		value := t.popRK()
+		if op == code.OpSetGlobal {
+			t.emit(opSetGlobal, operands[0], value, 0)
+		} else {
+			t.emit(opAssignGlobal, operands[0], value, 0)
+		}
+
+	case code.OpGetLocal, code.OpCaptureLocal:
+		t.push(slot{kind: inRegister, rk: operands[0]})
+
+	case code.OpSetLocal:
+		value := t.popRK()
+		t.materializeUses(operands[0])
+		if value != operands[0] {
+			t.emit(opMove, operands[0], value, 0)
+		}
+
+	case code.OpGetLocalCell:
+		t.emit(opGetCell, t.pushSlot(), operands[0], 0)
+
+	case code.OpSetLocalCell:
+		value := t.popRK()
+		t.emit(opSetCell, operands[0], value, 0)
+
+	case code.OpDefineLocalCell:
+		value := t.popRK()
+		t.materializeUses(operands[0])
+		t.emit(opNewCell, operands[0], value, 0)
+
+	case code.OpGetFree:
+		t.emit(opGetFree, t.pushSlot(), operands[0], 0)
+
+	case code.OpSetFree:
+		value := t.popRK()
+		t.emit(opSetFree, operands[0], value, 0)
+
+	case code.OpCaptureFree:
+		t.emit(opFreeCell, t.pushSlot(), operands[0], 0)
+
+	case code.OpArray:
+		first := t.popInSlots(operands[0])
+		t.emit(opArray, first, operands[0], 0)
+		t.pushSlot()
+
+	case code.OpHash:
+		first := t.popInSlots(2 * operands[0])
+		t.emit(opHash, first, 2*operands[0], 0)
+		t.pushSlot()
+
+	case code.OpIndex:
+		index := t.popRK()
+		left := t.popRK()
+		t.emit(opIndex, t.pushSlot(), left, index)
+
+	case code.OpSetIndex:
+		value := t.popRK()
+		index := t.popRK()
+		left := t.popInSlots(1)
+		t.emit(opSetIndex, left, index, value)
+		t.pushSlot()
+
+	case code.OpMember:
+		obj := t.popRK()
+		t.emit(opMember, t.pushSlot(), obj, constantRK(firstConstant+operands[0]))
+
+	case code.OpSetMember:
+		value := t.popRK()
+		obj := t.popInSlots(1)
+		t.emit(opSetMember, obj, constantRK(firstConstant+operands[0]), value)
+		t.pushSlot()
+
+	case code.OpCall:
+		callee := t.popInSlots(operands[0] + 1)
+		t.emit(opCall, callee, operands[0], 0)
+		t.pushSlot()
+
+	case code.OpReturnValue:
+		t.emit(opReturn, t.popRK(), 0, 0)
+
+	case code.OpReturn:
+		t.emit(opReturn, constantRK(nullConstant), 0, 0)
+
+	case code.OpClosure:
+		first := t.popInSlots(operands[1])
+		t.emit(opClosure, first, firstConstant+operands[0], operands[1])
+		t.pushSlot()
+
+	case code.OpIter:
+		iterable := t.popRK()
+		t.emit(opIter, t.pushSlot(), iterable, 0)
+
+	case code.OpIterNext:
+		t.materializeAll()
+		iter := t.register(len(t.slots) - 1)
+		t.jump(opIterNext, iter, operands[0])
+		t.out[len(t.out)-1].a = t.pushSlot()
+
+	default:
+		def, _ := code.Lookup(byte(op))
+		return fmt.Errorf("opcode %s not supported", def.Name)
+	}
+
+	return nil
+}
+
+func (t *translator) emit(op opcode, a, b, c int) {
+	t.out = append(t.out, instruction{op: op, a: a, b: b, c: c})
+	t.offsets = append(t.offsets, t.offset)
+}
+
+// jump emits a jump to target, an offset in the stack code, which is
+// patched once the whole function is translated. operand is the operand
+// the jump tests, if any.
+func (t *translator) jump(op opcode, operand int, target int) {
+	if _, ok := t.depths[target]; !ok {
+		t.depths[target] = len(t.slots)
+	}
+	t.fixups = append(t.fixups, fixup{at: len(t.out), target: target})
+	t.emit(op, 0, operand, 0)
+}
+
+// register returns the register of the slot at index i.
+func (t *translator) register(i int) int {
+	return t.numLocals + i
+}
+
+func (t *translator) push(s slot) {
+	t.slots = append(t.slots, s)
+	if len(t.slots) > t.maxSlots {
+		t.maxSlots = len(t.slots)
+	}
+}
+
+// pushSlot pushes a value that is about to be stored in the register of
+// its slot, which it returns.
+func (t *translator) pushSlot() int {
+	t.push(slot{kind: inSlot})
+	return t.register(len(t.slots) - 1)
+}
+
+func (t *translator) pop() slot {
+	s := t.slots[len(t.slots)-1]
+	t.slots = t.slots[:len(t.slots)-1]
+	return s
+}
+
+// popRK pops a value and returns the operand reading it.
+func (t *translator) popRK() int {
+	s := t.pop()
+	if s.kind == inSlot {
+		return t.register(len(t.slots))
+	}
+	return s.rk
+}
+
+// alias returns a copy of the slot at index i.
+func (t *translator) alias(i int) slot {
+	if t.slots[i].kind == inSlot {
+		return slot{kind: inRegister, rk: t.register(i)}
+	}
+	return t.slots[i]
+}
+
+// popInSlots pops n values, making sure they are in the registers of
+// their slots, and returns the register of the first one.
+func (t *translator) popInSlots(n int) int {
+	first := len(t.slots) - n
+	for i := first; i < len(t.slots); i++ {
+		t.materialize(i)
+	}
+	t.slots = t.slots[:first]
+	return t.register(first)
+}
+
+func (t *translator) materialize(i int) {
+	if t.slots[i].kind != inSlot {
+		t.emit(opMove, t.register(i), t.slots[i].rk, 0)
+		t.slots[i] = slot{kind: inSlot}
+	}
+}
+
+// materializeAll puts every value in the register of its slot, which is
+// where the code at jump targets expects them.
+func (t *translator) materializeAll() {
+	for i := range t.slots {
+		t.materialize(i)
+	}
+}
+
+// materializeUses copies the values that are still read from register r
+// before it is overwritten.
+func (t *translator) materializeUses(r int) {
+	for i, s := range t.slots {
+		if s.kind == inRegister && s.rk == r {
+			t.materialize(i)
+		}
+	}
+}
+
+// reset starts over at a jump target, where the stack has the given depth
+// and all values are in their slots.
+func (t *translator) reset(depth int) {
+	t.slots = t.slots[:0]
+	for i := 0; i < depth; i++ {
+		t.push(slot{kind: inSlot})
+	}
+}
@@ -0,0 +1,62 @@
+package regvm
+
+import "staq/object"
+
+type kind byte
+
+const (
+	kindObject kind = iota
+	kindInt
+	kindFloat
+)
+
+// value is the content of a register. Integers that fit in an int64 and
+// floats are kept unboxed, so that arithmetic on them allocates nothing.
+// They are only boxed when they are stored where objects are expected,
+// such as in arrays, globals and cells.
+type value struct {
+	kind kind
+	i    int64
+	f    float64
+	obj  object.Object
+}
+
+func intValue(i int64) value     { return value{kind: kindInt, i: i} }
+func floatValue(f float64) value { return value{kind: kindFloat, f: f} }
+
+func boolValue(b bool) value {
+	if b {
+		return value{obj: True}
+	}
+	return value{obj: False}
+}
+
+func unbox(obj object.Object) value {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return intValue(obj.Value)
+	case *object.Float:
+		return floatValue(obj.Value)
+	}
+	return value{obj: obj}
+}
+
+func (v value) box() object.Object {
+	switch v.kind {
+	case kindInt:
+		return &object.Integer{Value: v.i}
+	case kindFloat:
+		return &object.Float{Value: v.f}
+	}
+	return v.obj
+}
+
+func (v value) truthy() bool {
+	switch v.kind {
+	case kindInt:
+		return v.i != 0
+	case kindFloat:
+		return v.f != 0
+	}
+	return object.IsTruthy(v.obj)
+}
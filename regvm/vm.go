@@ -0,0 +1,430 @@
+// Package regvm runs the bytecode of the compiler package on a register
+// machine. Each function's stack code is translated into register code
+// before it runs, see translator, and registers hold integers and floats
+// unboxed. Values are otherwise the same objects the evaluator uses, and
+// operators other than the arithmetic fast paths are applied by the
+// evaluator's own functions, so programs give the same results and errors
+// with every engine.
+package regvm
+
+import (
+	"errors"
+	"fmt"
+	"staq/code"
+	"staq/compiler"
+	"staq/evaluator"
+	"staq/object"
+)
+
+const (
+	// RegistersSize is the number of registers a store starts with. The
+	// registers grow as calls need more, so only MaxFrames limits how deep
+	// a program can call.
+	RegistersSize = 1 << 14
+	GlobalsSize   = 65536
+	// MaxFrames is the number of nested calls a program can make,
+	// counting the main program, the same for every engine.
+	MaxFrames = evaluator.MaxCallDepth
+)
+
+var (
+	Null  = evaluator.NULL
+	True  = evaluator.TRUE
+	False = evaluator.FALSE
+)
+
+// frame is the activation of a closure. ip is only up to date for the
+// frames below the current one.
+type frame struct {
+	fn   *function
+	cl   *object.Closure
+	ip   int
+	base int
+}
+
+type VM struct {
+	// constants are true, false and null followed by the constants of the
+	// bytecode, unboxed, see firstConstant.
+	constants   []value
+	functions   map[*object.CompiledFunction]*function
+	globals     []object.Object
+	globalNames []string
+
+	registers []value
+	store     *Store
+
+	frames      []frame
+	framesIndex int
+
+	lastPopped value
+	// err is set if the bytecode could not be translated.
+	err error
+}
+
+// Store is what machines keep from one REPL line to the next: the
+// globals, the translations of the functions compiled so far and the
+// registers.
+type Store struct {
+	globals   []object.Object
+	functions map[*object.CompiledFunction]*function
+	registers []value
+}
+
+// NewStore returns a store that keeps the globals in globals.
+func NewStore(globals []object.Object) *Store {
+	return &Store{
+		globals:   globals,
+		functions: make(map[*object.CompiledFunction]*function),
+		registers: make([]value, RegistersSize),
+	}
+}
+
+// New returns a machine ready to run bytecode.
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithStore(bytecode, NewStore(make([]object.Object, GlobalsSize)))
+}
+
+// NewWithGlobalsStore returns a machine that keeps its globals in s, so
+// that they survive from one REPL line to the next.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	return NewWithStore(bytecode, NewStore(s))
+}
+
+// NewWithStore returns a machine that keeps its state in s. Only the
+// functions s has no translation for yet are translated.
+func NewWithStore(bytecode *compiler.Bytecode, s *Store) *VM {
+	vm := &VM{
+		constants:   []value{{obj: True}, {obj: False}, {obj: Null}},
+		functions:   s.functions,
+		globals:     s.globals,
+		globalNames: bytecode.Globals,
+		registers:   s.registers,
+		store:       s,
+		frames:      make([]frame, MaxFrames),
+		framesIndex: 1,
+	}
+
+	for _, c := range bytecode.Constants {
+		vm.constants = append(vm.constants, unbox(c))
+
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok || vm.err != nil || vm.functions[fn] != nil {
+			continue
+		}
+		translated, err := translate(fn.Instructions, fn.Lines, fn.NumLocals, false)
+		if err != nil {
+			vm.err = err
+			continue
+		}
+		vm.functions[fn] = translated
+	}
+
+	mainFn, err := translate(bytecode.Instructions, bytecode.Lines, bytecode.NumLocals, true)
+	if vm.err == nil {
+		vm.err = err
+	}
+	if err == nil {
+		vm.reserve(mainFn.numRegisters)
+		// The registers may hold the locals of a previous run.
+		locals := vm.registers[:mainFn.numLocals]
+		for i := range locals {
+			locals[i] = value{}
+		}
+	}
+	vm.frames[0] = frame{fn: mainFn, cl: &object.Closure{}}
+
+	return vm
+}
+
+// LastPoppedStackElem returns the value of the last expression statement
+// that ran, or of a return statement at the top level.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped.box()
+}
+
+// Run executes the main program until it ends or fails. Runtime errors are
+// returned with the message the evaluator gives them, as a
+// code.RuntimeError with the line of the instruction that failed.
+func (vm *VM) Run() (err error) {
+	if vm.err != nil {
+		return vm.err
+	}
+
+	regs := vm.registers
+	fr := &vm.frames[vm.framesIndex-1]
+
+	ins := fr.fn.instructions
+	base := fr.base
+	ip := fr.ip
+
+	defer func() {
+		if err != nil {
+			// ip is past the instruction that failed.
+			err = code.AtLine(err, fr.fn.lines.Line(fr.fn.offsets[ip-1]))
+		}
+	}()
+
+	for ip < len(ins) {
+		in := &ins[ip]
+		ip++
+
+		switch in.op {
+		case opMove:
+			regs[base+in.a] = vm.rk(base, in.b)
+
+		case opPop:
+			vm.lastPopped = vm.rk(base, in.a)
+
+		case opAdd, opSub, opMul, opDiv, opFloorDiv, opMod, opPow, opBitAnd,
+			opBitOr, opBitXor, opShl, opShr, opEqual, opNotEqual, opLessThan,
+			opLessEqual, opGreaterThan, opGreaterEqual:
+			result, err := binary(in.op, vm.rk(base, in.b), vm.rk(base, in.c))
+			if err != nil {
+				return err
+			}
+			regs[base+in.a] = result
+
+		case opMinus, opBang, opBitNot, opIncrement, opDecrement:
+			result, err := unary(in.op, vm.rk(base, in.b), in.c == 1)
+			if err != nil {
+				return err
+			}
+			regs[base+in.a] = result
+
+		case opJump:
+			ip = in.a
+
+		case opJumpIf:
+			if vm.rk(base, in.b).truthy() {
+				ip = in.a
+			}
+
+		case opJumpIfNot:
+			if !vm.rk(base, in.b).truthy() {
+				ip = in.a
+			}
+
+		case opJumpNull, opJumpNotNull:
+			v := regs[base+in.b]
+			isNull := v.kind == kindObject && v.obj == Null
+			if isNull == (in.op == opJumpNull) {
+				ip = in.a
+			}
+
+		case opGetGlobal:
+			global := vm.globals[in.b]
+			if global == nil {
+				return fmt.Errorf("identifier not found: %s", vm.globalNames[in.b])
+			}
+			regs[base+in.a] = unbox(global)
+
+		case opSetGlobal:
+			vm.globals[in.a] = vm.rk(base, in.b).box()
+
+		case opAssignGlobal:
+			if vm.globals[in.a] == nil {
+				return fmt.Errorf("assignment to undeclared variable: %s", vm.globalNames[in.a])
+			}
+			vm.globals[in.a] = vm.rk(base, in.b).box()
+
+		case opGetCell:
+			regs[base+in.a] = unbox(regs[base+in.b].obj.(*object.Cell).Value)
+
+		case opSetCell:
+			regs[base+in.a].obj.(*object.Cell).Value = vm.rk(base, in.b).box()
+
+		case opNewCell:
+			regs[base+in.a] = value{obj: &object.Cell{Value: vm.rk(base, in.b).box()}}
+
+		case opGetFree:
+			regs[base+in.a] = unbox(fr.cl.Free[in.b].Value)
+
+		case opSetFree:
+			fr.cl.Free[in.a].Value = vm.rk(base, in.b).box()
+
+		case opFreeCell:
+			regs[base+in.a] = value{obj: fr.cl.Free[in.b]}
+
+		case opArray:
+			elements := make([]object.Object, in.b)
+			for i := range elements {
+				elements[i] = regs[base+in.a+i].box()
+			}
+			regs[base+in.a] = value{obj: &object.Array{Elements: elements}}
+
+		case opHash:
+			hash := object.NewHash()
+			for i := 0; i < in.b; i += 2 {
+				key := regs[base+in.a+i].box()
+				hashKey, ok := key.(object.Hashable)
+				if !ok {
+					return fmt.Errorf("unusable as hash key: %s", key.Type())
+				}
+				hash.Set(hashKey, regs[base+in.a+i+1].box())
+			}
+			regs[base+in.a] = value{obj: hash}
+
+		case opIndex:
+			result, err := index(vm.rk(base, in.b), vm.rk(base, in.c))
+			if err != nil {
+				return err
+			}
+			regs[base+in.a] = result
+
+		case opSetIndex:
+			result, err := setIndex(regs[base+in.a], vm.rk(base, in.b), vm.rk(base, in.c))
+			if err != nil {
+				return err
+			}
+			regs[base+in.a] = result
+
+		case opMember:
+			name := vm.rk(base, in.c).obj.(*object.String).Value
+			result, err := fromObject(evaluator.Member(vm.rk(base, in.b).box(), name))
+			if err != nil {
+				return err
+			}
+			regs[base+in.a] = result
+
+		case opSetMember:
+			name := vm.rk(base, in.b).obj.(*object.String).Value
+			result, err := fromObject(evaluator.SetMember(regs[base+in.a].box(), name, vm.rk(base, in.c).box()))
+			if err != nil {
+				return err
+			}
+			regs[base+in.a] = result
+
+		case opCall:
+			callee := regs[base+in.a]
+			cl, ok := callee.obj.(*object.Closure)
+			if !ok || callee.kind != kindObject {
+				return fmt.Errorf("not a function: %s", callee.box().Type())
+			}
+			if in.b != len(cl.Fn.Parameters) {
+				return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+					len(cl.Fn.Parameters), in.b)
+			}
+
+			fn := vm.functions[cl.Fn]
+			newBase := base + in.a + 1
+			if vm.framesIndex == MaxFrames {
+				return errors.New("stack overflow")
+			}
+			regs = vm.reserve(newBase + fn.numRegisters)
+
+			fr.ip = ip
+			vm.frames[vm.framesIndex] = frame{fn: fn, cl: cl, base: newBase}
+			vm.framesIndex++
+			fr = &vm.frames[vm.framesIndex-1]
+
+			// Clear the registers of the other locals, which may hold
+			// values of a previous call.
+			locals := regs[newBase+in.b : newBase+fn.numLocals]
+			for i := range locals {
+				locals[i] = value{}
+			}
+			for _, i := range cl.Fn.CellParameters {
+				regs[newBase+i] = value{obj: &object.Cell{Value: regs[newBase+i].box()}}
+			}
+
+			ins = fn.instructions
+			base = newBase
+			ip = 0
+
+		case opReturn:
+			result := vm.rk(base, in.a)
+
+			if vm.framesIndex == 1 {
+				// A return statement at the top level ends the program,
+				// with the returned value as its result.
+				vm.lastPopped = result
+				return nil
+			}
+
+			// The result replaces the closure in the caller's register.
+			regs[base-1] = result
+
+			vm.framesIndex--
+			fr = &vm.frames[vm.framesIndex-1]
+			ins = fr.fn.instructions
+			base = fr.base
+			ip = fr.ip
+
+		case opClosure:
+			fn := vm.constants[in.b].obj.(*object.CompiledFunction)
+			free := make([]*object.Cell, in.c)
+			for i := range free {
+				free[i] = regs[base+in.a+i].obj.(*object.Cell)
+			}
+			regs[base+in.a] = value{obj: &object.Closure{Fn: fn, Free: free}}
+
+		case opIter:
+			elements, err := evaluator.Iterate(vm.rk(base, in.b).box())
+			if err != nil {
+				return errors.New(err.Message)
+			}
+			regs[base+in.a] = value{obj: &iterator{elements: elements}}
+
+		case opIterNext:
+			iter := regs[base+in.b].obj.(*iterator)
+			if iter.next == len(iter.elements) {
+				ip = in.c
+				continue
+			}
+			regs[base+in.a] = unbox(iter.elements[iter.next])
+			iter.next++
+
+		default:
+			return fmt.Errorf("opcode %d not supported", in.op)
+		}
+	}
+
+	return nil
+}
+
+// reserve makes the registers at least n long, doubling them as often as
+// needed, and returns them. The store keeps the grown registers for the
+// next run.
+func (vm *VM) reserve(n int) []value {
+	if n > len(vm.registers) {
+		size := len(vm.registers)
+		for size < n {
+			size *= 2
+		}
+		registers := make([]value, size)
+		copy(registers, vm.registers)
+		vm.registers = registers
+		vm.store.registers = registers
+	}
+	return vm.registers
+}
+
+// rk reads operand rk, a register of the frame at base or a constant.
+func (vm *VM) rk(base, rk int) value {
+	if rk >= 0 {
+		return vm.registers[base+rk]
+	}
+	return vm.constants[-rk-1]
+}
+
+// index evaluates left[idx], reading the elements of arrays directly.
+func index(left, idx value) (value, error) {
+	if array, ok := left.obj.(*object.Array); ok && idx.kind == kindInt {
+		if idx.i >= 0 && idx.i < int64(len(array.Elements)) {
+			return unbox(array.Elements[idx.i]), nil
+		}
+	}
+	return fromObject(evaluator.Index(left.box(), idx.box()))
+}
+
+// setIndex evaluates left[idx] = val, writing the elements of arrays
+// directly.
+func setIndex(left, idx, val value) (value, error) {
+	if array, ok := left.obj.(*object.Array); ok && idx.kind == kindInt {
+		if idx.i >= 0 && idx.i < int64(len(array.Elements)) {
+			array.Elements[idx.i] = val.box()
+			return val, nil
+		}
+	}
+	return fromObject(evaluator.SetIndex(left.box(), idx.box(), val.box()))
+}
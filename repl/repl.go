@@ -4,14 +4,47 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"staq/ast"
+	"staq/compiler"
+	"staq/diagnostic"
+	"staq/evaluator"
 	"staq/lexer"
+	"staq/object"
+	"staq/optimizer"
 	"staq/parser"
+	"staq/regvm"
+	"staq/vm"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
+// Engine selects what runs the programs typed into the REPL.
+type Engine string
+
+const (
+	// Evaluator walks the AST of every line.
+	Evaluator Engine = "eval"
+	// VM compiles every line to bytecode and runs it on the virtual
+	// machine.
+	VM Engine = "vm"
+	// RegisterVM compiles every line to bytecode and runs it on the
+	// register machine.
+	RegisterVM Engine = "regvm"
+)
+
+// machine is what the REPL needs of the virtual machines.
+type machine interface {
+	Run() error
+	LastPoppedStackElem() object.Object
+}
+
+// Start reads lines from in and runs them with engine, writing their
+// values to out. Lines are optimized before they run, unless optimize is
+// false. With dumpAST, the AST of every line is written instead, as
+// formatted by ast.Format.
+func Start(in io.Reader, out io.Writer, engine Engine, dumpAST, optimize bool) {
 	scanner := bufio.NewScanner(in)
+	run := newRunner(engine)
 
 	for {
 		fmt.Print(PROMPT)
@@ -25,20 +58,85 @@ func Start(in io.Reader, out io.Writer) {
 		p := parser.New(l)
 
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		if diagnostic.HasErrors(p.Diagnostics()) {
+			printParserErrors(out, line, p.Diagnostics())
 			continue
 		}
+		if len(p.Diagnostics()) != 0 {
+			diagnostic.RenderAll(out, line, p.Diagnostics())
+		}
 
-		io.WriteString(out, program.String())
-		io.WriteString(out, "\n")
+		if optimize {
+			optimizer.Optimize(program)
+		}
+		if dumpAST {
+			io.WriteString(out, ast.Format(program))
+			continue
+		}
+
+		if result := run(program); result != nil {
+			io.WriteString(out, result.Inspect())
+			io.WriteString(out, "\n")
+		}
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
-	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+// newRunner returns a function that runs one program after the other,
+// keeping the globals of the previous ones. It returns the value to print,
+// if any.
+func newRunner(engine Engine) func(program *ast.Program) object.Object {
+	globals := make([]object.Object, vm.GlobalsSize)
+
+	var newMachine func(*compiler.Bytecode) machine
+	switch engine {
+	case VM:
+		newMachine = func(bytecode *compiler.Bytecode) machine {
+			return vm.NewWithGlobalsStore(bytecode, globals)
+		}
+	case RegisterVM:
+		// The register machine also keeps the functions it translated.
+		store := regvm.NewStore(globals)
+		newMachine = func(bytecode *compiler.Bytecode) machine {
+			return regvm.NewWithStore(bytecode, store)
+		}
+	default:
+		env := object.NewEnvironment()
+		return func(program *ast.Program) object.Object {
+			return evaluator.Eval(program, env)
+		}
+	}
+
+	constants := []object.Object{}
+	symbolTable := compiler.NewSymbolTable()
+
+	return func(program *ast.Program) object.Object {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := newMachine(bytecode)
+		if err := machine.Run(); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+
+		// Like the evaluator, only print the value of a program that
+		// ends with an expression or returns.
+		if len(program.Statements) == 0 {
+			return nil
+		}
+		switch program.Statements[len(program.Statements)-1].(type) {
+		case *ast.ExpressionStatement, *ast.ReturnStatement:
+			return machine.LastPoppedStackElem()
+		}
+		return nil
 	}
 }
+
+func printParserErrors(out io.Writer, source string, diagnostics []diagnostic.Diagnostic) {
+	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
+	diagnostic.RenderAll(out, source, diagnostics)
+}
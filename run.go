@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"staq/ast"
+	"staq/code"
+	"staq/compiler"
+	"staq/diagnostic"
+	"staq/evaluator"
+	"staq/lexer"
+	"staq/object"
+	"staq/optimizer"
+	"staq/parser"
+	"staq/regvm"
+	"staq/repl"
+	"staq/sqc"
+	"staq/token"
+	"staq/vm"
+)
+
+// machine is what running a file needs of the virtual machines.
+type machine interface {
+	Run() error
+	LastPoppedStackElem() object.Object
+}
+
+// runFile runs the program in path, a source file or a .sqc file, and
+// returns the exit status. Precompiled programs cannot be evaluated, so
+// they run on the virtual machine unless engine is the register machine.
+//
+// Like in the REPL, the value of the program is printed, unless it is
+// null. It is the value of its last statement, if that is an expression
+// or a return statement, or of a return statement that ended it early.
+func runFile(path string, engine repl.Engine, optimize bool) int {
+	if filepath.Ext(path) == ".sqc" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fail(err)
+		}
+		bytecode, err := sqc.Unmarshal(data)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", path, err))
+		}
+		return runBytecode(bytecode, engine)
+	}
+
+	program, err := parseFile(path, optimize)
+	if err != nil {
+		return fail(err)
+	}
+	if program == nil {
+		return 1
+	}
+
+	if engine == repl.Evaluator {
+		result := evaluator.Eval(program, object.NewEnvironment())
+		if _, ok := result.(*object.Error); ok {
+			fmt.Fprintln(os.Stderr, result.Inspect())
+			return 1
+		}
+		printResult(result)
+		return 0
+	}
+
+	bytecode, err := compile(program)
+	if err != nil {
+		return fail(fmt.Errorf("%s: %w", path, err))
+	}
+	return runBytecode(bytecode, engine)
+}
+
+func runBytecode(bytecode *compiler.Bytecode, engine repl.Engine) int {
+	var m machine
+	if engine == repl.RegisterVM {
+		m = regvm.New(bytecode)
+	} else {
+		m = vm.New(bytecode)
+	}
+
+	if err := m.Run(); err != nil {
+		message := err.Error()
+		var runtimeErr *code.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			message = fmt.Sprintf("line %d: %s", runtimeErr.Line, message)
+		}
+		fmt.Fprintln(os.Stderr, (&object.Error{Message: message}).Inspect())
+		return 1
+	}
+	printResult(m.LastPoppedStackElem())
+	return 0
+}
+
+func printResult(result object.Object) {
+	if result != nil && result != evaluator.NULL {
+		fmt.Println(result.Inspect())
+	}
+}
+
+// dumpFile prints the AST of the source file path, optimized unless
+// optimize is false, as formatted by ast.Format, and returns the exit
+// status.
+func dumpFile(path string, optimize bool) int {
+	if filepath.Ext(path) == ".sqc" {
+		return fail(fmt.Errorf("%s: a .sqc file has no AST to print", path))
+	}
+
+	program, err := parseFile(path, optimize)
+	if err != nil {
+		return fail(err)
+	}
+	if program == nil {
+		return 1
+	}
+
+	fmt.Print(ast.Format(program))
+	return 0
+}
+
+// parseFile parses the source file path and, if optimize is true,
+// optimizes it. If it has errors, they are printed and the program is nil.
+func parseFile(path string, optimize bool) (*ast.Program, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.NewFile(path, string(source)))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		diagnostic.RenderAll(os.Stderr, string(source), p.Diagnostics())
+	}
+	if diagnostic.HasErrors(p.Diagnostics()) {
+		return nil, nil
+	}
+
+	if optimize {
+		optimizer.Optimize(program)
+	}
+	return program, nil
+}
+
+// compile compiles the program of a file. Programs that do not end with
+// an expression or a return statement get a final null, so that the last
+// value the machine pops is the value of the program, also once it is
+// saved to a .sqc file.
+func compile(program *ast.Program) (*compiler.Bytecode, error) {
+	statements := program.Statements
+	if n := len(statements); n == 0 || !endsWithValue(statements[n-1]) {
+		null := &ast.NullLiteral{Token: token.Token{Type: token.NULL, Literal: "null"}}
+		statements = append(statements[:n:n], &ast.ExpressionStatement{Token: null.Token, Expression: null})
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(&ast.Program{Statements: statements}); err != nil {
+		return nil, err
+	}
+	return comp.Bytecode(), nil
+}
+
+func endsWithValue(statement ast.Statement) bool {
+	switch statement.(type) {
+	case *ast.ExpressionStatement, *ast.ReturnStatement:
+		return true
+	}
+	return false
+}
+
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "staq: %s\n", err)
+	return 1
+}
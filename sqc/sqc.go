@@ -0,0 +1,500 @@
+// Package sqc reads and writes precompiled StaQ programs, the bytecode of
+// the compiler package saved to .sqc files.
+//
+// A .sqc file starts with the magic bytes "SQC\x00" and a big-endian
+// uint16 version, and ends with the big-endian CRC-32 (IEEE) of everything
+// before it. In between is the program, encoded with varints:
+//
+//	program   = numLocals globals constants instructions lines
+//	globals   = count string...
+//	constants = count constant...
+//	constant  = tag payload
+//	function  = instructions lines parameters numLocals cellParameters
+//	lines     = count (offsetDelta lineDelta)...
+//
+// Strings and instructions are prefixed with their length. Functions,
+// including the ones nested in others, are constants like any other, and
+// refer to each other by their index in the constant pool, just as the
+// instructions of the compiler do.
+package sqc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"staq/code"
+	"staq/compiler"
+	"staq/decimal"
+	"staq/object"
+)
+
+// Version is the version of the format written by Marshal, the only one
+// Unmarshal reads. It changes whenever the format or the meaning of the
+// instructions does, since old files would not run correctly.
+const Version = 1
+
+const magic = "SQC\x00"
+
+const (
+	headerSize   = len(magic) + 2
+	checksumSize = 4
+)
+
+var (
+	// ErrNotSQC is returned by Unmarshal for data that does not start
+	// with the magic bytes of a .sqc file.
+	ErrNotSQC = errors.New("not a .sqc file")
+	// ErrChecksum is returned by Unmarshal for data that was changed
+	// after it was written.
+	ErrChecksum = errors.New("checksum mismatch, the file is corrupt")
+)
+
+// Tags of the constants.
+const (
+	tagInteger byte = iota + 1
+	tagBigInteger
+	tagFloat
+	tagDecimal
+	tagString
+	tagFunction
+)
+
+// Marshal encodes bytecode in the .sqc format.
+func Marshal(bytecode *compiler.Bytecode) ([]byte, error) {
+	e := &encoder{}
+	e.buf.WriteString(magic)
+	binary.Write(&e.buf, binary.BigEndian, uint16(Version))
+
+	e.uint(bytecode.NumLocals)
+	e.strings(bytecode.Globals)
+
+	e.uint(len(bytecode.Constants))
+	for _, c := range bytecode.Constants {
+		if err := e.constant(c); err != nil {
+			return nil, err
+		}
+	}
+
+	e.bytes(bytecode.Instructions)
+	e.lines(bytecode.Lines)
+
+	binary.Write(&e.buf, binary.BigEndian, crc32.ChecksumIEEE(e.buf.Bytes()))
+	return e.buf.Bytes(), nil
+}
+
+// Unmarshal decodes a program in the .sqc format, checking that it is
+// intact and well-formed: that its instructions are complete, only refer
+// to constants, globals, locals and free variables that exist, and find
+// on the stack, in the locals and in the closures the kind of value they
+// expect. Files that the compiler did not write, or that were changed
+// by hand, are refused rather than left to make a machine fail.
+func Unmarshal(data []byte) (*compiler.Bytecode, error) {
+	if len(data) < headerSize+checksumSize || string(data[:len(magic)]) != magic {
+		return nil, ErrNotSQC
+	}
+	if v := binary.BigEndian.Uint16(data[len(magic):]); v != Version {
+		return nil, fmt.Errorf("unsupported .sqc version %d, want %d", v, Version)
+	}
+
+	body, sum := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, ErrChecksum
+	}
+
+	d := &decoder{data: body[headerSize:]}
+	bytecode := &compiler.Bytecode{}
+
+	bytecode.NumLocals = d.uint()
+	bytecode.Globals = d.strings()
+
+	n := d.count()
+	bytecode.Constants = make([]object.Object, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.constant())
+	}
+
+	bytecode.Instructions = d.bytes()
+	bytecode.Lines = d.lines()
+
+	if d.err == nil && len(d.data) != 0 {
+		d.fail("%d unexpected bytes at the end", len(d.data))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if err := validate(bytecode); err != nil {
+		return nil, err
+	}
+	return bytecode, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint(x int) {
+	e.buf.Write(binary.AppendUvarint(nil, uint64(x)))
+}
+
+func (e *encoder) int(x int64) {
+	e.buf.Write(binary.AppendVarint(nil, x))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint(len(b))
+	e.buf.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) strings(s []string) {
+	e.uint(len(s))
+	for _, str := range s {
+		e.string(str)
+	}
+}
+
+func (e *encoder) lines(lines code.LineTable) {
+	e.uint(len(lines))
+
+	offset, line := 0, 0
+	for _, entry := range lines {
+		e.uint(entry.Offset - offset)
+		e.int(int64(entry.Line - line))
+		offset, line = entry.Offset, entry.Line
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(tagInteger)
+		e.int(obj.Value)
+	case *object.BigInteger:
+		e.buf.WriteByte(tagBigInteger)
+		e.string(obj.Value.String())
+	case *object.Float:
+		e.buf.WriteByte(tagFloat)
+		binary.Write(&e.buf, binary.BigEndian, math.Float64bits(obj.Value))
+	case *object.Decimal:
+		e.buf.WriteByte(tagDecimal)
+		e.string(obj.Value.Unscaled().String())
+		e.int(int64(obj.Value.Scale()))
+	case *object.String:
+		e.buf.WriteByte(tagString)
+		e.string(obj.Value)
+	case *object.CompiledFunction:
+		e.buf.WriteByte(tagFunction)
+		e.bytes(obj.Instructions)
+		e.lines(obj.Lines)
+		e.strings(obj.Parameters)
+		e.uint(obj.NumLocals)
+		e.uint(len(obj.CellParameters))
+		for _, i := range obj.CellParameters {
+			e.uint(i)
+		}
+	default:
+		return fmt.Errorf("constant of type %s cannot be saved", obj.Type())
+	}
+	return nil
+}
+
+// decoder reads the body of a .sqc file. After the first error, every
+// read returns a zero value and err keeps the error.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("malformed .sqc file: "+format, a...)
+	}
+	d.data = nil
+}
+
+func (d *decoder) uint() int {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 || x > math.MaxInt32 {
+		d.fail("bad unsigned integer")
+		return 0
+	}
+	d.data = d.data[n:]
+	return int(x)
+}
+
+func (d *decoder) int() int64 {
+	x, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("bad integer")
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+// count reads the number of items that follow, each at least one byte
+// long, so that a corrupt count cannot make the decoder allocate more
+// than the data holds.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > len(d.data) {
+		d.fail("count %d larger than the data", n)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) next(n int) []byte {
+	if n > len(d.data) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+// bytes reads a byte slice, copied so that it does not keep the data of
+// the whole file alive.
+func (d *decoder) bytes() []byte {
+	return append([]byte{}, d.next(d.count())...)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	n := d.count()
+	s := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		s = append(s, d.string())
+	}
+	return s
+}
+
+func (d *decoder) lines() code.LineTable {
+	n := d.count()
+	lines := make(code.LineTable, 0, n)
+
+	offset, line := 0, 0
+	for i := 0; i < n && d.err == nil; i++ {
+		offset += d.uint()
+		line += int(d.int())
+		lines = append(lines, code.LineEntry{Offset: offset, Line: line})
+	}
+	return lines
+}
+
+func (d *decoder) constant() object.Object {
+	tag := d.next(1)
+	if d.err != nil {
+		return nil
+	}
+
+	switch tag[0] {
+	case tagInteger:
+		return &object.Integer{Value: d.int()}
+
+	case tagBigInteger:
+		value, ok := new(big.Int).SetString(d.string(), 10)
+		if !ok {
+			d.fail("bad big integer")
+		}
+		return &object.BigInteger{Value: value}
+
+	case tagFloat:
+		b := d.next(8)
+		if d.err != nil {
+			return nil
+		}
+		return &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(b))}
+
+	case tagDecimal:
+		unscaled, ok := new(big.Int).SetString(d.string(), 10)
+		scale := d.int()
+		if !ok || scale < 0 || scale > math.MaxInt32 {
+			d.fail("bad decimal")
+			return nil
+		}
+		return &object.Decimal{Value: decimal.New(unscaled, int32(scale))}
+
+	case tagString:
+		return &object.String{Value: d.string()}
+
+	case tagFunction:
+		fn := &object.CompiledFunction{
+			Instructions: d.bytes(),
+			Lines:        d.lines(),
+			Parameters:   d.strings(),
+			NumLocals:    d.uint(),
+		}
+		n := d.count()
+		for i := 0; i < n && d.err == nil; i++ {
+			fn.CellParameters = append(fn.CellParameters, d.uint())
+		}
+		return fn
+	}
+
+	d.fail("unknown constant tag %d", tag[0])
+	return nil
+}
+
+// validate checks that the instructions of the main program and of every
+// function can be run without reading past the end of the instructions,
+// the constant pool, the globals, the locals of the frame or the free
+// variables of the closure, and without misusing the stack, see
+// checkStack.
+func validate(bytecode *compiler.Bytecode) error {
+	// numFree holds the number of free variables of the closures of each
+	// function, the smallest one if it is closed over more than once.
+	numFree := make(map[int]int)
+
+	if err := validateInstructions(bytecode.Instructions, bytecode.NumLocals, bytecode, numFree); err != nil {
+		return fmt.Errorf("main program: %w", err)
+	}
+
+	for i, c := range bytecode.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumLocals < len(fn.Parameters) {
+			return fmt.Errorf("function %d: %d locals for %d parameters", i, fn.NumLocals, len(fn.Parameters))
+		}
+		for _, p := range fn.CellParameters {
+			if p >= len(fn.Parameters) {
+				return fmt.Errorf("function %d: cell parameter %d out of range", i, p)
+			}
+		}
+		if err := validateInstructions(fn.Instructions, fn.NumLocals, bytecode, numFree); err != nil {
+			return fmt.Errorf("function %d: %w", i, err)
+		}
+	}
+
+	if err := checkStack(bytecode.Instructions, newLocals(bytecode.NumLocals, 0, nil), 0, false); err != nil {
+		return fmt.Errorf("main program: %w", err)
+	}
+	for i, c := range bytecode.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		locals := newLocals(fn.NumLocals, len(fn.Parameters), fn.CellParameters)
+		if err := checkStack(fn.Instructions, locals, numFree[i], true); err != nil {
+			return fmt.Errorf("function %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// newLocals returns what the locals of a frame hold when it starts: the
+// parameters hold the arguments, in a cell for the cell parameters, and
+// the other locals are unset.
+func newLocals(numLocals, numParameters int, cellParameters []int) []kind {
+	locals := make([]kind, numLocals)
+	for i := range locals {
+		if i < numParameters {
+			locals[i] = value
+		} else {
+			locals[i] = unset
+		}
+	}
+	for _, i := range cellParameters {
+		locals[i] = cell
+	}
+	return locals
+}
+
+func validateInstructions(ins code.Instructions, numLocals int, bytecode *compiler.Bytecode, numFree map[int]int) error {
+	starts := make(map[int]bool)
+	var jumps []int
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", i, err)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return fmt.Errorf("offset %d: %s is incomplete", i, def.Name)
+		}
+		operands, _ := code.ReadOperands(def, ins[i+1:])
+
+		op := code.Opcode(ins[i])
+		if err := checkOperands(op, operands, numLocals, bytecode); err != nil {
+			return fmt.Errorf("offset %d: %s: %w", i, def.Name, err)
+		}
+		switch op {
+		case code.OpJump, code.OpJumpNotTruthy, code.OpJumpTruthy, code.OpJumpNull,
+			code.OpJumpNotNull, code.OpIterNext:
+			jumps = append(jumps, operands[0])
+		case code.OpClosure:
+			if n, ok := numFree[operands[0]]; !ok || operands[1] < n {
+				numFree[operands[0]] = operands[1]
+			}
+		}
+
+		starts[i] = true
+		i += 1 + width
+	}
+
+	for _, target := range jumps {
+		if target != len(ins) && !starts[target] {
+			return fmt.Errorf("jump to %d is not to an instruction", target)
+		}
+	}
+	return nil
+}
+
+// checkOperands checks the operands of op that refer to constants, globals
+// and locals.
+func checkOperands(op code.Opcode, operands []int, numLocals int, bytecode *compiler.Bytecode) error {
+	switch op {
+	case code.OpConstant:
+		return checkConstant(bytecode, operands[0], false)
+	case code.OpClosure:
+		return checkConstant(bytecode, operands[0], true)
+	case code.OpMember, code.OpSetMember:
+		if err := checkConstant(bytecode, operands[0], false); err != nil {
+			return err
+		}
+		if _, ok := bytecode.Constants[operands[0]].(*object.String); !ok {
+			return fmt.Errorf("constant %d is not a name", operands[0])
+		}
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpAssignGlobal:
+		if operands[0] >= len(bytecode.Globals) {
+			return fmt.Errorf("global %d out of range", operands[0])
+		}
+	case code.OpGetLocal, code.OpSetLocal, code.OpGetLocalCell, code.OpSetLocalCell,
+		code.OpDefineLocalCell, code.OpCaptureLocal:
+		if operands[0] >= numLocals {
+			return fmt.Errorf("local %d out of range", operands[0])
+		}
+	}
+	return nil
+}
+
+func checkConstant(bytecode *compiler.Bytecode, i int, function bool) error {
+	if i >= len(bytecode.Constants) {
+		return fmt.Errorf("constant %d out of range", i)
+	}
+	if _, ok := bytecode.Constants[i].(*object.CompiledFunction); ok != function {
+		return fmt.Errorf("constant %d is of the wrong kind", i)
+	}
+	return nil
+}
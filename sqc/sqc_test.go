@@ -0,0 +1,302 @@
+package sqc
+
+import (
+	"errors"
+	"staq/ast"
+	"staq/code"
+	"staq/compiler"
+	"staq/conformance"
+	"staq/lexer"
+	"staq/object"
+	"staq/parser"
+	"staq/vm"
+	"strings"
+	"testing"
+)
+
+// TestConformance runs every program of the conformance suite after a
+// round trip through the .sqc format.
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(program *ast.Program) string {
+		bytecode := roundTrip(t, compile(t, program))
+
+		machine := vm.New(bytecode)
+		if err := machine.Run(); err != nil {
+			return "ERROR: " + err.Error()
+		}
+		return machine.LastPoppedStackElem().Inspect()
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	input := `let big = 99999999999999999999;
+let d = 1.25d;
+let f = 2.5;
+let make = fn(x) {
+  let g = fn(y) { x + y };
+  g
+};
+make("a")("b");`
+
+	original := compile(t, parse(input))
+	bytecode := roundTrip(t, original)
+
+	if bytecode.NumLocals != original.NumLocals {
+		t.Errorf("wrong NumLocals. want=%d, got=%d", original.NumLocals, bytecode.NumLocals)
+	}
+	if strings.Join(bytecode.Globals, ",") != "big,d,f,make" {
+		t.Errorf("wrong globals. got=%v", bytecode.Globals)
+	}
+	if bytecode.Instructions.String() != original.Instructions.String() {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", original.Instructions, bytecode.Instructions)
+	}
+	if !equalLines(bytecode.Lines, original.Lines) {
+		t.Errorf("wrong lines. want=%v, got=%v", original.Lines, bytecode.Lines)
+	}
+
+	if len(bytecode.Constants) != len(original.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d",
+			len(original.Constants), len(bytecode.Constants))
+	}
+	for i, want := range original.Constants {
+		got := bytecode.Constants[i]
+		if got.Type() != want.Type() || got.Inspect() != want.Inspect() {
+			t.Errorf("wrong constant %d. want=%s, got=%s", i, want.Inspect(), got.Inspect())
+		}
+
+		wantFn, ok := want.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		gotFn := got.(*object.CompiledFunction)
+		if gotFn.Instructions.String() != wantFn.Instructions.String() ||
+			gotFn.NumLocals != wantFn.NumLocals ||
+			!equalLines(gotFn.Lines, wantFn.Lines) ||
+			len(gotFn.CellParameters) != len(wantFn.CellParameters) {
+			t.Errorf("wrong function %d. want=%+v, got=%+v", i, wantFn, gotFn)
+		}
+	}
+
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := machine.LastPoppedStackElem().Inspect(); got != "ab" {
+		t.Errorf("wrong result. want=%q, got=%q", "ab", got)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	data, err := Marshal(compile(t, parse("let a = fn(x) { x * 2 }; a(21)")))
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+
+	corrupt := func(change func(b []byte) []byte) []byte {
+		return change(append([]byte{}, data...))
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", nil, ErrNotSQC.Error()},
+		{"source code", []byte("let a = 1; a + 1"), ErrNotSQC.Error()},
+		{"newer version", corrupt(func(b []byte) []byte { b[5] = 2; return b }),
+			"unsupported .sqc version 2, want 1"},
+		{"flipped bit", corrupt(func(b []byte) []byte { b[len(b)/2] ^= 1; return b }),
+			ErrChecksum.Error()},
+		{"truncated", corrupt(func(b []byte) []byte { return b[:len(b)-1] }),
+			ErrChecksum.Error()},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal(tt.data)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+
+	if _, err := Unmarshal(corrupt(func(b []byte) []byte { b[0] = 'X'; return b })); !errors.Is(err, ErrNotSQC) {
+		t.Errorf("wrong error for bad magic bytes. got=%v", err)
+	}
+}
+
+func TestUnmarshalRejectsBadInstructions(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytecode *compiler.Bytecode
+		expected string
+	}{
+		{
+			"constant out of range",
+			&compiler.Bytecode{Instructions: []byte{0, 0, 5}},
+			"main program: offset 0: OpConstant: constant 5 out of range",
+		},
+		{
+			"incomplete instruction",
+			&compiler.Bytecode{
+				Instructions: []byte{0, 0},
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"main program: offset 0: OpConstant is incomplete",
+		},
+		{
+			"closure of a non-function",
+			compileBytecode(t, "fn() { 1 }", func(b *compiler.Bytecode) {
+				b.Constants[len(b.Constants)-1] = &object.Integer{Value: 1}
+			}),
+			"main program: offset 0: OpClosure: constant 1 is of the wrong kind",
+		},
+		{
+			"undefined global",
+			compileBytecode(t, "let a = 1;", func(b *compiler.Bytecode) {
+				b.Globals = nil
+			}),
+			"main program: offset 3: OpSetGlobal: global 0 out of range",
+		},
+		{
+			"local out of range",
+			compileBytecode(t, "fn(x) { x }", func(b *compiler.Bytecode) {
+				b.Constants[0].(*object.CompiledFunction).NumLocals = 0
+			}),
+			"function 0: 0 locals for 1 parameters",
+		},
+		{
+			"stack underflow",
+			&compiler.Bytecode{Instructions: code.Make(code.OpPop)},
+			"main program: offset 0: OpPop: the stack is empty",
+		},
+		{
+			"local read before it is set",
+			&compiler.Bytecode{Instructions: code.Make(code.OpGetLocal, 0), NumLocals: 1},
+			"main program: offset 0: OpGetLocal: local 0 does not always hold a value",
+		},
+		{
+			"cell of a local that holds a value",
+			compileBytecode(t, "fn(x) { x }", func(b *compiler.Bytecode) {
+				fn := b.Constants[0].(*object.CompiledFunction)
+				fn.Instructions = concat(code.Make(code.OpGetLocalCell, 0), code.Make(code.OpReturnValue))
+			}),
+			"function 0: offset 0: OpGetLocalCell: local 0 does not always hold a cell",
+		},
+		{
+			"closure over a value",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpNull), code.Make(code.OpClosure, 0, 1)),
+				Constants:    []object.Object{&object.CompiledFunction{Instructions: code.Make(code.OpReturn)}},
+			},
+			"main program: offset 1: OpClosure: found a value on the stack instead of a cell",
+		},
+		{
+			"free variable out of range",
+			&compiler.Bytecode{
+				Instructions: code.Make(code.OpClosure, 0, 0),
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: concat(code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)),
+				}},
+			},
+			"function 0: offset 0: OpGetFree: free variable 0 out of range",
+		},
+		{
+			"iteration over a value",
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpNull), code.Make(code.OpIterNext, 4))},
+			"main program: offset 1: OpIterNext: found a value on the stack instead of an iterator",
+		},
+		{
+			"paths with different stacks",
+			&compiler.Bytecode{Instructions: concat(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 5),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			)},
+			"main program: offset 5: the stack differs between the paths to it",
+		},
+		{
+			"function without a return",
+			&compiler.Bytecode{
+				Instructions: code.Make(code.OpClosure, 0, 0),
+				Constants:    []object.Object{&object.CompiledFunction{Instructions: code.Make(code.OpNull)}},
+			},
+			"function 0: the instructions end without a return",
+		},
+	}
+
+	for _, tt := range tests {
+		data, err := Marshal(tt.bytecode)
+		if err != nil {
+			t.Fatalf("%s: marshal error: %s", tt.name, err)
+		}
+		_, err = Unmarshal(data)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func roundTrip(t *testing.T, bytecode *compiler.Bytecode) *compiler.Bytecode {
+	t.Helper()
+
+	data, err := Marshal(bytecode)
+	if err != nil {
+		t.Fatalf("marshal error: %s", err)
+	}
+	result, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal error: %s", err)
+	}
+	return result
+}
+
+func compile(t *testing.T, program *ast.Program) *compiler.Bytecode {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+// compileBytecode compiles input and lets change break the result.
+func compileBytecode(t *testing.T, input string, change func(*compiler.Bytecode)) *compiler.Bytecode {
+	bytecode := compile(t, parse(input))
+	change(bytecode)
+	return bytecode
+}
+
+func concat(instructions ...[]byte) []byte {
+	var out []byte
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func equalLines(a, b []code.LineEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
@@ -0,0 +1,397 @@
+package sqc
+
+import (
+	"fmt"
+	"staq/code"
+)
+
+// kind is what a slot of the operand stack, or a local, may hold, as a set
+// of the flags below. Stack slots hold exactly one kind; locals may hold
+// several, one for each path that leads to an instruction.
+type kind byte
+
+const (
+	// unset locals were never written in the current call.
+	unset kind = 1 << iota
+	value
+	cell
+	iterator
+)
+
+func (k kind) String() string {
+	switch k {
+	case value:
+		return "a value"
+	case cell:
+		return "a cell"
+	case iterator:
+		return "an iterator"
+	}
+	return "nothing"
+}
+
+// state is what the stack and the locals of a frame hold when an
+// instruction is reached.
+type state struct {
+	stack  []kind
+	locals []kind
+}
+
+func (s state) copy() state {
+	return state{
+		stack:  append([]kind{}, s.stack...),
+		locals: append([]kind{}, s.locals...),
+	}
+}
+
+// merge returns the state at an instruction that both a and b lead to.
+// The stack must be the same on both paths, since the machines give each
+// slot of it a fixed place.
+func merge(a, b state) (state, bool) {
+	if len(a.stack) != len(b.stack) {
+		return state{}, false
+	}
+	for i := range a.stack {
+		if a.stack[i] != b.stack[i] {
+			return state{}, false
+		}
+	}
+
+	s := a.copy()
+	for i := range s.locals {
+		s.locals[i] |= b.locals[i]
+	}
+	return s, true
+}
+
+// equal reports whether s and other have the same locals, for states
+// whose stacks merge found to be the same.
+func (s state) equal(other state) bool {
+	for i := range s.locals {
+		if s.locals[i] != other.locals[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// stackChecker follows the instructions of a function, or of the main
+// program, in order, the way the register machine translates them: the
+// state after an instruction that does not fall through, such as a jump
+// or a return, carries over to the next one unless a jump leads there.
+// It checks that no instruction pops more values than the stack holds or
+// finds a value of the wrong kind there, and that the stack is the same
+// on every path to an instruction.
+type stackChecker struct {
+	ins      code.Instructions
+	numFree  int
+	function bool
+
+	entry state
+	// back holds the states that jumps backwards bring to their targets,
+	// which the next pass starts from.
+	back map[int]state
+}
+
+// checkStack checks ins, the instructions of a function with numFree free
+// variables, or of the main program, which start with the locals in
+// locals. Loops can change what the locals hold at their start, so the
+// instructions are followed again until that no longer happens.
+func checkStack(ins code.Instructions, locals []kind, numFree int, function bool) error {
+	c := &stackChecker{
+		ins:      ins,
+		numFree:  numFree,
+		function: function,
+		entry:    state{locals: locals},
+		back:     make(map[int]state),
+	}
+
+	for {
+		changed, err := c.pass()
+		if err != nil || !changed {
+			return err
+		}
+	}
+}
+
+// pass follows the instructions once and reports whether a jump backwards
+// brought a state that the instruction it leads to had not been checked
+// with.
+func (c *stackChecker) pass() (bool, error) {
+	forward := make(map[int]state)
+	reached := make(map[int]state)
+	changed := false
+
+	s := c.entry.copy()
+	falls := true
+	for ip := 0; ; {
+		if target, ok := forward[ip]; ok {
+			if falls {
+				merged, ok := merge(s, target)
+				if !ok {
+					return false, fmt.Errorf("offset %d: the stack differs between the paths to it", ip)
+				}
+				target = merged
+			}
+			s, falls = target, true
+		}
+		if target, ok := c.back[ip]; ok {
+			merged, ok := merge(s, target)
+			if !ok {
+				return false, fmt.Errorf("offset %d: the stack differs between the paths to it", ip)
+			}
+			s = merged
+		}
+		reached[ip] = s.copy()
+
+		if ip == len(c.ins) {
+			if c.function && falls {
+				return false, fmt.Errorf("the instructions end without a return")
+			}
+			return changed, nil
+		}
+
+		def, _ := code.Lookup(c.ins[ip])
+		operands, read := code.ReadOperands(def, c.ins[ip+1:])
+		op := code.Opcode(c.ins[ip])
+
+		jump, target, err := c.step(&s, op, operands)
+		if err != nil {
+			return false, fmt.Errorf("offset %d: %s: %w", ip, def.Name, err)
+		}
+		if jump != nil {
+			if target > ip {
+				if other, ok := forward[target]; ok {
+					merged, ok := merge(other, *jump)
+					if !ok {
+						return false, fmt.Errorf("offset %d: the stack differs between the paths to it", target)
+					}
+					*jump = merged
+				}
+				forward[target] = *jump
+			} else {
+				merged, ok := merge(reached[target], *jump)
+				if !ok {
+					return false, fmt.Errorf("offset %d: the stack differs between the paths to it", target)
+				}
+				if !merged.equal(reached[target]) {
+					if other, ok := c.back[target]; ok {
+						merged, _ = merge(other, merged)
+					}
+					c.back[target] = merged
+					changed = true
+				}
+			}
+		}
+
+		switch op {
+		case code.OpJump, code.OpReturnValue, code.OpReturn:
+			falls = false
+		default:
+			falls = true
+		}
+		ip += 1 + read
+	}
+}
+
+// step applies op to s. For jumps, it returns the state the jump brings
+// to its target, which differs from the one it falls through with for
+// some of them.
+func (c *stackChecker) step(s *state, op code.Opcode, operands []int) (*state, int, error) {
+	// peek checks that the top of the stack is of kind want, or of any
+	// kind for 0.
+	peek := func(want kind) error {
+		if len(s.stack) == 0 {
+			return fmt.Errorf("the stack is empty")
+		}
+		if top := s.stack[len(s.stack)-1]; want != 0 && top != want {
+			return fmt.Errorf("found %s on the stack instead of %s", top, want)
+		}
+		return nil
+	}
+	pop := func(want kind) error {
+		if err := peek(want); err != nil {
+			return err
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+		return nil
+	}
+	popValues := func(n int) error {
+		for i := 0; i < n; i++ {
+			if err := pop(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	push := func(k kind) {
+		s.stack = append(s.stack, k)
+	}
+	jump := func() (*state, int, error) {
+		j := s.copy()
+		return &j, operands[0], nil
+	}
+
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal:
+		push(value)
+
+	case code.OpPop:
+		return nil, 0, pop(0)
+
+	case code.OpDup, code.OpDup2:
+		n := 1
+		if op == code.OpDup2 {
+			n = 2
+		}
+		if len(s.stack) < n {
+			return nil, 0, fmt.Errorf("the stack holds fewer than %d values", n)
+		}
+		s.stack = append(s.stack, s.stack[len(s.stack)-n:]...)
+
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpFloorDiv,
+		code.OpMod, code.OpPow, code.OpBitAnd, code.OpBitOr, code.OpBitXor,
+		code.OpShl, code.OpShr, code.OpEqual, code.OpNotEqual,
+		code.OpLessThan, code.OpLessEqual, code.OpGreaterThan,
+		code.OpGreaterEqual, code.OpIndex:
+		if err := popValues(2); err != nil {
+			return nil, 0, err
+		}
+		push(value)
+
+	case code.OpMinus, code.OpBang, code.OpBitNot, code.OpIncrement,
+		code.OpDecrement, code.OpMember:
+		if err := popValues(1); err != nil {
+			return nil, 0, err
+		}
+		push(value)
+
+	case code.OpJump:
+		return jump()
+
+	case code.OpJumpNotTruthy, code.OpJumpTruthy:
+		if err := pop(value); err != nil {
+			return nil, 0, err
+		}
+		return jump()
+
+	case code.OpJumpNull, code.OpJumpNotNull:
+		if err := peek(value); err != nil {
+			return nil, 0, err
+		}
+		j, target, _ := jump()
+		if op == code.OpJumpNotNull {
+			s.stack = s.stack[:len(s.stack)-1]
+		}
+		return j, target, nil
+
+	case code.OpSetGlobal, code.OpAssignGlobal:
+		return nil, 0, pop(value)
+
+	case code.OpGetLocal:
+		if s.locals[operands[0]] != value {
+			return nil, 0, fmt.Errorf("local %d does not always hold a value", operands[0])
+		}
+		push(value)
+
+	case code.OpSetLocal:
+		if err := pop(value); err != nil {
+			return nil, 0, err
+		}
+		s.locals[operands[0]] = value
+
+	case code.OpGetLocalCell, code.OpSetLocalCell, code.OpCaptureLocal:
+		if s.locals[operands[0]] != cell {
+			return nil, 0, fmt.Errorf("local %d does not always hold a cell", operands[0])
+		}
+		switch op {
+		case code.OpGetLocalCell:
+			push(value)
+		case code.OpSetLocalCell:
+			return nil, 0, pop(value)
+		default:
+			push(cell)
+		}
+
+	case code.OpDefineLocalCell:
+		if err := pop(value); err != nil {
+			return nil, 0, err
+		}
+		s.locals[operands[0]] = cell
+
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		if operands[0] >= c.numFree {
+			return nil, 0, fmt.Errorf("free variable %d out of range", operands[0])
+		}
+		switch op {
+		case code.OpGetFree:
+			push(value)
+		case code.OpSetFree:
+			return nil, 0, pop(value)
+		default:
+			push(cell)
+		}
+
+	case code.OpArray:
+		if err := popValues(operands[0]); err != nil {
+			return nil, 0, err
+		}
+		push(value)
+
+	case code.OpHash:
+		if err := popValues(2 * operands[0]); err != nil {
+			return nil, 0, err
+		}
+		push(value)
+
+	case code.OpSetIndex:
+		if err := popValues(3); err != nil {
+			return nil, 0, err
+		}
+		push(value)
+
+	case code.OpSetMember:
+		if err := popValues(2); err != nil {
+			return nil, 0, err
+		}
+		push(value)
+
+	case code.OpCall:
+		if err := popValues(operands[0] + 1); err != nil {
+			return nil, 0, err
+		}
+		push(value)
+
+	case code.OpReturnValue:
+		return nil, 0, pop(value)
+
+	case code.OpReturn:
+
+	case code.OpClosure:
+		for i := 0; i < operands[1]; i++ {
+			if err := pop(cell); err != nil {
+				return nil, 0, err
+			}
+		}
+		push(value)
+
+	case code.OpIter:
+		if err := pop(value); err != nil {
+			return nil, 0, err
+		}
+		push(iterator)
+
+	case code.OpIterNext:
+		if err := peek(iterator); err != nil {
+			return nil, 0, err
+		}
+		j, target, _ := jump()
+		push(value)
+		return j, target, nil
+
+	default:
+		return nil, 0, fmt.Errorf("not supported")
+	}
+
+	return nil, 0, nil
+}
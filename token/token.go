@@ -1,10 +1,45 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
+// Position is a location in a source file. Offset is a byte offset
+// starting at 0, Line and Column start at 1. Column counts characters, not
+// bytes, so it matches what an editor shows for non-ASCII text. The zero
+// Position is not a valid location.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position points somewhere in a source file.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns the position as file:line:column, leaving out the file
+// name when it is not known.
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+	if p.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Token is a lexical token. Pos is the position of its first character and
+// End the position immediately after its last one.
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+	End     Position
 }
 
 const (
@@ -12,10 +47,11 @@ const (
 	EOF     = "EOF"
 
 	// Identifiers + literals
-	IDENT  = "IDENT"
-	INT    = "INT"
-	FLOAT  = "FLOAT"
-	STRING = "STRING"
+	IDENT   = "IDENT"
+	INT     = "INT"
+	FLOAT   = "FLOAT"
+	DECIMAL = "DECIMAL"
+	STRING  = "STRING"
 
 	// Operators
 	ASSIGN    = "="
@@ -33,7 +69,7 @@ const (
 	INC       = "++"
 	DEC       = "--"
 	EXP       = "**"
-	INTDIV    = "//"
+	INTDIV    = "~/"
 	MOD       = "%"
 	SHL       = "<<"
 	SHR       = ">>"
@@ -44,6 +80,7 @@ const (
 	MULASSIGN = "*="
 	DIVASSIGN = "/="
 	NULLCOAL  = "??"
+	OPTCHAIN  = "?."
 	BITAND    = "&"
 	BITOR     = "|"
 	BITXOR    = "^"
@@ -52,33 +89,52 @@ const (
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
+	DOT       = "."
 
-	LPAREN = "("
-	RPAREN = ")"
-	LBRACE = "{"
-	RBRACE = "}"
+	LPAREN   = "("
+	RPAREN   = ")"
+	LBRACE   = "{"
+	RBRACE   = "}"
+	LBRACKET = "["
+	RBRACKET = "]"
 
 	// Strings
 	QUOTE = "\""
 
+	// Comments, only emitted when the lexer is asked to keep them
+	COMMENT = "COMMENT"
+
 	// Keywords
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	NULL     = "NULL"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"null":     NULL,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 // LookupIdent checks the keywords table to see whether the given identifier is
@@ -0,0 +1,22 @@
+package vm
+
+import (
+	"staq/code"
+	"staq/object"
+)
+
+// Frame is the activation of a closure: where it is in its instructions
+// and where its locals start on the stack.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,634 @@
+// Package vm runs the bytecode of the compiler package on a stack machine.
+// Values are the same objects the evaluator uses, and operators are applied
+// by the evaluator's own functions, so programs give the same results and
+// errors with both.
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"staq/code"
+	"staq/compiler"
+	"staq/evaluator"
+	"staq/object"
+)
+
+const (
+	// StackSize is the number of slots a machine starts with. The stack
+	// grows as calls need more, so only MaxFrames limits how deep a
+	// program can call.
+	StackSize   = 2048
+	GlobalsSize = 65536
+	// MaxFrames is the number of nested calls a program can make,
+	// counting the main program, the same for every engine.
+	MaxFrames = evaluator.MaxCallDepth
+)
+
+var (
+	Null  = evaluator.NULL
+	True  = evaluator.TRUE
+	False = evaluator.FALSE
+)
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpFloorDiv:     "~/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShl:          "<<",
+	code.OpShr:          ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLessThan:     "<",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterThan:  ">",
+	code.OpGreaterEqual: ">=",
+}
+
+var prefixOperators = map[code.Opcode]string{
+	code.OpMinus:  "-",
+	code.OpBang:   "!",
+	code.OpBitNot: "~",
+}
+
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+
+	stack []object.Object
+	sp    int // Always points to the next free slot. Top of stack is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+}
+
+// New returns a machine ready to run bytecode.
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		NumLocals:    bytecode.NumLocals,
+		Lines:        bytecode.Lines,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+
+		stack: make([]object.Object, StackSize),
+		sp:    bytecode.NumLocals,
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobalsStore returns a machine that keeps its globals in s, so
+// that they survive from one REPL line to the next.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// LastPoppedStackElem returns the value of the last expression statement
+// that ran, or of a return statement at the top level.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// Run executes the main program until it ends or fails. Runtime errors are
+// returned with the message the evaluator gives them, as a
+// code.RuntimeError with the line of the instruction that failed.
+func (vm *VM) Run() (err error) {
+	vm.reserve(vm.sp + 1)
+
+	defer func() {
+		if err != nil {
+			frame := vm.currentFrame()
+			err = code.AtLine(err, frame.cl.Fn.Lines.Line(frame.ip))
+		}
+	}()
+
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpDup:
+			if err := vm.push(vm.stack[vm.sp-1]); err != nil {
+				return err
+			}
+
+		case code.OpDup2:
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(True); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(False); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpFloorDiv,
+			code.OpMod, code.OpPow, code.OpBitAnd, code.OpBitOr, code.OpBitXor,
+			code.OpShl, code.OpShr, code.OpEqual, code.OpNotEqual,
+			code.OpLessThan, code.OpLessEqual, code.OpGreaterThan,
+			code.OpGreaterEqual:
+			if err := vm.executeBinaryOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpMinus, code.OpBang, code.OpBitNot:
+			operand := vm.pop()
+			if err := vm.pushResult(evaluator.Prefix(prefixOperators[op], operand)); err != nil {
+				return err
+			}
+
+		case code.OpIncrement, code.OpDecrement:
+			postfix := code.ReadUint8(ins[ip+1:]) == 1
+			vm.currentFrame().ip += 1
+
+			operator := "++"
+			if op == code.OpDecrement {
+				operator = "--"
+			}
+			current := vm.pop()
+			if err := vm.pushResult(evaluator.Increment(operator, postfix, current)); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy, code.OpJumpTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if object.IsTruthy(condition) == (op == code.OpJumpTruthy) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpJumpNull:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.stack[vm.sp-1] == Null {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpJumpNotNull:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.stack[vm.sp-1] != Null {
+				vm.currentFrame().ip = pos - 1
+			} else {
+				vm.pop()
+			}
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.globals[globalIndex]
+			if value == nil {
+				return fmt.Errorf("identifier not found: %s", vm.globalNames[globalIndex])
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpAssignGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if vm.globals[globalIndex] == nil {
+				return fmt.Errorf("assignment to undeclared variable: %s", vm.globalNames[globalIndex])
+			}
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.stack[vm.currentFrame().basePointer+int(localIndex)]); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.stack[vm.currentFrame().basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocalCell:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			cell := vm.stack[vm.currentFrame().basePointer+int(localIndex)].(*object.Cell)
+			if err := vm.push(cell.Value); err != nil {
+				return err
+			}
+
+		case code.OpSetLocalCell:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			cell := vm.stack[vm.currentFrame().basePointer+int(localIndex)].(*object.Cell)
+			cell.Value = vm.pop()
+
+		case code.OpDefineLocalCell:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.stack[vm.currentFrame().basePointer+int(localIndex)] = &object.Cell{Value: vm.pop()}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.push(vm.currentFrame().cl.Free[freeIndex].Value); err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			vm.currentFrame().cl.Free[freeIndex].Value = vm.pop()
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(&object.Array{Elements: elements}); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numPairs := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-2*numPairs, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - 2*numPairs
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.pushResult(evaluator.Index(left, index)); err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.pushResult(evaluator.SetIndex(left, index, value)); err != nil {
+				return err
+			}
+
+		case code.OpMember:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			name := vm.constants[nameIndex].(*object.String).Value
+			obj := vm.pop()
+			if err := vm.pushResult(evaluator.Member(obj, name)); err != nil {
+				return err
+			}
+
+		case code.OpSetMember:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			name := vm.constants[nameIndex].(*object.String).Value
+			value := vm.pop()
+			obj := vm.pop()
+			if err := vm.pushResult(evaluator.SetMember(obj, name, value)); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.callFunction(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue, code.OpReturn:
+			returnValue := object.Object(Null)
+			if op == code.OpReturnValue {
+				returnValue = vm.pop()
+			}
+
+			if vm.framesIndex == 1 {
+				// A return statement at the top level ends the program,
+				// with the returned value as its result.
+				vm.stack[vm.sp] = returnValue
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.stack[vm.currentFrame().basePointer+int(localIndex)]); err != nil {
+				return err
+			}
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.push(vm.currentFrame().cl.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpIter:
+			elements, err := evaluator.Iterate(vm.pop())
+			if err != nil {
+				return errors.New(err.Message)
+			}
+			if err := vm.push(&iterator{elements: elements}); err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			iter := vm.stack[vm.sp-1].(*iterator)
+			if iter.next == len(iter.elements) {
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+			element := iter.elements[iter.next]
+			iter.next++
+			if err := vm.push(element); err != nil {
+				return err
+			}
+
+		default:
+			def, err := code.Lookup(byte(op))
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("opcode %s not supported", def.Name)
+		}
+	}
+
+	return nil
+}
+
+// executeBinaryOperation applies an infix operator. Integer arithmetic
+// and comparisons that cannot overflow are done right here, since they
+// are by far the most common; everything else is left to the evaluator.
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			if result := executeIntegerOperation(op, l.Value, r.Value); result != nil {
+				return vm.push(result)
+			}
+		}
+	}
+
+	return vm.pushResult(evaluator.Infix(infixOperators[op], left, right))
+}
+
+// executeIntegerOperation returns nil when the result needs the checks of
+// the evaluator, e.g. for overflows.
+func executeIntegerOperation(op code.Opcode, l, r int64) object.Object {
+	switch op {
+	case code.OpAdd:
+		if sum := l + r; (sum > l) == (r > 0) {
+			return &object.Integer{Value: sum}
+		}
+	case code.OpSub:
+		if diff := l - r; (diff < l) == (r > 0) {
+			return &object.Integer{Value: diff}
+		}
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(l == r)
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(l != r)
+	case code.OpLessThan:
+		return nativeBoolToBooleanObject(l < r)
+	case code.OpLessEqual:
+		return nativeBoolToBooleanObject(l <= r)
+	case code.OpGreaterThan:
+		return nativeBoolToBooleanObject(l > r)
+	case code.OpGreaterEqual:
+		return nativeBoolToBooleanObject(l >= r)
+	}
+	return nil
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hash := object.NewHash()
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		hash.Set(hashKey, value)
+	}
+
+	return hash, nil
+}
+
+func (vm *VM) callFunction(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		return fmt.Errorf("not a function: %s", vm.stack[vm.sp-1-numArgs].Type())
+	}
+
+	fn := cl.Fn
+	if numArgs != len(fn.Parameters) {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			len(fn.Parameters), numArgs)
+	}
+	if vm.framesIndex == MaxFrames {
+		return errors.New("stack overflow")
+	}
+	vm.reserve(vm.sp - numArgs + fn.NumLocals + 1)
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	vm.pushFrame(frame)
+
+	// Clear the slots of the other locals, which may hold values of a
+	// previous call.
+	locals := vm.stack[frame.basePointer+numArgs : frame.basePointer+fn.NumLocals]
+	for i := range locals {
+		locals[i] = nil
+	}
+	for _, i := range fn.CellParameters {
+		vm.stack[frame.basePointer+i] = &object.Cell{Value: vm.stack[frame.basePointer+i]}
+	}
+
+	vm.sp = frame.basePointer + fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]*object.Cell, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i].(*object.Cell)
+	}
+	vm.sp = vm.sp - numFree
+
+	return vm.push(&object.Closure{Fn: function, Free: free})
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// reserve makes the stack at least n slots long, doubling it as often as
+// needed.
+func (vm *VM) reserve(n int) {
+	if n <= len(vm.stack) {
+		return
+	}
+	size := len(vm.stack)
+	for size < n {
+		size *= 2
+	}
+	stack := make([]object.Object, size)
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+}
+
+func (vm *VM) push(o object.Object) error {
+	vm.reserve(vm.sp + 2)
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// pushResult pushes the result of an operator, unless it is an error.
+func (vm *VM) pushResult(o object.Object) error {
+	if err, ok := o.(*object.Error); ok {
+		return errors.New(err.Message)
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
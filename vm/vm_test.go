@@ -0,0 +1,157 @@
+package vm
+
+import (
+	"errors"
+	"staq/ast"
+	"staq/code"
+	"staq/compiler"
+	"staq/conformance"
+	"staq/evaluator"
+	"staq/lexer"
+	"staq/object"
+	"staq/parser"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	conformance.Run(t, func(program *ast.Program) string {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		machine := New(comp.Bytecode())
+		return result(machine, machine.Run())
+	})
+}
+
+func TestStackOverflow(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn() { f() }; f()")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	machine := New(comp.Bytecode())
+	if got := result(machine, machine.Run()); got != "ERROR: stack overflow" {
+		t.Errorf("wrong result. want=%q, got=%q", "ERROR: stack overflow", got)
+	}
+}
+
+func TestErrorLines(t *testing.T) {
+	input := `let f = fn(x) {
+	x + true
+};
+f(1);
+1 / 0`
+	tests := []struct {
+		input string
+		line  int
+	}{
+		{input, 2},
+		{"1;\n\n1 / 0", 3},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		var runtimeErr *code.RuntimeError
+		if err := New(comp.Bytecode()).Run(); !errors.As(err, &runtimeErr) {
+			t.Errorf("%q: expected a runtime error, got %v", tt.input, err)
+		} else if runtimeErr.Line != tt.line {
+			t.Errorf("%q: wrong line. want=%d, got=%d", tt.input, tt.line, runtimeErr.Line)
+		}
+	}
+}
+
+func TestGlobalsSurviveRuns(t *testing.T) {
+	globals := make([]object.Object, GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+
+	lines := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;", ""},
+		{"let f = fn() { a + 1 };", ""},
+		{"a = f(); a", "2"},
+		{"b", "ERROR: identifier not found: b"},
+		{"let b = a * 3; b", "6"},
+	}
+
+	for _, tt := range lines {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := NewWithGlobalsStore(bytecode, globals)
+		err := machine.Run()
+		if tt.expected == "" {
+			if err != nil {
+				t.Fatalf("vm error for %q: %s", tt.input, err)
+			}
+			continue
+		}
+		if got := result(machine, err); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func result(machine *VM, err error) string {
+	if err != nil {
+		return "ERROR: " + err.Error()
+	}
+	return machine.LastPoppedStackElem().Inspect()
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+// fibonacci is the recursive function from the README.
+const fibonacci = `
+let fibonacci = fn(x) {
+    if (x == 0) {
+        0;
+    } else {
+        if (x == 1) {
+            1;
+        } else {
+            fibonacci(x - 1) + fibonacci(x - 2);
+        }
+    }
+};
+fibonacci(30);
+`
+
+func BenchmarkFibonacciVM(b *testing.B) {
+	program := parse(fibonacci)
+
+	for i := 0; i < b.N; i++ {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			b.Fatalf("compiler error: %s", err)
+		}
+		machine := New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			b.Fatalf("vm error: %s", err)
+		}
+	}
+}
+
+func BenchmarkFibonacciEvaluator(b *testing.B) {
+	program := parse(fibonacci)
+
+	for i := 0; i < b.N; i++ {
+		evaluator.Eval(program, object.NewEnvironment())
+	}
+}